@@ -3,10 +3,13 @@ package client
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"github.com/lucas-clemente/quic-go"
 	"io"
 	"learn/irpc/common"
+	"learn/irpc/config"
 	"learn/irpc/service"
+	"sync"
 	"time"
 )
 
@@ -18,6 +21,10 @@ type IrpcClient struct {
 	dialAddr  string
 	conn      quic.Connection
 	requester *QuicAdapter
+	// 第一次请求用户服务之前握手，检查两端model kindID是否一致
+	handshakeMu  sync.Mutex
+	handshaked   bool
+	handshakeErr error
 }
 
 const (
@@ -44,35 +51,71 @@ func NewIrpcClient(ctx context.Context, cc *StreamCodec, mgr *service.Mgr, tlsCo
 
 // Call 根据服务名、方法名以及参数去请求
 func (c *IrpcClient) Call(srvName, methodName string, params ...interface{}) ([]interface{}, error) {
+	return c.CallContext(c.ctx, srvName, methodName, params...)
+}
+
+// CallContext 与Call相同。ctx带有deadline时作为本次请求的超时时间，带有WithVersion时请求对应版本的服务
+func (c *IrpcClient) CallContext(ctx context.Context, srvName, methodName string, params ...interface{}) ([]interface{}, error) {
 	// 根据srvName、methodName获取相应编号
 	// 为什么不使得Mgr Invoke参数为srvName, methodName呢？反正srvName、methodName获取id也要通过Mgr啊
 	// 错了，这是要传递id到服务端啊
-	srvID, mid, err := c.mgr.GetSrvMethodID(srvName, methodName)
+	srvID, mid, err := c.mgr.GetSrvMethodID(versionedName(ctx, srvName), methodName)
 	if err != nil {
 		return nil, err
 	}
 
-	// 构造请求
-	req, err := c.constructReq(srvID, mid, params...)
+	// 根据methodID获取输入、输出参数类型描述
+	inDescs, outDescs, err := c.mgr.GetTypeDescsByMethod(srvID, mid)
 	if err != nil {
 		return nil, err
 	}
 
-	// 编码请求
-	encodeReq, err := c.cc.EncodeToRequest(req)
+	return c.CallByID(ctx, srvID, mid, inDescs, outDescs, params...)
+}
+
+// CallByID 根据服务、方法编号以及出入参数类型描述请求，供没有编译期类型的通用工具使用
+// 通用工具可以通过common.NewTypeDescs由kindID序列构建类型描述，结构体需要已经放入parser的Models中
+func (c *IrpcClient) CallByID(ctx context.Context, srvID common.SrvID, mid common.MethodID, inDescs, outDescs []*common.TypeDesc, params ...interface{}) ([]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// 内置服务的model两端总是一致，不需要握手
+	if srvID < service.ReservedSrvIDStart {
+		if err := c.Handshake(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	// 构造请求。ctx中指定了编码格式时优先使用
+	ct := contentTypeFromContext(ctx, c.cc.ContentType())
+	req, err := c.constructReq(srvID, mid, ct, inDescs, params...)
 	if err != nil {
 		return nil, err
 	}
 
-	// 发送请求
-	respReader, err := c.requester.Request(encodeReq)
+	// 编码请求，body已经复制到frame中，可以复用
+	frame, err := c.cc.EncodeFrame(req)
+	common.PutBuffer(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// 发送请求，frame一次写入stream，写入之后可以复用
+	respReader, err := c.requester.RequestContext(ctx, frame)
+	common.PutBuffer(frame)
 	if err != nil {
 		return nil, err
 	}
 
 	// 获取响应内容并解析
-	resp, err := c.parseResp(respReader, srvID, mid)
+	resp, err := c.parseResp(respReader, ct, outDescs)
 	if err != nil {
+		// 错误状态以及过大的响应已经完整读取，stream仍然可以继续使用
+		var se *common.StatusError
+		if errors.As(err, &se) || errors.Is(err, common.ErrMessageTooLarge) {
+			respReader.Close()
+		}
 		return nil, err
 	}
 
@@ -85,7 +128,34 @@ func (c *IrpcClient) Call(srvName, methodName string, params ...interface{}) ([]
 	return resp, nil
 }
 
-func (c *IrpcClient) parseResp(reader io.Reader, srvID common.SrvID, mid common.MethodID) ([]interface{}, error) {
+// Handshake 通过反射服务获取server的schema，同名model的kindID不一致时返回service.ErrSchemaMismatch
+// 第一次请求用户服务时自动调用。schema不一致的结果会被记录，网络错误则在下次请求时重试
+func (c *IrpcClient) Handshake(ctx context.Context) error {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+	if c.handshaked {
+		return c.handshakeErr
+	}
+
+	srvID, mid, err := c.mgr.GetSrvMethodID(service.ReflectionServiceName, service.ReflectionSchemaMethod)
+	if err != nil {
+		return err
+	}
+	inDescs, outDescs, err := c.mgr.GetTypeDescsByMethod(srvID, mid)
+	if err != nil {
+		return err
+	}
+	r, err := c.CallByID(ctx, srvID, mid, inDescs, outDescs)
+	if err != nil {
+		return err
+	}
+
+	c.handshaked = true
+	c.handshakeErr = c.mgr.CheckSchema(r[0].(service.ServerSchema))
+	return c.handshakeErr
+}
+
+func (c *IrpcClient) parseResp(reader io.Reader, ct common.ContentType, outDescs []*common.TypeDesc) ([]interface{}, error) {
 	// 解析响应
 	// server 写入了正确的response，可是在最后主动断开了该连接。这导致response根本没有返回
 	// 问题在于请求过程中即使超过了时间，那么也不应该断开连接
@@ -94,19 +164,15 @@ func (c *IrpcClient) parseResp(reader io.Reader, srvID common.SrvID, mid common.
 	if err != nil {
 		return nil, err
 	}
+	// 结果已经解析为独立的值，body可以复用
+	defer common.PutBuffer(response.Body)
 
-	// 根据methodID获取输出参数kindIDs
-	_, outKids := c.mgr.GetKindIDsByMethod(srvID, mid)
-
-	return c.cc.ParseResponseBody(response.Body, outKids)
+	return c.cc.ParseResponseBody(ct, response.Body, outDescs)
 }
 
-func (c *IrpcClient) constructReq(srvID common.SrvID, mid common.MethodID, params ...interface{}) (*common.Request, error) {
-	// 根据methodID获取输入参数kindIDs
-	inKids, _ := c.mgr.GetKindIDsByMethod(srvID, mid)
-
+func (c *IrpcClient) constructReq(srvID common.SrvID, mid common.MethodID, ct common.ContentType, inDescs []*common.TypeDesc, params ...interface{}) (*common.Request, error) {
 	// 构造请求body
-	body, err := c.cc.EncodeBody(inKids, params...)
+	body, err := c.cc.EncodeBody(ct, inDescs, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -115,9 +181,36 @@ func (c *IrpcClient) constructReq(srvID common.SrvID, mid common.MethodID, param
 		Header: common.ReqHeader{
 			SID: srvID,
 			MID: mid,
+			CT:  ct,
 		},
 		Body: body,
 	}
 
 	return req, nil
 }
+
+type contentTypeKey struct{}
+
+// WithContentType 指定单次请求的编码格式，例如protobuf只用于部分方法
+func WithContentType(ctx context.Context, ct common.ContentType) context.Context {
+	return context.WithValue(ctx, contentTypeKey{}, ct)
+}
+
+func contentTypeFromContext(ctx context.Context, def common.ContentType) common.ContentType {
+	if ct, ok := ctx.Value(contentTypeKey{}).(common.ContentType); ok {
+		return ct
+	}
+	return def
+}
+
+type versionKey struct{}
+
+// WithVersion 指定单次请求的服务版本，服务名为config.VersionedName(srvName, version)
+func WithVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, versionKey{}, version)
+}
+
+func versionedName(ctx context.Context, srvName string) string {
+	version, _ := ctx.Value(versionKey{}).(string)
+	return config.VersionedName(srvName, version)
+}
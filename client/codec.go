@@ -2,59 +2,247 @@ package client
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"learn/irpc/codec"
 	common2 "learn/irpc/common"
+	"sync/atomic"
 )
 
+var (
+	ErrUnsupportedContentType = fmt.Errorf("irpcClient codec: %w", common2.ErrUnsupportedContentType)
+)
+
+// StreamCodec 默认使用二进制格式，可以通过SetContentType修改该client所有请求的格式
 type StreamCodec struct {
-	parser *common2.Parser
+	codecs map[common2.ContentType]codec.Codec
+	ct     common2.ContentType
+	// compression 为nil时不压缩也不接受压缩
+	compression *codec.CompressionConfig
+	// peerAccept server可以解压的算法以及协议版本，从响应header得知。收到第一个响应之前请求不压缩，使用v1格式
+	peerAccept atomic.Uint32
+	// protocol 最高使用的协议版本
+	protocol common2.ProtocolVersion
+	// 请求、响应body的最大长度，不大于0时使用common.DefaultMaxMessageSize
+	maxRequestSize  int
+	maxResponseSize int
 }
 
 func NewStreamCodec(parser *common2.Parser) *StreamCodec {
-	return &StreamCodec{parser: parser}
+	return &StreamCodec{
+		codecs:      codec.NewCodecs(parser),
+		ct:          common2.ContentTypeBinary,
+		compression: codec.DefaultCompressionConfig(),
+		protocol:    common2.ProtocolV2,
+	}
+}
+
+// SetProtocolVersion 设置最高使用的协议版本，实际使用与server协商的版本。需要在请求之前调用
+func (c *StreamCodec) SetProtocolVersion(v common2.ProtocolVersion) {
+	c.protocol = v
+}
+
+// SetCompression 设置压缩配置，为nil时关闭压缩。需要在请求之前调用
+func (c *StreamCodec) SetCompression(cfg *codec.CompressionConfig) {
+	c.compression = cfg
+}
+
+// SetMaxMessageSize 设置请求、响应body的最大长度，不大于0时使用默认值。需要在请求之前调用
+func (c *StreamCodec) SetMaxMessageSize(maxRequestSize, maxResponseSize int) {
+	c.maxRequestSize = maxRequestSize
+	c.maxResponseSize = maxResponseSize
+}
+
+func maxMessageSize(size int) int {
+	if size <= 0 {
+		return common2.DefaultMaxMessageSize
+	}
+	return size
+}
+
+func (c *StreamCodec) compressionConfig() *codec.CompressionConfig {
+	if c.compression == nil {
+		return &codec.CompressionConfig{}
+	}
+	return c.compression
+}
+
+// RegisterCodec 注册或替换Codec，需要在请求之前调用
+func (c *StreamCodec) RegisterCodec(cd codec.Codec) {
+	c.codecs[cd.ContentType()] = cd
+}
+
+// SetContentType 设置默认编码格式，需要在请求之前调用
+func (c *StreamCodec) SetContentType(ct common2.ContentType) error {
+	if _, ok := c.codecs[ct]; !ok {
+		return ErrUnsupportedContentType
+	}
+	c.ct = ct
+	return nil
+}
+
+// ContentType 默认编码格式
+func (c *StreamCodec) ContentType() common2.ContentType {
+	return c.ct
+}
+
+func (c *StreamCodec) getCodec(ct common2.ContentType) (codec.Codec, error) {
+	cd, ok := c.codecs[ct]
+	if !ok {
+		return nil, ErrUnsupportedContentType
+	}
+	return cd, nil
 }
 
+// EncodeToRequest 返回完整的请求frame
 func (c *StreamCodec) EncodeToRequest(req *common2.Request) ([]byte, error) {
-	// json marshal content
-	contentLen := len(req.Body)
-	r := make([]byte, 2+1+4+contentLen)
+	header, body, err := c.encodeRequest(req)
+	if err != nil {
+		return nil, err
+	}
 
-	// 编码srvID
-	binary.BigEndian.PutUint16(r[:2], uint16(req.Header.SID))
+	r := make([]byte, 0, len(header)+len(body))
+	r = append(r, header...)
+	return append(r, body...), nil
+}
 
-	// 编码mID
-	r[2] = byte(req.Header.MID)
+// EncodeFrame 与EncodeToRequest相同，frame来自buffer池，可以通过一次Write写入stream，写入之后通过common.PutBuffer放回
+// quic.Stream没有vectored write，header与body分开写入会产生两次stream写入
+func (c *StreamCodec) EncodeFrame(req *common2.Request) ([]byte, error) {
+	header, body, err := c.encodeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := common2.GetBuffer(len(header) + len(body))[:0]
+	frame = append(frame, header...)
+	return append(frame, body...), nil
+}
+
+// encodeRequest 按照server支持的算法压缩body，返回frame header以及body
+// server支持v2时使用v2格式，否则方法编号超过common.MaxV1MethodID时返回common.ErrProtocolUnsupported
+func (c *StreamCodec) encodeRequest(req *common2.Request) ([]byte, []byte, error) {
+	peerAccept, peerVersion := common2.DecodeAccept(byte(c.peerAccept.Load()))
+	version := c.protocol
+	if peerVersion < version {
+		version = peerVersion
+	}
+	if version < common2.ProtocolV2 && req.Header.MID > common2.MaxV1MethodID {
+		return nil, nil, fmt.Errorf("%w: method id %d needs protocol v2", common2.ErrProtocolUnsupported, req.Header.MID)
+	}
+
+	cfg := c.compressionConfig()
+	body, comp, err := cfg.Compress(req.Body, peerAccept)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contentLen := len(body)
+	if contentLen > maxMessageSize(c.maxRequestSize) {
+		return nil, nil, fmt.Errorf("%w: request %d bytes", common2.ErrMessageTooLarge, contentLen)
+	}
+
+	header := make([]byte, common2.RequestHeaderLen, common2.RequestHeaderLen+2*binary.MaxVarintLen16)
+	if version < common2.ProtocolV2 {
+		// 编码srvID
+		binary.BigEndian.PutUint16(header[:2], uint16(req.Header.SID))
+
+		// 编码mID
+		header[2] = byte(req.Header.MID)
+	}
+
+	// 编码content type
+	header[3] = byte(req.Header.CT)
+
+	// 编码压缩算法以及本端可以解压的算法，最高位标记v2格式
+	header[4] = byte(comp)
+	header[5] = common2.EncodeAccept(cfg.Accept(), version)
 
 	// 编码content len
-	binary.BigEndian.PutUint32(r[3:3+4], uint32(contentLen))
+	binary.BigEndian.PutUint32(header[6:10], uint32(contentLen))
 
-	// 编码content
-	copy(r[7:], req.Body)
+	// v2的srvID、mID紧跟header
+	if version >= common2.ProtocolV2 {
+		header = binary.AppendUvarint(header, uint64(req.Header.SID))
+		header = binary.AppendUvarint(header, uint64(req.Header.MID))
+	}
 
-	return r, nil
+	return header, body, nil
 }
 
-func (c *StreamCodec) EncodeBody(kids []common2.KindID, params ...interface{}) ([]byte, error) {
-	return c.parser.EncodeBody(kids, params...)
+// EncodeBody 编码结果来自buffer池，请求写入之后可以通过common.PutBuffer放回
+func (c *StreamCodec) EncodeBody(ct common2.ContentType, descs []*common2.TypeDesc, params ...interface{}) ([]byte, error) {
+	cd, err := c.getCodec(ct)
+	if err != nil {
+		return nil, err
+	}
+	buf := common2.GetBuffer(0)
+	body, err := codec.AppendBody(cd, buf, descs, params...)
+	if err != nil {
+		common2.PutBuffer(buf)
+		return nil, err
+	}
+	return body, nil
 }
 
+// ReadResponse 响应header为状态、压缩算法、server可以解压的算法以及内容长度
+// 状态不是StatusOK时返回*common.StatusError。响应超过最大长度时丢弃内容并返回common.ErrMessageTooLarge
+// 返回的Body来自buffer池，解析完毕后可以通过common.PutBuffer放回
 func (c *StreamCodec) ReadResponse(reader io.Reader) (*common2.Response, error) {
-	var contentLen uint32
-	err := binary.Read(reader, binary.BigEndian, &contentLen)
+	var header [common2.ResponseHeaderLen]byte
+	_, err := io.ReadFull(reader, header[:])
 	if err != nil {
 		return nil, err
 	}
+	status, comp := common2.StatusCode(header[0]), common2.Compression(header[1])
+	contentLen := binary.BigEndian.Uint32(header[3:7])
+	if int64(contentLen) > int64(maxMessageSize(c.maxResponseSize)) {
+		_, err = io.CopyN(io.Discard, reader, int64(contentLen))
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: response %d bytes", common2.ErrMessageTooLarge, contentLen)
+	}
 
-	body := make([]byte, contentLen)
-	_, err = reader.Read(body)
+	// stream上单次Read可能只返回部分内容
+	body := common2.GetBuffer(int(contentLen))
+	_, err = io.ReadFull(reader, body)
 	if err != nil {
+		common2.PutBuffer(body)
 		return nil, err
 	}
 
+	// 之后的请求按照server支持的算法压缩，server支持时使用v2格式
+	c.peerAccept.Store(uint32(header[2]))
+
+	if status != common2.StatusOK {
+		msg := string(body)
+		common2.PutBuffer(body)
+		return nil, &common2.StatusError{Code: status, Message: msg}
+	}
+
+	if comp != common2.CompressionNone {
+		raw := body
+		body, err = c.compressionConfig().DecompressLimit(raw, comp, maxMessageSize(c.maxResponseSize))
+		common2.PutBuffer(raw)
+		if errors.Is(err, codec.ErrExceedDecompressedSize) {
+			return nil, fmt.Errorf("%w: response %s", common2.ErrMessageTooLarge, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	res := &common2.Response{Body: body}
 	return res, nil
 }
 
-func (c *StreamCodec) ParseResponseBody(body []byte, kids []common2.KindID) ([]interface{}, error) {
-	return c.parser.ParseBody(body, kids)
+// ParseResponseBody 响应与请求使用相同的编码格式
+func (c *StreamCodec) ParseResponseBody(ct common2.ContentType, body []byte, descs []*common2.TypeDesc) ([]interface{}, error) {
+	cd, err := c.getCodec(ct)
+	if err != nil {
+		return nil, err
+	}
+	return cd.ParseBody(body, descs)
 }
@@ -16,6 +16,7 @@ type StreamConn interface {
 	io.Reader
 	io.Writer
 	io.Closer
+	SetDeadline(t time.Time) error
 }
 
 const (
@@ -257,6 +258,12 @@ func (sc *AdapterStreamConn) Close() error {
 	// 在先close的情况下，acquire没有得到最新，就会创建多余的stream
 	// 在tryGetStream中加锁也是很有可能在间隙中创建多余的stream，但不会超过最大限制
 
+	// 清除本次请求设置的deadline，避免影响stream复用
+	err := sc.si.stream.SetDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+
 	sc.ci.rwMutex.Lock()
 	sc.si.flag.Store(idle)
 	if !sc.ci.existsAvailableStream() {
@@ -274,3 +281,7 @@ func (sc *AdapterStreamConn) Read(p []byte) (n int, err error) {
 func (sc *AdapterStreamConn) Write(p []byte) (n int, err error) {
 	return sc.si.stream.Write(p)
 }
+
+func (sc *AdapterStreamConn) SetDeadline(t time.Time) error {
+	return sc.si.stream.SetDeadline(t)
+}
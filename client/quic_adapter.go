@@ -1,7 +1,9 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
+	"io"
 	"time"
 )
 
@@ -21,13 +23,33 @@ func NewQuicAdapter(tlsConfig *tls.Config, dialAddr string, expireDuration, scan
 }
 
 func (a *QuicAdapter) Request(b []byte) (StreamConn, error) {
+	return a.RequestContext(context.Background(), b)
+}
+
+// RequestContext ctx带有deadline时设置到stream上，读写响应超时都会返回错误
+func (a *QuicAdapter) RequestContext(ctx context.Context, b []byte) (StreamConn, error) {
+	return a.RequestFunc(ctx, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// RequestFunc 与RequestContext相同，由write向stream写入请求。write中每次Write都是一次stream写入
+func (a *QuicAdapter) RequestFunc(ctx context.Context, write func(w io.Writer) error) (StreamConn, error) {
 	streamConn, err := a.ac.AcquireStream()
 	if err != nil {
 		return nil, err
 	}
 
+	if deadline, ok := ctx.Deadline(); ok {
+		err = streamConn.SetDeadline(deadline)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// write bytes in open stream
-	_, err = streamConn.Write(b)
+	err = write(streamConn)
 	if err != nil {
 		return nil, err
 	}
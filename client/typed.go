@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"learn/irpc/common"
+	"reflect"
+)
+
+var (
+	ErrTypeMismatch = errors.New("irpcClient typed: type mismatch")
+)
+
+// Invoke 调用方法并将唯一的结果转换为Resp。参数、结果类型与注册的方法不一致时返回ErrTypeMismatch而不是panic
+// 方法没有返回值时Resp使用struct{}。ctx带有WithVersion时请求对应版本的服务
+// 可变参数与go调用相同，可以逐个传入元素，也可以传入对应类型的slice
+func Invoke[Resp any](ctx context.Context, c *IrpcClient, srvName, methodName string, args ...interface{}) (Resp, error) {
+	var resp Resp
+	srvName = versionedName(ctx, srvName)
+	srvID, mid, err := c.mgr.GetSrvMethodID(srvName, methodName)
+	if err != nil {
+		return resp, err
+	}
+
+	in, out, err := c.mgr.GetMethodTypes(srvID, mid)
+	if err != nil {
+		return resp, err
+	}
+	h, err := c.mgr.GetHandler(srvID, mid)
+	if err != nil {
+		return resp, err
+	}
+	if h.Variadic() {
+		args, err = variadicArgs(srvName, methodName, in, args)
+		if err != nil {
+			return resp, err
+		}
+	}
+	err = checkArgs(srvName, methodName, in, args)
+	if err != nil {
+		return resp, err
+	}
+	err = checkResp[Resp](srvName, methodName, out)
+	if err != nil {
+		return resp, err
+	}
+
+	inDescs, outDescs, err := c.mgr.GetTypeDescsByMethod(srvID, mid)
+	if err != nil {
+		return resp, err
+	}
+	rs, err := c.CallByID(ctx, srvID, mid, inDescs, outDescs, args...)
+	if err != nil {
+		return resp, err
+	}
+
+	return convertResp[Resp](srvName, methodName, rs)
+}
+
+// MethodHandle 类型安全的方法句柄，创建时检查类型，之后调用不再检查
+type MethodHandle[Req, Resp any] struct {
+	c        *IrpcClient
+	srvName  string
+	name     string
+	srvID    common.SrvID
+	mid      common.MethodID
+	inDescs  []*common.TypeDesc
+	outDescs []*common.TypeDesc
+	noParams bool
+}
+
+// Method 创建方法句柄。方法需要恰好一个Req类型参数(没有参数时Req使用struct{})，
+// 恰好一个Resp类型结果(没有结果时Resp使用struct{})
+func Method[Req, Resp any](c *IrpcClient, srvName, methodName string) (*MethodHandle[Req, Resp], error) {
+	srvID, mid, err := c.mgr.GetSrvMethodID(srvName, methodName)
+	if err != nil {
+		return nil, err
+	}
+
+	in, out, err := c.mgr.GetMethodTypes(srvID, mid)
+	if err != nil {
+		return nil, err
+	}
+
+	reqType := reflect.TypeOf((*Req)(nil)).Elem()
+	noParams := len(in) == 0 && reqType == emptyStructType
+	if !noParams && (len(in) != 1 || in[0] != reqType) {
+		return nil, fmt.Errorf("%w: %s.%s params %v, handle req %s", ErrTypeMismatch, srvName, methodName, in, reqType)
+	}
+	err = checkResp[Resp](srvName, methodName, out)
+	if err != nil {
+		return nil, err
+	}
+
+	inDescs, outDescs, err := c.mgr.GetTypeDescsByMethod(srvID, mid)
+	if err != nil {
+		return nil, err
+	}
+	return &MethodHandle[Req, Resp]{
+		c:        c,
+		srvName:  srvName,
+		name:     methodName,
+		srvID:    srvID,
+		mid:      mid,
+		inDescs:  inDescs,
+		outDescs: outDescs,
+		noParams: noParams,
+	}, nil
+}
+
+// Call 调用方法
+func (h *MethodHandle[Req, Resp]) Call(ctx context.Context, req Req) (Resp, error) {
+	var rs []interface{}
+	var err error
+	if h.noParams {
+		rs, err = h.c.CallByID(ctx, h.srvID, h.mid, h.inDescs, h.outDescs)
+	} else {
+		rs, err = h.c.CallByID(ctx, h.srvID, h.mid, h.inDescs, h.outDescs, req)
+	}
+	if err != nil {
+		var resp Resp
+		return resp, err
+	}
+
+	return convertResp[Resp](h.srvName, h.name, rs)
+}
+
+var emptyStructType = reflect.TypeOf(struct{}{})
+
+// variadicArgs 可变参数逐个传入时合并为slice，server以该slice调用方法。
+// 参数个数与方法相同并且最后一个参数就是对应的slice时不合并，与go中的xs...相同
+func variadicArgs(srvName, methodName string, in []reflect.Type, args []interface{}) ([]interface{}, error) {
+	n := len(in) - 1
+	if len(args) == len(in) && args[n] != nil && reflect.TypeOf(args[n]) == in[n] {
+		return args, nil
+	}
+	if len(args) < n {
+		return nil, fmt.Errorf("%w: %s.%s want at least %d args got %d", ErrTypeMismatch, srvName, methodName, n, len(args))
+	}
+
+	et := in[n].Elem()
+	vs := reflect.MakeSlice(in[n], 0, len(args)-n)
+	for i, arg := range args[n:] {
+		if arg == nil {
+			// nil只允许用于slice、map、指针
+			if k := et.Kind(); k == reflect.Slice || k == reflect.Map || k == reflect.Ptr {
+				vs = reflect.Append(vs, reflect.Zero(et))
+				continue
+			}
+			return nil, fmt.Errorf("%w: %s.%s arg %d want %s got nil", ErrTypeMismatch, srvName, methodName, n+i, et)
+		}
+		if at := reflect.TypeOf(arg); at != et {
+			return nil, fmt.Errorf("%w: %s.%s arg %d want %s got %s", ErrTypeMismatch, srvName, methodName, n+i, et, at)
+		}
+		vs = reflect.Append(vs, reflect.ValueOf(arg))
+	}
+
+	return append(args[:n:n], vs.Interface()), nil
+}
+
+func checkArgs(srvName, methodName string, in []reflect.Type, args []interface{}) error {
+	if len(in) != len(args) {
+		return fmt.Errorf("%w: %s.%s want %d args got %d", ErrTypeMismatch, srvName, methodName, len(in), len(args))
+	}
+
+	for i, arg := range args {
+		if arg == nil {
+			// nil只允许用于slice、map、指针
+			if k := in[i].Kind(); k == reflect.Slice || k == reflect.Map || k == reflect.Ptr {
+				continue
+			}
+			return fmt.Errorf("%w: %s.%s arg %d want %s got nil", ErrTypeMismatch, srvName, methodName, i, in[i])
+		}
+		if at := reflect.TypeOf(arg); at != in[i] {
+			return fmt.Errorf("%w: %s.%s arg %d want %s got %s", ErrTypeMismatch, srvName, methodName, i, in[i], at)
+		}
+	}
+
+	return nil
+}
+
+func checkResp[Resp any](srvName, methodName string, out []reflect.Type) error {
+	respType := reflect.TypeOf((*Resp)(nil)).Elem()
+	if len(out) == 0 && respType == emptyStructType {
+		return nil
+	}
+	if len(out) != 1 || out[0] != respType {
+		return fmt.Errorf("%w: %s.%s results %v, want %s", ErrTypeMismatch, srvName, methodName, out, respType)
+	}
+	return nil
+}
+
+func convertResp[Resp any](srvName, methodName string, rs []interface{}) (Resp, error) {
+	var resp Resp
+	if len(rs) == 0 {
+		return resp, nil
+	}
+
+	// nil slice、map、指针解析后为nil
+	if rs[0] == nil {
+		return resp, nil
+	}
+	resp, ok := rs[0].(Resp)
+	if !ok {
+		return resp, fmt.Errorf("%w: %s.%s result want %T got %T", ErrTypeMismatch, srvName, methodName, resp, rs[0])
+	}
+	return resp, nil
+}
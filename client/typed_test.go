@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"learn/irpc/common"
+	"learn/irpc/service"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTypedTestClient(t *testing.T) *IrpcClient {
+	mgr := service.NewServiceMgr("../config/services.yml")
+	err := mgr.Register(&ServerTest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := NewStreamCodec(common.NewParser(mgr.GetModels()))
+	return NewIrpcClient(context.Background(), cc, mgr, nil, DefaultDialAddr)
+}
+
+func TestMethodHandleTypeCheck(t *testing.T) {
+	c := newTypedTestClient(t)
+
+	_, err := Method[string, int32](c, service.HealthServiceName, service.HealthCheckMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Method[string, int](c, service.HealthServiceName, service.HealthCheckMethod)
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("resp mismatch should fail %v", err)
+	}
+
+	// 多个参数的方法需要使用Invoke
+	_, err = Method[X, Z](c, "ServerTest", "AddWithStruct")
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("multi params should fail %v", err)
+	}
+
+	_, err = Method[int, int](c, "ServerTest", "NotExist")
+	if err != service.ErrNotExistMethod {
+		t.Fatalf("not exist method should fail %v", err)
+	}
+}
+
+func TestInvokeTypeCheck(t *testing.T) {
+	c := newTypedTestClient(t)
+
+	_, err := Invoke[int](context.Background(), c, "ServerTest", "Add", 1, "2")
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("arg mismatch should fail %v", err)
+	}
+
+	_, err = Invoke[int](context.Background(), c, "ServerTest", "Add", 1)
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("args len mismatch should fail %v", err)
+	}
+
+	_, err = Invoke[Y](context.Background(), c, "ServerTest", "AddWithStruct", X{1}, Y{2})
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("resp mismatch should fail %v", err)
+	}
+}
+
+func TestInvokeWithVersion(t *testing.T) {
+	c := newTypedTestClient(t)
+
+	// 配置中没有ServerTest.v2
+	ctx := WithVersion(context.Background(), "v2")
+	_, err := Invoke[int](ctx, c, "ServerTest", "Add", 1, 2)
+	if err != service.ErrNotExistSrv {
+		t.Fatalf("unknown version should fail %v", err)
+	}
+	if versionedName(ctx, "ServerTest") != "ServerTest.v2" {
+		t.Fatal("wrong versioned name")
+	}
+}
+
+func TestVariadicArgs(t *testing.T) {
+	in := []reflect.Type{reflect.TypeOf(""), reflect.TypeOf([]int(nil))}
+
+	cases := []struct {
+		args []interface{}
+		want []interface{}
+	}{
+		{[]interface{}{"a"}, []interface{}{"a", []int{}}},
+		{[]interface{}{"a", 1, 2}, []interface{}{"a", []int{1, 2}}},
+		// 直接传入slice时不合并
+		{[]interface{}{"a", []int{1, 2}}, []interface{}{"a", []int{1, 2}}},
+	}
+	for _, c := range cases {
+		args, err := variadicArgs("S", "M", in, c.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(args, c.want) {
+			t.Fatalf("%v: got %v want %v", c.args, args, c.want)
+		}
+		if err = checkArgs("S", "M", in, args); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, args := range [][]interface{}{{}, {"a", 1, "2"}, {"a", nil}} {
+		if _, err := variadicArgs("S", "M", in, args); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("%v should fail %v", args, err)
+		}
+	}
+}
+
+func TestInvokeVariadicTypeCheck(t *testing.T) {
+	c := newTypedTestClient(t)
+	c.mgr.SetAutoIDs(true)
+	err := c.mgr.HandleFunc("ServerTest", "Sum", func(base int, xs ...int) int {
+		for _, x := range xs {
+			base += x
+		}
+		return base
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Invoke[int](context.Background(), c, "ServerTest", "Sum", 1, 2, "3")
+	if !errors.Is(err, ErrTypeMismatch) || !strings.Contains(err.Error(), "arg 2") {
+		t.Fatalf("variadic element mismatch should fail %v", err)
+	}
+}
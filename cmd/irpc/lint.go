@@ -0,0 +1,135 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"learn/irpc/config"
+	"learn/irpc/service"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// lint 检查配置，dir不为空时同时检查其中与服务同名的类型
+func lint(configPath, dir string) (config.Issues, error) {
+	rc, err := config.ParseRawServicesConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var methods map[string][]string
+	if dir != "" {
+		methods, err = goMethods(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return service.ValidateConfig(rc, methods), nil
+}
+
+// goMethods 解析dir中的源码，返回类型名对应的导出方法。结构体包括值方法以及指针方法，接口不展开嵌入的接口
+// 结构体字段标签`irpc:"exclude=A,B"`排除的方法不返回
+func goMethods(dir string) (map[string][]string, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	methods := make(map[string][]string)
+	excluded := make(map[string]map[string]bool)
+	for _, pkg := range pkgs {
+		if strings.HasSuffix(pkg.Name, "_test") {
+			continue
+		}
+		for name, file := range pkg.Files {
+			if strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			collectMethods(file, methods, excluded)
+		}
+	}
+
+	for typ, names := range excluded {
+		kept := methods[typ][:0]
+		for _, name := range methods[typ] {
+			if !names[name] {
+				kept = append(kept, name)
+			}
+		}
+		methods[typ] = kept
+	}
+	return methods, nil
+}
+
+func collectMethods(file *ast.File, methods map[string][]string, excluded map[string]map[string]bool) {
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil || len(d.Recv.List) == 0 || !d.Name.IsExported() {
+				continue
+			}
+			if recv := receiverName(d.Recv.List[0].Type); recv != "" {
+				methods[recv] = append(methods[recv], d.Name.Name)
+			}
+
+		case *ast.GenDecl:
+			if d.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range d.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if st, ok := ts.Type.(*ast.StructType); ok {
+					collectExcludes(ts.Name.Name, st, excluded)
+					continue
+				}
+				it, ok := ts.Type.(*ast.InterfaceType)
+				if !ok {
+					continue
+				}
+				for _, field := range it.Methods.List {
+					for _, n := range field.Names {
+						if n.IsExported() {
+							methods[ts.Name.Name] = append(methods[ts.Name.Name], n.Name)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func collectExcludes(typ string, st *ast.StructType, excluded map[string]map[string]bool) {
+	for _, field := range st.Fields.List {
+		if field.Tag == nil {
+			continue
+		}
+		tag, err := strconv.Unquote(field.Tag.Value)
+		if err != nil {
+			continue
+		}
+		for _, name := range service.ExcludeTag(reflect.StructTag(tag)) {
+			if excluded[typ] == nil {
+				excluded[typ] = make(map[string]bool)
+			}
+			excluded[typ][name] = true
+		}
+	}
+}
+
+// receiverName 返回接收者的类型名，例如*T、T[K]均为T
+func receiverName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(e.X)
+	case *ast.IndexExpr:
+		return receiverName(e.X)
+	case *ast.IndexListExpr:
+		return receiverName(e.X)
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
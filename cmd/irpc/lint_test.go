@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	issues, err := lint("testdata/services.yml", "testdata/pkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"testdata/services.yml:6: method Calc.Mul not found on go type",
+		"testdata/services.yml:3: exported method Calc.Sub missing from config",
+		"testdata/services.yml:10: duplicate service id 1 of Greeter, first used by Calc at testdata/services.yml:3",
+		"testdata/services.yml:12: method Greeter.Hello id 70000 out of range [0, 65535]",
+	}
+	got := make([]string, len(issues))
+	for i, issue := range issues {
+		got[i] = issue.String()
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("wrong issues\n%s", strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,55 @@
+// irpc irpc命令行工具
+//
+//	irpc lint services.yml ./pkg
+//
+// lint 检查services.yml，包括重复或超出范围的编号、预留的服务编号，
+// 以及与pkg中同名类型(结构体或接口)的导出方法是否一致。问题按照"文件:行: 描述"逐行输出
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "lint":
+		if len(args) != 2 && len(args) != 3 {
+			usage()
+			os.Exit(2)
+		}
+		dir := ""
+		if len(args) == 3 {
+			dir = args[2]
+		}
+		issues, err := lint(args[1], dir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+		if len(issues) > 0 {
+			os.Exit(1)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage:\n")
+	fmt.Fprintf(os.Stderr, "  irpc lint services.yml [./pkg]\n")
+	flag.PrintDefaults()
+}
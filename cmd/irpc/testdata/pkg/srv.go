@@ -0,0 +1,22 @@
+package pkg
+
+type Calc struct {
+	_ struct{} `irpc:"exclude=Close"`
+}
+
+func (c *Calc) Add(x, y int) int {
+	return x + y
+}
+
+func (c Calc) Sub(x, y int) int {
+	return x - y
+}
+
+func (c *Calc) reset() {}
+
+// Close 不是rpc方法
+func (c *Calc) Close() {}
+
+type Greeter interface {
+	Hello(name string) string
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"learn/irpc/common"
+	"learn/irpc/config"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+var (
+	ErrNotFoundInterface   = errors.New("irpcgen: not found interface")
+	ErrNotConfiguredSrv    = errors.New("irpcgen: service not configured")
+	ErrNotConfiguredMethod = errors.New("irpcgen: method not configured")
+	ErrUnsupportedMethod   = errors.New("irpcgen: unsupported method signature")
+)
+
+// genOptions 生成参数
+type genOptions struct {
+	// 源码目录，也是生成文件所在package
+	Dir string
+	// 服务接口名
+	Interface string
+	// 配置中的服务名
+	Service string
+	// 配置中的服务版本，为空时匹配没有版本的服务
+	Version string
+	// services.yml路径
+	ConfigPath string
+}
+
+type genParam struct {
+	Name string
+	// Type 可变参数时为元素类型
+	Type     string
+	Variadic bool
+}
+
+type genMethod struct {
+	Name    string
+	ID      common.MethodID
+	Params  []genParam
+	Results []genParam
+}
+
+type genData struct {
+	Package   string
+	Imports   []string
+	Interface string
+	Service   string
+	Version   string
+	// FullName 带版本的服务名，用于注册以及查找编号
+	FullName string
+	// Ident 生成的常量、类型以及函数名的前缀，带版本时为服务名加版本，例如EchoV2
+	Ident   string
+	SrvID   common.SrvID
+	Methods []*genMethod
+}
+
+// generate 解析接口及配置，生成typed client、注册代码以及编号检查
+func generate(opts genOptions) ([]byte, error) {
+	sc, err := config.ParseToServicesConfig(opts.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	srvConfig, err := findService(sc, opts.Service, opts.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, opts.Dir, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// 寻找接口定义
+	for _, pkg := range pkgs {
+		if strings.HasSuffix(pkg.Name, "_test") {
+			continue
+		}
+		for _, file := range pkg.Files {
+			it := findInterface(file, opts.Interface)
+			if it == nil {
+				continue
+			}
+
+			data := &genData{
+				Package:   pkg.Name,
+				Interface: opts.Interface,
+				Service:   opts.Service,
+				Version:   opts.Version,
+				FullName:  srvConfig.FullName(),
+				Ident:     opts.Service + versionIdent(opts.Version),
+				SrvID:     srvConfig.ID,
+			}
+			used := make(map[string]bool)
+			data.Methods, err = collectMethods(it, srvConfig, used)
+			if err != nil {
+				return nil, err
+			}
+			data.Imports = collectImports(file, used)
+
+			return render(data)
+		}
+	}
+
+	return nil, fmt.Errorf("%w %s", ErrNotFoundInterface, opts.Interface)
+}
+
+// findService 按带版本的服务名查找配置。服务有多个版本而没有指定version时，
+// 只匹配没有版本的服务，不存在时提示可用的版本，避免生成其他版本的编号
+func findService(sc *config.ServicesConfig, name, version string) (*config.ServiceConfig, error) {
+	fullName := config.VersionedName(name, version)
+	versions := make([]string, 0)
+	for _, s := range sc.Services {
+		if s.FullName() == fullName {
+			return s, nil
+		}
+		if s.Name == name && s.Version != "" {
+			versions = append(versions, s.Version)
+		}
+	}
+	if version == "" && len(versions) > 0 {
+		return nil, fmt.Errorf("%w %s: -version required, configured versions %s", ErrNotConfiguredSrv, name, strings.Join(versions, ", "))
+	}
+	return nil, fmt.Errorf("%w %s", ErrNotConfiguredSrv, fullName)
+}
+
+// versionIdent 版本转换为go标识符的一部分，例如v2为V2，v1.1为V11
+func versionIdent(version string) string {
+	r := make([]rune, 0, len(version))
+	for _, c := range version {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			r = append(r, c)
+		}
+	}
+	if len(r) > 0 {
+		r[0] = unicode.ToUpper(r[0])
+	}
+	return string(r)
+}
+
+func findInterface(file *ast.File, name string) *ast.InterfaceType {
+	for _, decl := range file.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if ts.Name.Name != name {
+				continue
+			}
+			if it, ok := ts.Type.(*ast.InterfaceType); ok {
+				return it
+			}
+		}
+	}
+	return nil
+}
+
+// collectMethods 按接口中的顺序收集方法，used记录签名中引用的package
+func collectMethods(it *ast.InterfaceType, srvConfig *config.ServiceConfig, used map[string]bool) ([]*genMethod, error) {
+	methods := make([]*genMethod, 0, len(it.Methods.List))
+	for _, field := range it.Methods.List {
+		ft, ok := field.Type.(*ast.FuncType)
+		if !ok || len(field.Names) == 0 {
+			return nil, fmt.Errorf("%w: embedded interface", ErrUnsupportedMethod)
+		}
+		name := field.Names[0].Name
+
+		mid, ok := srvConfig.Methods[name]
+		if !ok {
+			return nil, fmt.Errorf("%w %s.%s", ErrNotConfiguredMethod, srvConfig.Name, name)
+		}
+
+		// 开始的context.Context以及最后的error与生成的client方法相同，不参与编解码
+		inFields, outFields := fieldList(ft.Params), fieldList(ft.Results)
+		if len(inFields) > 0 && isSingle(inFields[0], "context.Context") {
+			inFields = inFields[1:]
+		}
+		if len(outFields) > 0 && isSingle(outFields[len(outFields)-1], "error") {
+			outFields = outFields[:len(outFields)-1]
+		}
+
+		m := &genMethod{Name: name, ID: mid}
+		params, err := collectParams(inFields, "p", used)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		m.Params = params
+
+		results, err := collectParams(outFields, "r", used)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		m.Results = results
+
+		methods = append(methods, m)
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("%w: interface without methods", ErrUnsupportedMethod)
+	}
+	return methods, nil
+}
+
+func fieldList(fl *ast.FieldList) []*ast.Field {
+	if fl == nil {
+		return nil
+	}
+	return fl.List
+}
+
+// isSingle field只声明了一个typ类型的参数或结果
+func isSingle(field *ast.Field, typ string) bool {
+	return len(field.Names) <= 1 && exprString(field.Type) == typ
+}
+
+// collectParams 可变参数在生成的client方法中同样是可变参数，作为slice传给server
+func collectParams(fields []*ast.Field, prefix string, used map[string]bool) ([]genParam, error) {
+	r := make([]genParam, 0, len(fields))
+	for _, field := range fields {
+		expr, variadic := field.Type, false
+		if ellipsis, ok := expr.(*ast.Ellipsis); ok {
+			expr, variadic = ellipsis.Elt, true
+		}
+		typ := exprString(expr)
+		if typ == "error" || typ == "context.Context" {
+			return nil, fmt.Errorf("%w: %s param", ErrUnsupportedMethod, typ)
+		}
+		markUsedPackages(expr, used)
+
+		// 参数名可能省略，也可能与生成代码中的变量冲突，统一重新命名
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			r = append(r, genParam{
+				Name:     prefix + strconv.Itoa(len(r)),
+				Type:     typ,
+				Variadic: variadic,
+			})
+		}
+	}
+
+	return r, nil
+}
+
+func exprString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	_ = format.Node(&buf, token.NewFileSet(), expr)
+	return buf.String()
+}
+
+func markUsedPackages(expr ast.Expr, used map[string]bool) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if se, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := se.X.(*ast.Ident); ok {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+}
+
+// collectImports 返回接口签名中用到的import
+func collectImports(file *ast.File, used map[string]bool) []string {
+	r := make([]string, 0)
+	for _, is := range file.Imports {
+		path, _ := strconv.Unquote(is.Path.Value)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if is.Name != nil {
+			name = is.Name.Name
+		}
+		if !used[name] {
+			continue
+		}
+		if is.Name != nil {
+			r = append(r, is.Name.Name+" "+is.Path.Value)
+		} else {
+			r = append(r, is.Path.Value)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+func render(data *genData) ([]byte, error) {
+	var buf bytes.Buffer
+	err := genTemplate.Execute(&buf, data)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("irpcgen: format generated code failed %w\n%s", err, buf.String())
+	}
+	return src, nil
+}
+
+var genTemplate = template.Must(template.New("irpcgen").Parse(`// Code generated by irpcgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+	"fmt"
+	"learn/irpc/client"
+	"learn/irpc/common"
+	"learn/irpc/service"
+{{- range .Imports}}
+	{{.}}
+{{- end}}
+)
+
+// {{.FullName}}服务以及方法编号，生成自services.yml
+const (
+	{{.Ident}}SrvID common.SrvID = {{.SrvID}}
+{{- range .Methods}}
+	{{$.Ident}}{{.Name}}MethodID common.MethodID = {{.ID}}
+{{- end}}
+)
+
+// 编译期检查：方法编号重复时无法编译
+func _() {
+	switch common.MethodID(0) {
+	case {{range $i, $m := .Methods}}{{if $i}}, {{end}}{{$.Ident}}{{$m.Name}}MethodID{{end}}:
+	}
+}
+
+// check{{.Ident}}IDs 检查运行时加载的配置与生成时一致
+func check{{.Ident}}IDs(mgr *service.Mgr) error {
+{{- range .Methods}}
+	if sid, mid, err := mgr.GetSrvMethodID("{{$.FullName}}", "{{.Name}}"); err != nil {
+		return err
+	} else if sid != {{$.Ident}}SrvID || mid != {{$.Ident}}{{.Name}}MethodID {
+		return fmt.Errorf("irpcgen: {{$.FullName}}.{{.Name}} id %d.%d mismatch generated %d.%d", sid, mid, {{$.Ident}}SrvID, {{$.Ident}}{{.Name}}MethodID)
+	}
+{{- end}}
+	return nil
+}
+
+// Register{{.Ident}} 注册{{.FullName}}服务实现
+func Register{{.Ident}}(mgr *service.Mgr, srv {{.Interface}}) error {
+	if err := check{{.Ident}}IDs(mgr); err != nil {
+		return err
+	}
+	return mgr.RegisterWithName("{{.FullName}}", srv)
+}
+
+// {{.Ident}}Client {{.FullName}}服务的类型安全client
+type {{.Ident}}Client struct {
+	c *client.IrpcClient
+}
+
+// New{{.Ident}}Client mgr需要已经注册{{.FullName}}服务
+func New{{.Ident}}Client(c *client.IrpcClient, mgr *service.Mgr) (*{{.Ident}}Client, error) {
+	if err := check{{.Ident}}IDs(mgr); err != nil {
+		return nil, err
+	}
+	return &{{.Ident}}Client{c: c}, nil
+}
+{{range $m := .Methods}}
+func (c *{{$.Ident}}Client) {{.Name}}(ctx context.Context{{range .Params}}, {{.Name}} {{if .Variadic}}...{{end}}{{.Type}}{{end}}) ({{range .Results}}{{.Name}} {{.Type}}, {{end}}err error) {
+	rs, err := c.c.CallContext({{if $.Version}}client.WithVersion(ctx, "{{$.Version}}"){{else}}ctx{{end}}, "{{$.Service}}", "{{.Name}}"{{range .Params}}, {{.Name}}{{end}})
+	if err != nil {
+		return
+	}
+	if len(rs) != {{len .Results}} {
+		err = fmt.Errorf("irpcgen: {{$.FullName}}.{{.Name}} want {{len .Results}} results got %d", len(rs))
+		return
+	}
+{{- range $i, $r := .Results}}
+	if v, ok := rs[{{$i}}].({{$r.Type}}); ok {
+		{{$r.Name}} = v
+	} else if rs[{{$i}}] != nil {
+		err = fmt.Errorf("irpcgen: {{$.FullName}}.{{$m.Name}} result {{$i}} want {{$r.Type}} got %T", rs[{{$i}}])
+		return
+	}
+{{- end}}
+	return
+}
+{{end}}`))
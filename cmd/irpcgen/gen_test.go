@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// typeCheck 将生成的代码与dir中的源码放在一起执行go vet，确认生成的代码可以编译
+// 临时目录需要在module内，生成代码才能import learn/irpc
+func typeCheck(t *testing.T, dir, name string, src []byte) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	tmp, err := os.MkdirTemp("testdata", "typecheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(tmp, filepath.Base(f)), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.WriteFile(filepath.Join(tmp, name), src, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(goBin, "vet", "./"+filepath.ToSlash(tmp)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("generated code does not compile: %v\n%s\n%s", err, out, src)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	src, err := generate(genOptions{
+		Dir:        "testdata/servertest",
+		Interface:  "ServerTestService",
+		Service:    "ServerTest",
+		ConfigPath: "../../config/services.yml",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = parser.ParseFile(token.NewFileSet(), "servertest_irpc.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeCheck(t, "testdata/servertest", "servertest_irpc.go", src)
+
+	// gofmt会对齐const，比较前合并空白
+	code := strings.Join(strings.Fields(string(src)), " ")
+	wants := []string{
+		"package servertest",
+		"ServerTestSrvID common.SrvID = 1",
+		"ServerTestAddWithStructMethodID common.MethodID = 2",
+		"case ServerTestAddMethodID, ServerTestAddWithStructMethodID:",
+		"func RegisterServerTest(mgr *service.Mgr, srv ServerTestService) error",
+		"func (c *ServerTestClient) Add(ctx context.Context, p0 int, p1 int) (r0 int, err error)",
+		"func (c *ServerTestClient) AddWithStruct(ctx context.Context, p0 X, p1 Y) (r0 Z, err error)",
+	}
+	for _, want := range wants {
+		if !strings.Contains(code, want) {
+			t.Fatalf("generated code missing %q\n%s", want, src)
+		}
+	}
+}
+
+func TestGenerateNotConfiguredSrv(t *testing.T) {
+	_, err := generate(genOptions{
+		Dir:        "testdata/servertest",
+		Interface:  "ServerTestService",
+		Service:    "ServerTest",
+		ConfigPath: "../../config/yaml_test.yml",
+	})
+	if !errors.Is(err, ErrNotConfiguredSrv) {
+		t.Fatalf("unexpected err %v", err)
+	}
+}
+
+func TestGenerateNotConfiguredMethod(t *testing.T) {
+	// 配置中的ServerTest缺少AddWithStruct
+	_, err := generate(genOptions{
+		Dir:        "testdata/servertest",
+		Interface:  "ServerTestService",
+		Service:    "ServerTest",
+		ConfigPath: "testdata/servertest/missing_method.yml",
+	})
+	if !errors.Is(err, ErrNotConfiguredMethod) || !strings.Contains(err.Error(), "ServerTest.AddWithStruct") {
+		t.Fatalf("unexpected err %v", err)
+	}
+}
+
+func TestGenerateSignatures(t *testing.T) {
+	src, err := generate(genOptions{
+		Dir:        "testdata/signatures",
+		Interface:  "EchoService",
+		Service:    "Echo",
+		ConfigPath: "testdata/signatures/services.yml",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = parser.ParseFile(token.NewFileSet(), "echo_irpc.go", src, 0); err != nil {
+		t.Fatal(err)
+	}
+	typeCheck(t, "testdata/signatures", "echo_irpc.go", src)
+
+	// context.Context以及error不重复，可变参数作为slice传给server
+	code := strings.Join(strings.Fields(string(src)), " ")
+	wants := []string{
+		"EchoSrvID common.SrvID = 3",
+		"func (c *EchoClient) Echo(ctx context.Context, p0 Req) (r0 Req, err error)",
+		`rs, err := c.c.CallContext(ctx, "Echo", "Echo", p0)`,
+		"func (c *EchoClient) Sum(ctx context.Context, p0 int, p1 ...int) (r0 int, err error)",
+		`rs, err := c.c.CallContext(ctx, "Echo", "Sum", p0, p1)`,
+		"func (c *EchoClient) Ping(ctx context.Context) (err error)",
+		"if len(rs) != 0 {",
+	}
+	for _, want := range wants {
+		if !strings.Contains(code, want) {
+			t.Fatalf("generated code missing %q\n%s", want, src)
+		}
+	}
+}
+
+func TestGenerateVersion(t *testing.T) {
+	src, err := generate(genOptions{
+		Dir:        "testdata/signatures",
+		Interface:  "EchoService",
+		Service:    "Echo",
+		Version:    "v2",
+		ConfigPath: "testdata/signatures/services.yml",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeCheck(t, "testdata/signatures", "echov2_irpc.go", src)
+
+	// 编号来自Echo.v2而不是同名的Echo，标识符带版本
+	code := strings.Join(strings.Fields(string(src)), " ")
+	wants := []string{
+		"EchoV2SrvID common.SrvID = 4",
+		`mgr.GetSrvMethodID("Echo.v2", "Echo")`,
+		`return mgr.RegisterWithName("Echo.v2", srv)`,
+		"func (c *EchoV2Client) Sum(ctx context.Context, p0 int, p1 ...int) (r0 int, err error)",
+		`rs, err := c.c.CallContext(client.WithVersion(ctx, "v2"), "Echo", "Sum", p0, p1)`,
+	}
+	for _, want := range wants {
+		if !strings.Contains(code, want) {
+			t.Fatalf("generated code missing %q\n%s", want, src)
+		}
+	}
+
+	// 只有带版本的配置时必须指定版本
+	_, err = generate(genOptions{
+		Dir:        "testdata/signatures",
+		Interface:  "EchoService",
+		Service:    "Calc",
+		ConfigPath: "testdata/signatures/services.yml",
+	})
+	if !errors.Is(err, ErrNotConfiguredSrv) || !strings.Contains(err.Error(), "v1, v2") {
+		t.Fatalf("unexpected err %v", err)
+	}
+}
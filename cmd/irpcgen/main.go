@@ -0,0 +1,67 @@
+// irpcgen 根据服务接口以及services.yml生成typed client、服务注册代码以及编号检查
+//
+// 在服务接口所在package中使用：
+//
+//	//go:generate irpcgen -interface ServerTestService -service ServerTest -config ../config/services.yml
+//
+// 生成的client方法形如：
+//
+//	func (c *ServerTestClient) Add(ctx context.Context, p0 int, p1 int) (r0 int, err error)
+//
+// 接口方法开始的context.Context以及最后的error对应client方法的ctx以及err，可变参数在client方法中同样是可变参数
+//
+// 服务有多个版本时使用-version指定，生成的标识符带版本，例如ServerTestV2Client
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	dir        = flag.String("dir", ".", "服务接口所在目录")
+	iface      = flag.String("interface", "", "服务接口名")
+	srvName    = flag.String("service", "", "services.yml中的服务名，默认为接口名去掉Service后缀")
+	version    = flag.String("version", "", "services.yml中的服务版本，服务有多个版本时需要指定")
+	configPath = flag.String("config", "services.yml", "services.yml路径")
+	output     = flag.String("o", "", "输出文件，默认为<service><version>_irpc.go")
+)
+
+func main() {
+	flag.Parse()
+	if *iface == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := genOptions{
+		Dir:        *dir,
+		Interface:  *iface,
+		Service:    *srvName,
+		Version:    *version,
+		ConfigPath: *configPath,
+	}
+	if opts.Service == "" {
+		opts.Service = strings.TrimSuffix(opts.Interface, "Service")
+	}
+
+	src, err := generate(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	out := *output
+	if out == "" {
+		out = filepath.Join(*dir, strings.ToLower(opts.Service+versionIdent(opts.Version))+"_irpc.go")
+	}
+	err = ioutil.WriteFile(out, src, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
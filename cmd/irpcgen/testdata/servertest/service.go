@@ -0,0 +1,18 @@
+package servertest
+
+type X struct {
+	V int
+}
+
+type Y struct {
+	V int
+}
+
+type Z struct {
+	V int
+}
+
+type ServerTestService interface {
+	Add(x, y int) int
+	AddWithStruct(x X, y Y) Z
+}
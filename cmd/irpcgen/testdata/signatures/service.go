@@ -0,0 +1,14 @@
+package signatures
+
+import "context"
+
+type Req struct {
+	Msg string
+}
+
+// EchoService 方法可以以context.Context开始、以error结束，最后的参数可以是可变参数
+type EchoService interface {
+	Echo(ctx context.Context, req Req) (Req, error)
+	Sum(base int, xs ...int) int
+	Ping(ctx context.Context) error
+}
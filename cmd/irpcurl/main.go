@@ -0,0 +1,416 @@
+// irpcurl 命令行调用irpc服务，用于调试
+//
+//	irpcurl [flags] list
+//	irpcurl [flags] describe Service[.Method]
+//	irpcurl [flags] describe Model
+//	irpcurl [flags] call Service.Method '[1, 2]'
+//
+// 默认通过server内置的反射服务获取schema。指定-config时服务、方法编号来自services.yml，
+// 此时可以用-in、-out指定参数类型，从而不依赖反射服务
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"learn/irpc/client"
+	"learn/irpc/common"
+	"learn/irpc/config"
+	"learn/irpc/service"
+	"os"
+	"reflect"
+	"strings"
+)
+
+var (
+	addr       = flag.String("addr", "127.0.0.1:4433", "server地址")
+	certPath   = flag.String("cert", "", "CA证书路径，用于校验server证书。为空时需要指定-insecure")
+	insecure   = flag.Bool("insecure", false, "不校验server证书，仅用于调试")
+	configPath = flag.String("config", "", "services.yml路径，为空时通过反射服务获取schema")
+	inTypes    = flag.String("in", "", "入参类型，逗号分隔，例如int,[]string。需要同时指定-config")
+	outTypes   = flag.String("out", "", "出参类型，逗号分隔。需要同时指定-config")
+	codecName  = flag.String("codec", "binary", "call使用的编码格式：binary、json、msgpack、cbor")
+)
+
+var (
+	ErrUsage          = errors.New("irpcurl: wrong usage")
+	ErrNotExistTarget = errors.New("irpcurl: not exist service, method or model")
+	ErrArgsMismatch   = errors.New("irpcurl: args count mismatch")
+	ErrUnknownCodec   = errors.New("irpcurl: unknown codec")
+	ErrNoCert         = errors.New("irpcurl: -cert is required unless -insecure is set")
+	ErrInvalidSchema  = errors.New("irpcurl: invalid reflection schema")
+)
+
+var contentTypes = map[string]common.ContentType{
+	"binary":  common.ContentTypeBinary,
+	"json":    common.ContentTypeJSON,
+	"msgpack": common.ContentTypeMsgPack,
+	"cbor":    common.ContentTypeCBOR,
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	c, err := newCurl()
+	if err != nil {
+		fatal(err)
+	}
+
+	switch args[0] {
+	case "list":
+		err = c.list()
+	case "describe":
+		if len(args) != 2 {
+			err = ErrUsage
+			break
+		}
+		err = c.describe(args[1])
+	case "call":
+		if len(args) != 2 && len(args) != 3 {
+			err = ErrUsage
+			break
+		}
+		callArgs := "[]"
+		if len(args) == 3 {
+			callArgs = args[2]
+		}
+		err = c.call(args[1], callArgs)
+	default:
+		err = ErrUsage
+	}
+
+	if err == ErrUsage {
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage:\n")
+	fmt.Fprintf(os.Stderr, "  irpcurl [flags] list\n")
+	fmt.Fprintf(os.Stderr, "  irpcurl [flags] describe Service[.Method] | Model\n")
+	fmt.Fprintf(os.Stderr, "  irpcurl [flags] call Service.Method '[json args]'\n")
+	flag.PrintDefaults()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+type curl struct {
+	client *client.IrpcClient
+	// parser使用的models，反射得到的model会动态构建后放入
+	models     *common.Models
+	modelNames map[common.KindID]string
+	// 无法动态构建的model，调用或者查看使用它们的方法时返回ErrUnbuildableModel
+	unbuildable map[common.KindID]string
+	sc          *config.ServicesConfig
+	schema      *service.ServerSchema
+}
+
+func newCurl() (*curl, error) {
+	// 只包含内置服务，用于调用反射服务
+	mgr := service.NewServiceMgr("")
+	models := mgr.GetModels().Clone()
+
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cc := client.NewStreamCodec(common.NewParser(models))
+	c := &curl{
+		client:     client.NewIrpcClient(context.Background(), cc, mgr, tlsConfig, *addr),
+		models:     models,
+		modelNames: make(map[common.KindID]string),
+	}
+
+	if *configPath != "" {
+		c.sc, err = config.ParseToServicesConfig(*configPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// newTLSConfig 指定-cert时校验server证书，否则需要显式指定-insecure
+func newTLSConfig() (*tls.Config, error) {
+	protos := []string{config.AlpnQuicTransport}
+	if *certPath != "" {
+		return config.GenerateClientTLSConfig(*certPath, protos)
+	}
+	if !*insecure {
+		return nil, ErrNoCert
+	}
+	return &tls.Config{InsecureSkipVerify: true, NextProtos: protos}, nil
+}
+
+// fetchSchema 通过反射服务获取schema，并构建model类型
+func (c *curl) fetchSchema() (*service.ServerSchema, error) {
+	if c.schema != nil {
+		return c.schema, nil
+	}
+
+	r, err := c.client.Call(service.ReflectionServiceName, service.ReflectionSchemaMethod)
+	if err != nil {
+		return nil, err
+	}
+	if len(r) != 1 {
+		return nil, fmt.Errorf("%w: want 1 result got %d", ErrInvalidSchema, len(r))
+	}
+	ss, ok := r[0].(service.ServerSchema)
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected type %T", ErrInvalidSchema, r[0])
+	}
+
+	c.unbuildable = buildModels(ss.Models, c.models)
+	for _, ms := range ss.Models {
+		c.modelNames[common.KindID(ms.KindID)] = ms.Name
+	}
+	c.schema = &ss
+
+	return c.schema, nil
+}
+
+func (c *curl) list() error {
+	if c.sc != nil {
+		for _, s := range c.sc.Services {
+			fmt.Printf("%s (id %d)\n", s.Name, s.ID)
+		}
+		return nil
+	}
+
+	ss, err := c.fetchSchema()
+	if err != nil {
+		return err
+	}
+	for _, s := range ss.Services {
+		fmt.Printf("%s (id %d)\n", s.Name, s.ID)
+	}
+	return nil
+}
+
+func (c *curl) describe(target string) error {
+	ss, err := c.fetchSchema()
+	if err != nil {
+		return err
+	}
+
+	srvName, methodName := splitTarget(target)
+	for _, s := range ss.Services {
+		if s.Name != srvName {
+			continue
+		}
+		found := false
+		for _, m := range s.Methods {
+			if methodName != "" && m.Name != methodName {
+				continue
+			}
+			found = true
+			if err = checkBuildable(c.unbuildable, uint32ToKindIDs(m.In), uint32ToKindIDs(m.Out)); err != nil {
+				return err
+			}
+			sig := signature(m.Name, uint32ToKindIDs(m.In), uint32ToKindIDs(m.Out), c.modelNames)
+			fmt.Printf("%s.%s (id %d.%d)\n", s.Name, sig, s.ID, m.ID)
+		}
+		if !found {
+			return fmt.Errorf("%w %s", ErrNotExistTarget, target)
+		}
+		return nil
+	}
+
+	// 不同包中可能存在同名model，target不带包路径时全部输出
+	found := false
+	for _, ms := range ss.Models {
+		if ms.Name != target && ms.QualifiedName() != target {
+			continue
+		}
+		found = true
+		if err = checkBuildable(c.unbuildable, []common.KindID{common.KindID(ms.KindID)}); err != nil {
+			return err
+		}
+		fmt.Printf("%s (kind %d) {\n", ms.QualifiedName(), ms.KindID)
+		for _, f := range ms.Fields {
+			fmt.Printf("\t%s %s\n", f.Name, f.Type)
+		}
+		fmt.Println("}")
+	}
+	if found {
+		return nil
+	}
+
+	return fmt.Errorf("%w %s", ErrNotExistTarget, target)
+}
+
+func (c *curl) call(target, argsJSON string) error {
+	srvName, methodName := splitTarget(target)
+	if methodName == "" {
+		return ErrUsage
+	}
+
+	sid, mid, inKids, outKids, err := c.resolve(srvName, methodName)
+	if err != nil {
+		return err
+	}
+
+	if err = checkBuildable(c.unbuildable, inKids, outKids); err != nil {
+		return err
+	}
+	inDescs, err := common.NewTypeDescs(inKids, c.models)
+	if err != nil {
+		return err
+	}
+	outDescs, err := common.NewTypeDescs(outKids, c.models)
+	if err != nil {
+		return err
+	}
+
+	params, err := c.decodeArgs(argsJSON, inDescs)
+	if err != nil {
+		return err
+	}
+
+	ct, ok := contentTypes[*codecName]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrUnknownCodec, *codecName)
+	}
+	ctx := client.WithContentType(context.Background(), ct)
+	results, err := c.client.CallByID(ctx, sid, mid, inDescs, outDescs, params...)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// resolve 获取服务、方法编号以及出入参数kindIDs
+func (c *curl) resolve(srvName, methodName string) (common.SrvID, common.MethodID, []common.KindID, []common.KindID, error) {
+	// 配置模式并且指定了参数类型，无需反射服务
+	if c.sc != nil && typesFlagsSet() {
+		sid, mid, err := c.configIDs(srvName, methodName)
+		if err != nil {
+			return 0, 0, nil, nil, err
+		}
+		inKids, err := parseTypeNames(*inTypes)
+		if err != nil {
+			return 0, 0, nil, nil, err
+		}
+		outKids, err := parseTypeNames(*outTypes)
+		if err != nil {
+			return 0, 0, nil, nil, err
+		}
+		return sid, mid, inKids, outKids, nil
+	}
+
+	ss, err := c.fetchSchema()
+	if err != nil {
+		return 0, 0, nil, nil, err
+	}
+	for _, s := range ss.Services {
+		if s.Name != srvName {
+			continue
+		}
+		for _, m := range s.Methods {
+			if m.Name != methodName {
+				continue
+			}
+			sid, mid := common.SrvID(s.ID), common.MethodID(m.ID)
+			// 配置模式下编号以配置为准
+			if c.sc != nil {
+				sid, mid, err = c.configIDs(srvName, methodName)
+				if err != nil {
+					return 0, 0, nil, nil, err
+				}
+			}
+			return sid, mid, uint32ToKindIDs(m.In), uint32ToKindIDs(m.Out), nil
+		}
+	}
+
+	return 0, 0, nil, nil, fmt.Errorf("%w %s.%s", ErrNotExistTarget, srvName, methodName)
+}
+
+func (c *curl) configIDs(srvName, methodName string) (common.SrvID, common.MethodID, error) {
+	for _, s := range c.sc.Services {
+		if s.Name != srvName {
+			continue
+		}
+		mid, ok := s.Methods[methodName]
+		if !ok {
+			break
+		}
+		return s.ID, mid, nil
+	}
+	return 0, 0, fmt.Errorf("%w %s.%s", ErrNotExistTarget, srvName, methodName)
+}
+
+// decodeArgs 将json数组按照方法参数类型逐个解析
+func (c *curl) decodeArgs(argsJSON string, inDescs []*common.TypeDesc) ([]interface{}, error) {
+	types := make([]reflect.Type, len(inDescs))
+	for i, d := range inDescs {
+		types[i] = d.Type
+	}
+
+	raws := make([]json.RawMessage, 0)
+	err := json.Unmarshal([]byte(argsJSON), &raws)
+	if err != nil {
+		return nil, err
+	}
+	if len(raws) != len(types) {
+		return nil, fmt.Errorf("%w: want %d got %d", ErrArgsMismatch, len(types), len(raws))
+	}
+
+	params := make([]interface{}, len(types))
+	for i, rt := range types {
+		v := reflect.New(rt)
+		err = json.Unmarshal(raws[i], v.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("irpcurl: arg %d: %w", i, err)
+		}
+		params[i] = v.Elem().Interface()
+	}
+	return params, nil
+}
+
+func splitTarget(target string) (string, string) {
+	i := strings.LastIndex(target, ".")
+	if i < 0 {
+		return target, ""
+	}
+
+	// 内置服务名本身带有"."，例如irpc.Health
+	srvName, methodName := target[:i], target[i+1:]
+	if strings.HasPrefix(target, "irpc.") && !strings.Contains(srvName, ".") {
+		return target, ""
+	}
+	return srvName, methodName
+}
+
+func typesFlagsSet() bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "in" || f.Name == "out" {
+			set = true
+		}
+	})
+	return set
+}
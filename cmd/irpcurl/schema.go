@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"learn/irpc/common"
+	"learn/irpc/service"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidKinds    = errors.New("irpcurl: invalid kind ids")
+	ErrUnknownTypeName = errors.New("irpcurl: unknown type name")
+	// ErrUnbuildableModel model递归引用自身或者包含无法表示的字段，无法动态构建
+	ErrUnbuildableModel = errors.New("irpcurl: unbuildable model")
+)
+
+var basicTypes = map[common.KindID]reflect.Type{
+	common.Bool:     reflect.TypeOf(false),
+	common.Int:      reflect.TypeOf(0),
+	common.Int8:     reflect.TypeOf(int8(0)),
+	common.Int16:    reflect.TypeOf(int16(0)),
+	common.Int32:    reflect.TypeOf(int32(0)),
+	common.Int64:    reflect.TypeOf(int64(0)),
+	common.Uint:     reflect.TypeOf(uint(0)),
+	common.Uint8:    reflect.TypeOf(uint8(0)),
+	common.Uint16:   reflect.TypeOf(uint16(0)),
+	common.Uint32:   reflect.TypeOf(uint32(0)),
+	common.Uint64:   reflect.TypeOf(uint64(0)),
+	common.Float32:  reflect.TypeOf(float32(0)),
+	common.Float64:  reflect.TypeOf(float64(0)),
+	common.String:   reflect.TypeOf(""),
+	common.Bytes:    reflect.TypeOf([]byte(nil)),
+	common.Time:     reflect.TypeOf(time.Time{}),
+	common.Duration: reflect.TypeOf(time.Duration(0)),
+	common.BigInt:   reflect.TypeOf(big.Int{}),
+	common.BigFloat: reflect.TypeOf(big.Float{}),
+	common.BigRat:   reflect.TypeOf(big.Rat{}),
+	common.UUID:     reflect.TypeOf([16]byte{}),
+}
+
+// buildModels 根据反射得到的model schema动态构建结构体类型以及编解码计划
+// 递归引用或者包含无法表示字段的model不注册，返回这些model的kindID以及名称，使用它们的调用应当失败
+func buildModels(schemas []service.ModelSchema, models *common.Models) map[common.KindID]string {
+	pending := make(map[common.KindID]service.ModelSchema, len(schemas))
+	for _, ms := range schemas {
+		kid := common.KindID(ms.KindID)
+		if _, exists := models.Type(kid); exists {
+			continue
+		}
+		// 自定义编解码类型无法得知其类型，按原始bytes处理
+		if ms.Custom {
+			models.AddCodec(kid, rawCodec())
+			continue
+		}
+		pending[kid] = ms
+	}
+
+	// 嵌套的model需要先构建，逐轮构建直到没有进展
+	for len(pending) > 0 {
+		progress := false
+		for kid, ms := range pending {
+			plan, ok := buildModel(ms, models)
+			if !ok {
+				continue
+			}
+			models.AddModel(kid, plan)
+			delete(pending, kid)
+			progress = true
+		}
+		if !progress {
+			break
+		}
+	}
+
+	// 剩余的(例如递归引用)，只注册部分字段会与服务端的编码不一致
+	unbuildable := make(map[common.KindID]string, len(pending))
+	for kid, ms := range pending {
+		unbuildable[kid] = ms.QualifiedName()
+	}
+	return unbuildable
+}
+
+// checkBuildable kids中引用了无法构建的model时返回ErrUnbuildableModel
+func checkBuildable(unbuildable map[common.KindID]string, kids ...[]common.KindID) error {
+	for _, ks := range kids {
+		for _, kid := range ks {
+			if name, ok := unbuildable[kid]; ok {
+				return fmt.Errorf("%w %s", ErrUnbuildableModel, name)
+			}
+		}
+	}
+	return nil
+}
+
+func rawCodec() *common.TypeCodec {
+	return &common.TypeCodec{
+		Type: reflect.TypeOf([]byte(nil)),
+		Encode: func(rv reflect.Value) ([]byte, error) {
+			return rv.Bytes(), nil
+		},
+		Decode: func(b []byte, rv reflect.Value) error {
+			rv.SetBytes(b)
+			return nil
+		},
+	}
+}
+
+// buildModel 任一字段无法构建即失败
+func buildModel(ms service.ModelSchema, models *common.Models) (*common.StructPlan, bool) {
+	fields := make([]reflect.StructField, 0, len(ms.Fields))
+	plan := &common.StructPlan{Fields: make([]common.FieldPlan, 0, len(ms.Fields))}
+	for _, f := range ms.Fields {
+		if len(f.Kinds) == 0 {
+			continue
+		}
+		desc, _, err := common.NewTypeDesc(uint32ToKindIDs(f.Kinds), models)
+		if err != nil {
+			return nil, false
+		}
+		plan.Fields = append(plan.Fields, common.FieldPlan{
+			Name:  f.Name,
+			Index: len(fields),
+			Desc:  desc,
+		})
+		fields = append(fields, reflect.StructField{Name: f.Name, Type: desc.Type})
+	}
+	plan.Type = reflect.StructOf(fields)
+	return plan, true
+}
+
+// parseTypeNames 解析-in、-out中以逗号分隔的go类型，例如"int,[]string,map[string]int64"
+// 结构体需要通过反射服务获取
+func parseTypeNames(s string) ([]common.KindID, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	kids := make([]common.KindID, 0)
+	for _, name := range strings.Split(s, ",") {
+		k, err := parseTypeName(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		kids = append(kids, k...)
+	}
+	return kids, nil
+}
+
+func parseTypeName(name string) ([]common.KindID, error) {
+	if name == "[]byte" || name == "[]uint8" {
+		return []common.KindID{common.Bytes}, nil
+	}
+
+	if strings.HasPrefix(name, "[]") {
+		ek, err := parseTypeName(name[2:])
+		if err != nil {
+			return nil, err
+		}
+		return append([]common.KindID{common.Slice}, ek...), nil
+	}
+
+	if strings.HasPrefix(name, "*") {
+		ek, err := parseTypeName(name[1:])
+		if err != nil {
+			return nil, err
+		}
+		return append([]common.KindID{common.Ptr}, ek...), nil
+	}
+
+	if strings.HasPrefix(name, "map[") {
+		end := strings.Index(name, "]")
+		if end < 0 {
+			return nil, ErrUnknownTypeName
+		}
+		kk, err := parseTypeName(name[4:end])
+		if err != nil {
+			return nil, err
+		}
+		ek, err := parseTypeName(name[end+1:])
+		if err != nil {
+			return nil, err
+		}
+		return append(append([]common.KindID{common.Map}, kk...), ek...), nil
+	}
+
+	for kid, rt := range basicTypes {
+		if rt.String() == name {
+			return []common.KindID{kid}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %s", ErrUnknownTypeName, name)
+}
+
+// signature 生成便于阅读的方法签名，结构体使用model名称
+func signature(name string, in, out []common.KindID, modelNames map[common.KindID]string) string {
+	return fmt.Sprintf("%s(%s) (%s)", name, typeNames(in, modelNames), typeNames(out, modelNames))
+}
+
+func typeNames(kids []common.KindID, modelNames map[common.KindID]string) string {
+	names := make([]string, 0, len(kids))
+	for i := 0; i < len(kids); {
+		name, n := kindsName(kids[i:], modelNames)
+		if n == 0 {
+			return fmt.Sprintf("%v", kids)
+		}
+		names = append(names, name)
+		i += n
+	}
+	return strings.Join(names, ", ")
+}
+
+func kindsName(kids []common.KindID, modelNames map[common.KindID]string) (string, int) {
+	if len(kids) == 0 {
+		return "", 0
+	}
+
+	switch kids[0] {
+	case common.Slice, common.Array, common.Ptr:
+		en, n := kindsName(kids[1:], modelNames)
+		if n == 0 {
+			return "", 0
+		}
+		prefix := map[common.KindID]string{common.Slice: "[]", common.Array: "[...]", common.Ptr: "*"}[kids[0]]
+		return prefix + en, n + 1
+
+	case common.Map:
+		kn, kc := kindsName(kids[1:], modelNames)
+		if kc == 0 {
+			return "", 0
+		}
+		en, ec := kindsName(kids[1+kc:], modelNames)
+		if ec == 0 {
+			return "", 0
+		}
+		return "map[" + kn + "]" + en, kc + ec + 1
+	}
+
+	if rt, ok := basicTypes[kids[0]]; ok {
+		return rt.String(), 1
+	}
+	if name, ok := modelNames[kids[0]]; ok {
+		return name, 1
+	}
+	return "", 0
+}
+
+func uint32ToKindIDs(kinds []uint32) []common.KindID {
+	r := make([]common.KindID, len(kinds))
+	for i, k := range kinds {
+		r[i] = common.KindID(k)
+	}
+	return r
+}
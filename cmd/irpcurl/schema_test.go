@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"learn/irpc/common"
+	"learn/irpc/service"
+	"reflect"
+	"testing"
+)
+
+func TestParseTypeNames(t *testing.T) {
+	kids, err := parseTypeNames("int, []string,map[int64]float32, *[]int8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []common.KindID{common.Int, common.Slice, common.String, common.Map, common.Int64, common.Float32, common.Ptr, common.Slice, common.Int8}
+	if !reflect.DeepEqual(kids, want) {
+		t.Fatalf("wrong kids %v", kids)
+	}
+
+	_, err = parseTypeNames("chan int")
+	if err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestBuildModelsAndDecodeArgs(t *testing.T) {
+	schemas := []service.ModelSchema{
+		{
+			KindID: common.ModelStartKindID + 1,
+			Name:   "Outer",
+			Fields: []service.FieldSchema{
+				{Name: "In", Type: "Inner", Kinds: []uint32{common.ModelStartKindID}},
+				{Name: "Tags", Type: "[]string", Kinds: []uint32{uint32(common.Slice), uint32(common.String)}},
+			},
+		},
+		{
+			KindID: common.ModelStartKindID,
+			Name:   "Inner",
+			Fields: []service.FieldSchema{
+				{Name: "V", Type: "int64", Kinds: []uint32{uint32(common.Int64)}},
+			},
+		},
+		{
+			KindID: common.ModelStartKindID + 2,
+			Name:   "Money",
+			Custom: true,
+		},
+	}
+	c := &curl{models: &common.Models{}}
+	if unbuildable := buildModels(schemas, c.models); len(unbuildable) != 0 {
+		t.Fatalf("unbuildable %v", unbuildable)
+	}
+
+	descs, err := common.NewTypeDescs([]common.KindID{common.Int8, common.ModelStartKindID + 1}, c.models)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := c.decodeArgs(`[1, {"In": {"V": 9007199254740993}, "Tags": ["a"]}]`, descs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params[0] != int8(1) {
+		t.Fatal("int8 wrong")
+	}
+	outer := reflect.ValueOf(params[1])
+	if outer.FieldByName("In").FieldByName("V").Int() != 9007199254740993 {
+		t.Fatal("nested model wrong")
+	}
+	if outer.FieldByName("Tags").Index(0).String() != "a" {
+		t.Fatal("slice field wrong")
+	}
+
+	_, err = c.decodeArgs(`[1]`, descs)
+	if err == nil {
+		t.Fatal("should fail for args mismatch")
+	}
+
+	// 自定义编解码类型按原始bytes处理
+	descs, err = common.NewTypeDescs([]common.KindID{common.ModelStartKindID + 2}, c.models)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if descs[0].Type != reflect.TypeOf([]byte(nil)) {
+		t.Fatalf("custom model type wrong %s", descs[0].Type)
+	}
+}
+
+func TestBuildModelsUnbuildable(t *testing.T) {
+	node := uint32(common.ModelStartKindID)
+	schemas := []service.ModelSchema{
+		{
+			KindID: node,
+			Name:   "Node",
+			Fields: []service.FieldSchema{
+				{Name: "V", Type: "int", Kinds: []uint32{uint32(common.Int)}},
+				{Name: "Next", Type: "*Node", Kinds: []uint32{uint32(common.Ptr), node}},
+			},
+		},
+		// 引用了无法构建的model，同样无法构建
+		{
+			KindID: node + 1,
+			Name:   "List",
+			Fields: []service.FieldSchema{
+				{Name: "Head", Type: "Node", Kinds: []uint32{node}},
+			},
+		},
+		{
+			KindID: node + 2,
+			Name:   "Plain",
+			Fields: []service.FieldSchema{
+				{Name: "V", Type: "int", Kinds: []uint32{uint32(common.Int)}},
+			},
+		},
+	}
+	models := &common.Models{}
+	unbuildable := buildModels(schemas, models)
+	if len(unbuildable) != 2 {
+		t.Fatalf("unbuildable %v", unbuildable)
+	}
+	if _, exists := models.Type(common.KindID(node)); exists {
+		t.Fatal("truncated model registered")
+	}
+	if _, exists := models.Type(common.KindID(node + 2)); !exists {
+		t.Fatal("plain model not registered")
+	}
+
+	err := checkBuildable(unbuildable, []common.KindID{common.Int}, []common.KindID{common.Slice, common.KindID(node + 1)})
+	if !errors.Is(err, ErrUnbuildableModel) {
+		t.Fatalf("want unbuildable got %v", err)
+	}
+	if err = checkBuildable(unbuildable, []common.KindID{common.KindID(node + 2)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	names := map[common.KindID]string{common.ModelStartKindID: "X"}
+	s := signature("Add", []common.KindID{common.Int, common.Map, common.String, common.ModelStartKindID}, []common.KindID{common.Slice, common.Int}, names)
+	if s != "Add(int, map[string]X) ([]int)" {
+		t.Fatalf("wrong signature %s", s)
+	}
+}
+
+func TestSplitTarget(t *testing.T) {
+	cases := map[string][2]string{
+		"ServerTest.Add":    {"ServerTest", "Add"},
+		"ServerTest":        {"ServerTest", ""},
+		"irpc.Health":       {"irpc.Health", ""},
+		"irpc.Health.Check": {"irpc.Health", "Check"},
+	}
+	for target, want := range cases {
+		s, m := splitTarget(target)
+		if s != want[0] || m != want[1] {
+			t.Fatalf("wrong split %s: %s %s", target, s, m)
+		}
+	}
+}
+
+func TestNewTLSConfigRequiresInsecure(t *testing.T) {
+	defer func(v bool) { *insecure = v }(*insecure)
+
+	*insecure = false
+	if _, err := newTLSConfig(); !errors.Is(err, ErrNoCert) {
+		t.Fatalf("want ErrNoCert got %v", err)
+	}
+	*insecure = true
+	tc, err := newTLSConfig()
+	if err != nil || !tc.InsecureSkipVerify {
+		t.Fatalf("insecure config %v %v", tc, err)
+	}
+}
@@ -0,0 +1,114 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"learn/irpc/common"
+	"reflect"
+)
+
+// arrayCodec 将参数编码为数组的通用格式，例如JSON、MessagePack、CBOR。解码时先切分数组，再按照descs逐个解码
+type arrayCodec struct {
+	ct        common.ContentType
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+	// split 切分数组为各元素的原始编码
+	split func(data []byte) ([][]byte, error)
+}
+
+// NewJSONCodec JSON数组格式，便于调试以及其他语言调用
+func NewJSONCodec() Codec {
+	return &arrayCodec{
+		ct:        common.ContentTypeJSON,
+		marshal:   json.Marshal,
+		unmarshal: json.Unmarshal,
+		split: func(data []byte) ([][]byte, error) {
+			raws := make([]json.RawMessage, 0)
+			err := json.Unmarshal(data, &raws)
+			return rawsToBytes(raws), err
+		},
+	}
+}
+
+// NewMsgPackCodec MessagePack数组格式
+func NewMsgPackCodec() Codec {
+	return &arrayCodec{
+		ct:        common.ContentTypeMsgPack,
+		marshal:   msgpack.Marshal,
+		unmarshal: msgpack.Unmarshal,
+		split: func(data []byte) ([][]byte, error) {
+			raws := make([]msgpack.RawMessage, 0)
+			err := msgpack.Unmarshal(data, &raws)
+			return rawsToBytes(raws), err
+		},
+	}
+}
+
+// NewCBORCodec CBOR数组格式
+func NewCBORCodec() Codec {
+	return &arrayCodec{
+		ct:        common.ContentTypeCBOR,
+		marshal:   cbor.Marshal,
+		unmarshal: cbor.Unmarshal,
+		split: func(data []byte) ([][]byte, error) {
+			raws := make([]cbor.RawMessage, 0)
+			err := cbor.Unmarshal(data, &raws)
+			return rawsToBytes(raws), err
+		},
+	}
+}
+
+func rawsToBytes[T ~[]byte](raws []T) [][]byte {
+	r := make([][]byte, len(raws))
+	for i, raw := range raws {
+		r[i] = raw
+	}
+	return r
+}
+
+func (c *arrayCodec) ContentType() common.ContentType {
+	return c.ct
+}
+
+func (c *arrayCodec) EncodeBody(descs []*common.TypeDesc, params ...interface{}) ([]byte, error) {
+	if len(params) != len(descs) {
+		return nil, common.ErrNotMatchedParam
+	}
+	// 空数组而不是null
+	if params == nil {
+		params = []interface{}{}
+	}
+	return c.marshal(params)
+}
+
+func (c *arrayCodec) ParseBody(body []byte, descs []*common.TypeDesc) ([]interface{}, error) {
+	if len(descs) == 0 {
+		return nil, nil
+	}
+
+	raws, err := c.split(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", common.ErrNotMatchedBody, err)
+	}
+	if len(raws) != len(descs) {
+		return nil, common.ErrNotMatchedBody
+	}
+
+	r := make([]interface{}, len(descs))
+	for i, d := range descs {
+		rv := reflect.New(d.Type)
+		// MessagePack切分时nil元素为空
+		if len(raws[i]) == 0 {
+			r[i] = interfaceOf(rv.Elem())
+			continue
+		}
+		err = c.unmarshal(raws[i], rv.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("%w: param %d: %v", common.ErrNotMatchedBody, i, err)
+		}
+		r[i] = interfaceOf(rv.Elem())
+	}
+	return r, nil
+}
@@ -0,0 +1,84 @@
+package codec
+
+import (
+	"learn/irpc/common"
+	"reflect"
+)
+
+// Codec 编解码方法的参数以及结果，descs为注册方法时得到的类型描述
+// ParseBody返回的值不能引用body，body在解析之后会被复用
+type Codec interface {
+	ContentType() common.ContentType
+	EncodeBody(descs []*common.TypeDesc, params ...interface{}) ([]byte, error)
+	ParseBody(body []byte, descs []*common.TypeDesc) ([]interface{}, error)
+}
+
+// Appender 可以将编码结果直接追加到dst的Codec，避免额外的分配以及复制
+type Appender interface {
+	AppendBody(dst []byte, descs []*common.TypeDesc, params ...interface{}) ([]byte, error)
+}
+
+// AppendBody Codec实现了Appender时直接追加，否则编码后复制到dst之后
+func AppendBody(c Codec, dst []byte, descs []*common.TypeDesc, params ...interface{}) ([]byte, error) {
+	if a, ok := c.(Appender); ok {
+		return a.AppendBody(dst, descs, params...)
+	}
+	body, err := c.EncodeBody(descs, params...)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, body...), nil
+}
+
+// NewCodecs 返回所有内置Codec，二进制格式使用parser
+func NewCodecs(parser *common.Parser) map[common.ContentType]Codec {
+	codecs := []Codec{
+		NewBinaryCodec(parser),
+		NewJSONCodec(),
+		NewMsgPackCodec(),
+		NewCBORCodec(),
+		NewProtobufCodec(),
+	}
+
+	r := make(map[common.ContentType]Codec, len(codecs))
+	for _, c := range codecs {
+		r[c.ContentType()] = c
+	}
+	return r
+}
+
+// BinaryCodec Parser的二进制格式，默认格式
+type BinaryCodec struct {
+	parser *common.Parser
+}
+
+func NewBinaryCodec(parser *common.Parser) *BinaryCodec {
+	return &BinaryCodec{parser: parser}
+}
+
+func (c *BinaryCodec) ContentType() common.ContentType {
+	return common.ContentTypeBinary
+}
+
+func (c *BinaryCodec) EncodeBody(descs []*common.TypeDesc, params ...interface{}) ([]byte, error) {
+	return c.parser.EncodeBody(descs, params...)
+}
+
+func (c *BinaryCodec) AppendBody(dst []byte, descs []*common.TypeDesc, params ...interface{}) ([]byte, error) {
+	return c.parser.AppendBody(dst, descs, params...)
+}
+
+func (c *BinaryCodec) ParseBody(body []byte, descs []*common.TypeDesc) ([]interface{}, error) {
+	return c.parser.ParseBody(body, descs)
+}
+
+// interfaceOf nil slice、map、指针返回nil，与Parser一致
+func interfaceOf(rv reflect.Value) interface{} {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+	}
+	return rv.Interface()
+}
@@ -0,0 +1,90 @@
+package codec
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"learn/irpc/common"
+	"reflect"
+	"testing"
+)
+
+type CodecItem struct {
+	Name string
+	Tags map[string][]int32
+	Next *CodecItem
+}
+
+func itemDescs() []*common.TypeDesc {
+	str := &common.TypeDesc{Kind: common.String, Type: reflect.TypeOf("")}
+	return []*common.TypeDesc{
+		{Kind: common.Int64, Type: reflect.TypeOf(int64(0))},
+		str,
+		{Kind: common.Slice, Elem: str, Type: reflect.TypeOf([]string(nil))},
+		{Kind: common.ModelStartKindID, Type: reflect.TypeOf(CodecItem{})},
+	}
+}
+
+func TestArrayCodecs(t *testing.T) {
+	item := CodecItem{
+		Name: "a",
+		Tags: map[string][]int32{"x": {1, 2}},
+		Next: &CodecItem{Name: "b", Tags: map[string][]int32{}},
+	}
+	params := []interface{}{int64(-1 << 40), "{[", nil, item}
+	for _, c := range []Codec{NewJSONCodec(), NewMsgPackCodec(), NewCBORCodec()} {
+		body, err := c.EncodeBody(itemDescs(), params...)
+		if err != nil {
+			t.Fatalf("content type %d: %v", c.ContentType(), err)
+		}
+		r, err := c.ParseBody(body, itemDescs())
+		if err != nil {
+			t.Fatalf("content type %d: %v", c.ContentType(), err)
+		}
+		if !reflect.DeepEqual(r, params) {
+			t.Fatalf("content type %d: %#v", c.ContentType(), r)
+		}
+
+		// 参数数量与descs不一致
+		_, err = c.ParseBody(body, itemDescs()[:1])
+		if err == nil {
+			t.Fatalf("content type %d: should fail for count mismatch", c.ContentType())
+		}
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	c := NewProtobufCodec()
+	str := &common.TypeDesc{Kind: common.ModelStartKindID, Type: reflect.TypeOf(wrapperspb.StringValue{})}
+	descs := []*common.TypeDesc{
+		{Kind: common.Ptr, Elem: str, Type: reflect.TypeOf(&wrapperspb.StringValue{})},
+		{Kind: common.Ptr, Elem: str, Type: reflect.TypeOf(&wrapperspb.StringValue{})},
+	}
+	body, err := c.EncodeBody(descs, wrapperspb.String("hello"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.ParseBody(body, descs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(r[0].(proto.Message), wrapperspb.String("hello")) || r[1].(*wrapperspb.StringValue).GetValue() != "" {
+		t.Fatalf("proto wrong %v", r)
+	}
+
+	_, err = c.EncodeBody(itemDescs()[:1], int64(1))
+	if err != ErrNotProtoMessage {
+		t.Fatalf("want ErrNotProtoMessage got %v", err)
+	}
+}
+
+func TestNewCodecs(t *testing.T) {
+	codecs := NewCodecs(common.NewParser(&common.Models{}))
+	for _, ct := range []common.ContentType{
+		common.ContentTypeBinary, common.ContentTypeJSON, common.ContentTypeMsgPack,
+		common.ContentTypeCBOR, common.ContentTypeProtobuf,
+	} {
+		if codecs[ct] == nil || codecs[ct].ContentType() != ct {
+			t.Fatalf("content type %d not registered", ct)
+		}
+	}
+}
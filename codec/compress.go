@@ -0,0 +1,224 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"learn/irpc/common"
+	"math"
+	"sync"
+)
+
+var (
+	ErrUnsupportedCompression = errors.New("codec: unsupported compression")
+	ErrExceedDecompressedSize = errors.New("codec: exceed max decompressed size")
+)
+
+const (
+	defaultCompressThreshold   = 1 << 10
+	defaultMaxDecompressedSize = 64 << 20
+)
+
+// CompressionConfig 压缩配置，client、server各自持有。zstd encoder、decoder以及gzip writer、reader在同一个配置的所有消息间共享，
+// 因此配置使用之后不能复制，MaxDecompressedSize需要在使用之前设置
+type CompressionConfig struct {
+	// Algorithms 本端支持的算法，按优先级排列。为空时不压缩，也不接受压缩的消息
+	Algorithms []common.Compression
+	// Threshold body小于该长度时不压缩
+	Threshold int
+	// MaxDecompressedSize 解压后的最大长度，超过时返回错误，防止解压炸弹。不大于0时不限制
+	MaxDecompressedSize int
+
+	// 第一次使用zstd时创建，EncodeAll、DecodeAll可以并发调用
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+	zstdErr  error
+	// 复用的*gzip.Writer以及*gzip.Reader
+	gzipWriters sync.Pool
+	gzipReaders sync.Pool
+}
+
+// DefaultCompressionConfig 支持zstd、snappy、gzip，1KB以下不压缩，解压后不超过64MB
+func DefaultCompressionConfig() *CompressionConfig {
+	return &CompressionConfig{
+		Algorithms:          []common.Compression{common.CompressionZstd, common.CompressionSnappy, common.CompressionGzip},
+		Threshold:           defaultCompressThreshold,
+		MaxDecompressedSize: defaultMaxDecompressedSize,
+	}
+}
+
+// Accept 本端可以解压的算法
+func (c *CompressionConfig) Accept() common.CompressionSet {
+	return common.NewCompressionSet(c.Algorithms...)
+}
+
+// Negotiate 按本端优先级选择对端可以解压的算法，没有时返回CompressionNone
+func (c *CompressionConfig) Negotiate(peer common.CompressionSet) common.Compression {
+	for _, alg := range c.Algorithms {
+		if peer.Has(alg) {
+			return alg
+		}
+	}
+	return common.CompressionNone
+}
+
+// Compress body未达到阈值、对端不支持或者压缩后没有变小时不压缩，返回使用的算法
+func (c *CompressionConfig) Compress(body []byte, peer common.CompressionSet) ([]byte, common.Compression, error) {
+	if len(body) < c.Threshold {
+		return body, common.CompressionNone, nil
+	}
+	alg := c.Negotiate(peer)
+	if alg == common.CompressionNone {
+		return body, common.CompressionNone, nil
+	}
+
+	r, err := c.compress(alg, body)
+	if err != nil {
+		return nil, common.CompressionNone, err
+	}
+	if len(r) >= len(body) {
+		return body, common.CompressionNone, nil
+	}
+	return r, alg, nil
+}
+
+// Decompress 只解压本端支持的算法
+func (c *CompressionConfig) Decompress(body []byte, alg common.Compression) ([]byte, error) {
+	return c.DecompressLimit(body, alg, 0)
+}
+
+// DecompressLimit 解压后的长度同时不能超过limit，例如消息的最大长度。limit不大于0时没有额外的限制，
+// 只使用MaxDecompressedSize，两者都不大于0时不限制解压后的长度
+func (c *CompressionConfig) DecompressLimit(body []byte, alg common.Compression, limit int) ([]byte, error) {
+	if alg == common.CompressionNone {
+		return body, nil
+	}
+	if !c.Accept().Has(alg) {
+		return nil, ErrUnsupportedCompression
+	}
+	if limit <= 0 || (c.MaxDecompressedSize > 0 && c.MaxDecompressedSize < limit) {
+		limit = c.MaxDecompressedSize
+	}
+	if limit <= 0 {
+		limit = noDecompressLimit
+	}
+	return c.decompress(alg, body, limit)
+}
+
+// noDecompressLimit 不限制解压后的长度，readLimited中加1也不会溢出
+const noDecompressLimit = math.MaxInt - 1
+
+// zstdCodec 共享的encoder以及decoder。decoder的内存上限为MaxDecompressedSize，更小的limit由decompress检查
+func (c *CompressionConfig) zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	c.zstdOnce.Do(func() {
+		c.zstdEnc, c.zstdErr = zstd.NewWriter(nil)
+		if c.zstdErr != nil {
+			return
+		}
+		var opts []zstd.DOption
+		if c.MaxDecompressedSize > 0 {
+			opts = append(opts, zstd.WithDecoderMaxMemory(uint64(c.MaxDecompressedSize)+1))
+		}
+		c.zstdDec, c.zstdErr = zstd.NewReader(nil, opts...)
+	})
+	return c.zstdEnc, c.zstdDec, c.zstdErr
+}
+
+func (c *CompressionConfig) compress(alg common.Compression, body []byte) ([]byte, error) {
+	switch alg {
+	case common.CompressionGzip:
+		var buf bytes.Buffer
+		w, _ := c.gzipWriters.Get().(*gzip.Writer)
+		if w == nil {
+			w = gzip.NewWriter(&buf)
+		} else {
+			w.Reset(&buf)
+		}
+		defer c.gzipWriters.Put(w)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	case common.CompressionZstd:
+		enc, _, err := c.zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(body, make([]byte, 0, len(body))), nil
+
+	case common.CompressionSnappy:
+		return snappy.Encode(nil, body), nil
+	}
+
+	return nil, ErrUnsupportedCompression
+}
+
+func (c *CompressionConfig) decompress(alg common.Compression, body []byte, limit int) ([]byte, error) {
+	switch alg {
+	case common.CompressionGzip:
+		r, _ := c.gzipReaders.Get().(*gzip.Reader)
+		var err error
+		if r == nil {
+			r, err = gzip.NewReader(bytes.NewReader(body))
+		} else {
+			err = r.Reset(bytes.NewReader(body))
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer c.gzipReaders.Put(r)
+		return readLimited(r, limit)
+
+	case common.CompressionZstd:
+		_, dec, err := c.zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		// EncodeAll在frame header中记录了解压后的长度，解压前检查
+		var h zstd.Header
+		if err = h.Decode(body); err != nil {
+			return nil, err
+		}
+		if h.HasFCS && h.FrameContentSize > uint64(limit) {
+			return nil, ErrExceedDecompressedSize
+		}
+		b, err := dec.DecodeAll(body, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || len(b) > limit {
+			return nil, ErrExceedDecompressedSize
+		}
+		return b, err
+
+	case common.CompressionSnappy:
+		// snappy在头部记录了解压后的长度，解压前检查
+		l, err := snappy.DecodedLen(body)
+		if err != nil {
+			return nil, err
+		}
+		if l > limit {
+			return nil, ErrExceedDecompressedSize
+		}
+		return snappy.Decode(nil, body)
+	}
+
+	return nil, ErrUnsupportedCompression
+}
+
+// readLimited 最多读取limit+1个byte，超过limit即返回错误
+func readLimited(r io.Reader, limit int) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > limit {
+		return nil, ErrExceedDecompressedSize
+	}
+	return b, nil
+}
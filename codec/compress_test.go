@@ -0,0 +1,146 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"learn/irpc/common"
+	"sync"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	cfg := DefaultCompressionConfig()
+	body := bytes.Repeat([]byte("irpc compress "), 1000)
+	for _, alg := range []common.Compression{common.CompressionGzip, common.CompressionZstd, common.CompressionSnappy} {
+		r, used, err := cfg.Compress(body, common.NewCompressionSet(alg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used != alg || len(r) >= len(body) {
+			t.Fatalf("alg %d not used", alg)
+		}
+		d, err := cfg.Decompress(r, used)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(d, body) {
+			t.Fatalf("alg %d round trip wrong", alg)
+		}
+	}
+
+	// 按本端优先级选择
+	if cfg.Negotiate(common.NewCompressionSet(common.CompressionGzip, common.CompressionZstd)) != common.CompressionZstd {
+		t.Fatal("negotiate wrong")
+	}
+	// 未达到阈值或者对端不支持时不压缩
+	_, used, _ := cfg.Compress(body[:10], cfg.Accept())
+	if used != common.CompressionNone {
+		t.Fatal("should skip small body")
+	}
+	_, used, _ = cfg.Compress(body, 0)
+	if used != common.CompressionNone {
+		t.Fatal("should skip unsupported peer")
+	}
+	// 关闭压缩时不接受压缩的消息
+	_, err := (&CompressionConfig{}).Decompress(body, common.CompressionGzip)
+	if err != ErrUnsupportedCompression {
+		t.Fatalf("want ErrUnsupportedCompression got %v", err)
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	cfg := DefaultCompressionConfig()
+	body := make([]byte, 1<<20)
+	limited := &CompressionConfig{Algorithms: cfg.Algorithms, MaxDecompressedSize: 1 << 10}
+	for _, alg := range cfg.Algorithms {
+		r, _, err := cfg.Compress(body, common.NewCompressionSet(alg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = limited.Decompress(r, alg)
+		if err != ErrExceedDecompressedSize {
+			t.Fatalf("alg %d want ErrExceedDecompressedSize got %v", alg, err)
+		}
+		// 消息的最大长度小于MaxDecompressedSize时以消息长度为准
+		_, err = cfg.DecompressLimit(r, alg, 1<<10)
+		if err != ErrExceedDecompressedSize {
+			t.Fatalf("alg %d want ErrExceedDecompressedSize with limit got %v", alg, err)
+		}
+		if _, err = cfg.DecompressLimit(r, alg, 2<<20); err != nil {
+			t.Fatalf("alg %d %v", alg, err)
+		}
+	}
+}
+
+func TestDecompressNoLimit(t *testing.T) {
+	// MaxDecompressedSize以及limit都不大于0时不限制解压后的长度
+	cfg := DefaultCompressionConfig()
+	cfg.MaxDecompressedSize = 0
+	body := make([]byte, 1<<20)
+	for _, alg := range cfg.Algorithms {
+		r, _, err := cfg.Compress(body, common.NewCompressionSet(alg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, limit := range []int{0, -1} {
+			d, err := cfg.DecompressLimit(r, alg, limit)
+			if err != nil || len(d) != len(body) {
+				t.Fatalf("alg %d limit %d: %d bytes %v", alg, limit, len(d), err)
+			}
+		}
+		// 只有limit时以limit为准
+		if _, err = cfg.DecompressLimit(r, alg, 1<<10); err != ErrExceedDecompressedSize {
+			t.Fatalf("alg %d want ErrExceedDecompressedSize got %v", alg, err)
+		}
+	}
+}
+
+func TestCompressConcurrent(t *testing.T) {
+	// 共享的zstd encoder、decoder以及复用的gzip writer、reader可以并发使用
+	cfg := DefaultCompressionConfig()
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := bytes.Repeat([]byte{byte(i)}, 4<<10)
+			for _, alg := range cfg.Algorithms {
+				r, _, err := cfg.Compress(body, common.NewCompressionSet(alg))
+				if err == nil {
+					r, err = cfg.Decompress(r, alg)
+				}
+				if err == nil && !bytes.Equal(r, body) {
+					err = fmt.Errorf("alg %d round trip wrong", alg)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkZstdRoundTrip(b *testing.B) {
+	cfg := DefaultCompressionConfig()
+	body := bytes.Repeat([]byte("irpc compress "), 100)
+	set := common.NewCompressionSet(common.CompressionZstd)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r, _, err := cfg.Compress(body, set)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = cfg.Decompress(r, common.CompressionZstd); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
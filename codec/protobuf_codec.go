@@ -0,0 +1,87 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"google.golang.org/protobuf/proto"
+	"learn/irpc/common"
+	"reflect"
+)
+
+var (
+	ErrNotProtoMessage = errors.New("codec: param not proto.Message")
+)
+
+var protoMessageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+// ProtobufCodec 参数、结果均为proto.Message的方法，每个参数以varint长度为前缀
+type ProtobufCodec struct {
+}
+
+func NewProtobufCodec() *ProtobufCodec {
+	return &ProtobufCodec{}
+}
+
+func (c *ProtobufCodec) ContentType() common.ContentType {
+	return common.ContentTypeProtobuf
+}
+
+func (c *ProtobufCodec) EncodeBody(descs []*common.TypeDesc, params ...interface{}) ([]byte, error) {
+	return c.AppendBody(nil, descs, params...)
+}
+
+func (c *ProtobufCodec) AppendBody(r []byte, descs []*common.TypeDesc, params ...interface{}) ([]byte, error) {
+	if len(params) != len(descs) {
+		return nil, common.ErrNotMatchedParam
+	}
+
+	for i, d := range descs {
+		if !d.Type.Implements(protoMessageType) {
+			return nil, ErrNotProtoMessage
+		}
+		// nil按照空消息编码
+		var msg proto.Message
+		if params[i] != nil {
+			var ok bool
+			msg, ok = params[i].(proto.Message)
+			if !ok {
+				return nil, ErrNotProtoMessage
+			}
+		}
+		var err error
+		r = binary.AppendUvarint(r, uint64(proto.Size(msg)))
+		r, err = proto.MarshalOptions{}.MarshalAppend(r, msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (c *ProtobufCodec) ParseBody(body []byte, descs []*common.TypeDesc) ([]interface{}, error) {
+	if len(descs) == 0 {
+		return nil, nil
+	}
+
+	var index int
+	r := make([]interface{}, len(descs))
+	for i, d := range descs {
+		if d.Kind != common.Ptr || !d.Type.Implements(protoMessageType) {
+			return nil, ErrNotProtoMessage
+		}
+		l, n := binary.Uvarint(body[index:])
+		if n <= 0 || uint64(len(body)-index-n) < l {
+			return nil, common.ErrNotMatchedBody
+		}
+		index += n
+
+		msg := reflect.New(d.Type.Elem()).Interface().(proto.Message)
+		err := proto.Unmarshal(body[index:index+int(l)], msg)
+		if err != nil {
+			return nil, err
+		}
+		index += int(l)
+		r[i] = msg
+	}
+	return r, nil
+}
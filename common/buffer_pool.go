@@ -0,0 +1,35 @@
+package common
+
+import "sync"
+
+const (
+	defaultBufferSize = 512
+	// 超过该大小的buffer不放回，避免偶尔的大消息长期占用内存
+	maxPooledBufferSize = 1 << 20
+)
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, defaultBufferSize)
+		return &b
+	},
+}
+
+// GetBuffer 从池中获取长度为n的buffer，内容未初始化。使用完毕后通过PutBuffer放回
+func GetBuffer(n int) []byte {
+	bp := bufferPool.Get().(*[]byte)
+	if cap(*bp) < n {
+		bufferPool.Put(bp)
+		return make([]byte, n)
+	}
+	return (*bp)[:n]
+}
+
+// PutBuffer 放回buffer，放回后不能再使用b以及引用b的slice
+func PutBuffer(b []byte) {
+	if cap(b) > maxPooledBufferSize {
+		return
+	}
+	b = b[:0]
+	bufferPool.Put(&b)
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Models 已注册的结构体以及自定义编解码类型。写时复制，编解码读取时不加锁，server运行时也可以注册新的model
+// 零值可以直接使用，不可复制
+type Models struct {
+	mu   sync.Mutex
+	snap atomic.Pointer[modelsSnapshot]
+}
+
+// modelsSnapshot 发布之后不再修改
+type modelsSnapshot struct {
+	types map[KindID]reflect.Type
+	// 结构体model的编解码计划
+	plans map[KindID]*StructPlan
+	// 自定义编解码类型，与结构体共用model kindID
+	codecs map[KindID]*TypeCodec
+}
+
+var emptyModels = &modelsSnapshot{}
+
+func (m *Models) load() *modelsSnapshot {
+	if s := m.snap.Load(); s != nil {
+		return s
+	}
+	return emptyModels
+}
+
+// update 复制当前快照，修改之后整体替换
+func (m *Models) update(f func(s *modelsSnapshot)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old := m.load()
+	s := &modelsSnapshot{
+		types:  make(map[KindID]reflect.Type, len(old.types)+1),
+		plans:  make(map[KindID]*StructPlan, len(old.plans)+1),
+		codecs: make(map[KindID]*TypeCodec, len(old.codecs)+1),
+	}
+	for kid, rt := range old.types {
+		s.types[kid] = rt
+	}
+	for kid, plan := range old.plans {
+		s.plans[kid] = plan
+	}
+	for kid, codec := range old.codecs {
+		s.codecs[kid] = codec
+	}
+	f(s)
+	m.snap.Store(s)
+}
+
+// Type model的go类型
+func (m *Models) Type(kid KindID) (reflect.Type, bool) {
+	rt, ok := m.load().types[kid]
+	return rt, ok
+}
+
+// Plan 结构体model的编解码计划
+func (m *Models) Plan(kid KindID) (*StructPlan, bool) {
+	plan, ok := m.load().plans[kid]
+	return plan, ok
+}
+
+// Codec 自定义编解码类型的编解码
+func (m *Models) Codec(kid KindID) (*TypeCodec, bool) {
+	codec, ok := m.load().codecs[kid]
+	return codec, ok
+}
+
+// KindIDs 所有已注册model的kindID，顺序不固定
+func (m *Models) KindIDs() []KindID {
+	types := m.load().types
+	kids := make([]KindID, 0, len(types))
+	for kid := range types {
+		kids = append(kids, kid)
+	}
+	return kids
+}
+
+// Clone 返回包含相同model的新Models，之后两者的修改互不影响
+func (m *Models) Clone() *Models {
+	c := &Models{}
+	c.snap.Store(m.load())
+	return c
+}
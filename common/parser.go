@@ -2,12 +2,10 @@ package common
 
 import (
 	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"log"
 	"math"
 	"reflect"
-	"strconv"
 )
 
 var (
@@ -15,11 +13,8 @@ var (
 	ErrNotMatchedStruct      = errors.New("not matched struct")
 	ErrNotMatchedBody        = errors.New("not matched body")
 	ErrUnsupportedMapKeyType = errors.New("unsupported map key type")
-)
-
-const (
-	SliceKidsLen = 2
-	MapKidsLen   = 3
+	ErrNotMatchedParam       = errors.New("not matched param")
+	ErrInvalidKids           = errors.New("invalid kids")
 )
 
 type Parser struct {
@@ -30,366 +25,349 @@ func NewParser(models *Models) *Parser {
 	return &Parser{models: models}
 }
 
-func (p *Parser) ParseToStruct(js []byte, kid KindID) (interface{}, error) {
-	// 检查是否是已经注册的类型
-	rt, ok := p.models.ModelMap[kid]
-	if !ok {
-		return nil, ErrNotRegisteredStruct
+// ParseBody 按照descs顺序单次向前解析body。slice、map带有元素数量，结构体带有字段数量，无需扫描分隔符
+// nil slice、map、指针解析为nil
+func (p *Parser) ParseBody(body []byte, descs []*TypeDesc) ([]interface{}, error) {
+	if len(descs) == 0 {
+		return nil, nil
 	}
 
-	// 解析json为map[string]interface{}
-	m := make(map[string]interface{})
-	err := json.Unmarshal(js, &m)
-	if err != nil {
-		return nil, err
-	}
+	var index int
+	r := make([]interface{}, len(descs))
+	for i, d := range descs {
+		// 基本类型直接解析，无需反射
+		if size := fixedKindSize(d.Kind); size > 0 && d.Type == basicKindTypes[d.Kind] {
+			if len(body)-index < size {
+				return nil, ErrNotMatchedBody
+			}
+			r[i] = p.parseFixed(d.Kind, body[index:index+size])
+			index += size
+			continue
+		}
 
-	// 遍历map，一个个赋值到创建的结构成员中
-	op := reflect.New(rt)
-	o := op.Elem()
-	for k, v := range m {
-		// field一直是nil的原因在于字符串中的name是小写，可是结构中的却是大写的
-		field := o.FieldByName(k)
-		err = p.assign(field, v)
+		rv := reflect.New(d.Type).Elem()
+		steps, err := p.parseInto(body[index:], d, rv)
 		if err != nil {
 			return nil, err
 		}
+		index += steps
+		r[i] = interfaceOf(rv)
 	}
 
-	// 为什么返回含slice结构的elem的Interface是空呢？
-	// 即使我更新了slice value还是如此呢?
-	// set value 之后slice确实有值啦，可是结构指针interface却还是有问题。真不明白为什么同样代码含有slice的rv指针就不行，但是不含的就行
-	// 我的猜测是因为slice还是指针
-	return o.Interface(), nil
+	return r, nil
 }
 
-func (p *Parser) assign(value reflect.Value, input interface{}) error {
-	iv := reflect.ValueOf(input)
-	if value.CanSet() {
-		switch value.Kind() {
-		case reflect.Bool:
-			if iv.Kind() != reflect.Bool {
-				return ErrNotMatchedStruct
-			}
-			value.SetBool(iv.Bool())
-
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			if iv.Kind() != reflect.Float64 {
-				return ErrNotMatchedStruct
-			}
-			value.SetInt(int64(iv.Float()))
-
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			if iv.Kind() != reflect.Float64 {
-				return ErrNotMatchedStruct
-			}
-			value.SetUint(uint64(iv.Float()))
-
-		case reflect.Float64, reflect.Float32:
-			if iv.Kind() != reflect.Float64 {
-				return ErrNotMatchedStruct
-			}
-			value.SetFloat(iv.Float())
-
-		case reflect.Complex64, reflect.Complex128:
-			if iv.Kind() != reflect.Complex64 && iv.Kind() != reflect.Complex128 {
-				return ErrNotMatchedStruct
-			}
-			value.SetComplex(iv.Complex())
-
-		case reflect.String:
-			value.SetString(iv.String())
-
-		case reflect.Slice:
-			slice, ok := input.([]interface{})
-			if !ok {
-				return ErrNotMatchedStruct
-			}
-			// slice类型.Elem()就是里面值的类型
-			//objType := value.Type().Elem()
-			// makeSlice需要的是slice的类型
-			s := reflect.MakeSlice(value.Type(), len(slice), len(slice))
-			for k, v := range slice {
-				o := s.Index(k)
-				//op := reflect.New(objType)
-				//o := op.Elem()
-				// 不需要reflect.Value的指针也能set值
-				if err := p.assign(o, v); err != nil {
-					return err
-				}
-			}
-			// value只是临时变量，改这个是没用的，只能set改变里面实际值的指针
-			value.Set(s)
-
-		case reflect.Map:
-			m, ok := input.(map[string]interface{})
-			if !ok {
-				return ErrNotMatchedStruct
-			}
+// ParseBodyInto 与ParseBody相同，但解析到dsts指向的值中，定长基本类型不分配内存。dsts需要是指向descs对应类型的指针
+func (p *Parser) ParseBodyInto(body []byte, descs []*TypeDesc, dsts ...interface{}) error {
+	if len(dsts) != len(descs) {
+		return ErrNotMatchedParam
+	}
 
-			mt := value.Type()
-			mkt := mt.Key()
-			mvt := mt.Elem()
-			rvm := reflect.MakeMapWithSize(mt, len(m))
-			for k, v := range m {
-				krvp := reflect.New(mkt)
-				krv := krvp.Elem()
-				if err := p.assignMapKey(krv, k); err != nil {
-					return err
-				}
-
-				vrvp := reflect.New(mvt)
-				vrv := vrvp.Elem()
-				if err := p.assign(vrv, v); err != nil {
-					return err
-				}
-				rvm.SetMapIndex(krv, vrv)
-			}
-			value.Set(rvm)
+	var index int
+	for i, d := range descs {
+		rt := reflect.TypeOf(dsts[i])
+		if rt == nil || rt.Kind() != reflect.Ptr || rt.Elem() != d.Type {
+			return ErrNotMatchedParam
+		}
 
-		case reflect.Struct:
-			m, ok := input.(map[string]interface{})
-			if !ok {
-				return ErrNotMatchedStruct
-			}
-			for k, v := range m {
-				field := value.FieldByName(k)
-				if field.IsValid() == false || field.CanSet() == false {
-					return ErrNotMatchedStruct
-				}
-				if err := p.assign(field, v); err != nil {
-					return err
-				}
+		if size := fixedKindSize(d.Kind); size > 0 && d.Type == basicKindTypes[d.Kind] {
+			if len(body)-index < size {
+				return ErrNotMatchedBody
 			}
+			p.setBasic(body[index:index+size], dsts[i])
+			index += size
+			continue
+		}
 
-		default:
-			return ErrNotMatchedStruct
+		rv := reflect.ValueOf(dsts[i])
+		if rv.IsNil() {
+			return ErrNotMatchedParam
 		}
-	} else {
-		return ErrNotMatchedStruct
+		steps, err := p.parseInto(body[index:], d, rv.Elem())
+		if err != nil {
+			return err
+		}
+		index += steps
 	}
 
 	return nil
 }
 
-func (p *Parser) assignMapKey(value reflect.Value, input string) error {
-	if value.CanSet() {
-		switch value.Kind() {
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			parseInt, err := strconv.ParseInt(input, 10, 64)
-			if err != nil {
-				return err
-			}
-			value.SetInt(parseInt)
-
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			parseUint, err := strconv.ParseUint(input, 10, 64)
-			if err != nil {
-				return err
-			}
-			value.SetUint(parseUint)
-
-		case reflect.Float64, reflect.Float32:
-			parseFloat, err := strconv.ParseFloat(input, 64)
-			if err != nil {
-				return err
-			}
-			value.SetFloat(parseFloat)
-
-		case reflect.String:
-			value.SetString(input)
+// setBasic 解析定长基本类型到dst指向的值，items长度已检查
+func (p *Parser) setBasic(items []byte, dst interface{}) {
+	switch v := dst.(type) {
+	case *bool:
+		*v = p.parseBool(items[0])
+	case *int8:
+		*v = p.parseInt8(items[0])
+	case *uint8:
+		*v = p.parseUint8(items[0])
+	case *int16:
+		*v = p.parseInt16(items)
+	case *uint16:
+		*v = p.parseUint16(items)
+	case *int32:
+		*v = p.parseInt32(items)
+	case *uint32:
+		*v = p.parseUint32(items)
+	case *float32:
+		*v = p.parseFloat32(items)
+	case *int64:
+		*v = p.parseInt64(items)
+	case *uint64:
+		*v = p.parseUint64(items)
+	case *float64:
+		*v = p.parseFloat64(items)
+	case *int:
+		*v = p.parseInt(items)
+	case *uint:
+		*v = p.parseUint(items)
+	}
+}
 
-		default:
-			return ErrNotMatchedStruct
+func interfaceOf(rv reflect.Value) interface{} {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Ptr:
+		if rv.IsNil() {
+			return nil
 		}
-	} else {
-		return ErrNotMatchedStruct
 	}
-
-	return nil
+	return rv.Interface()
 }
 
-// kids还剩的情况就忽略吧
-func (p *Parser) ParseBody(body []byte, kids []KindID) ([]interface{}, error) {
-	if len(kids) == 0 {
-		return nil, nil
+// parseInto 按照d解析值并设置到rv，返回消耗的byte数。按Kind设置，因此支持type Status int这类自定义类型
+func (p *Parser) parseInto(body []byte, d *TypeDesc, rv reflect.Value) (int, error) {
+	if size := fixedKindSize(d.Kind); size > 0 {
+		if len(body) < size {
+			return 0, ErrNotMatchedBody
+		}
+		p.setFixed(d.Kind, body[:size], rv)
+		return size, nil
 	}
 
-	var index int
-	var items []byte
-	var item byte
-	r := make([]interface{}, 0, len(kids))
-	var kid KindID
-	for i := 0; i < len(kids); {
-		kid = kids[i]
-		switch kid {
-		case Bool:
-			item = body[index]
-			r = append(r, p.parseBool(item))
-			index++
-
-		case Int8:
-			item = body[index]
-			r = append(r, p.parseInt8(item))
-			index++
-
-		case Uint8:
-			item = body[index]
-			r = append(r, p.parseUint8(item))
-			index++
-
-		case Int16:
-			items = body[index : index+2]
-			r = append(r, p.parseInt16(items))
-			index += 2
-
-		case Uint16:
-			items = body[index : index+2]
-			r = append(r, p.parseUint16(items))
-			index += 2
-
-		case Int32:
-			items = body[index : index+4]
-			r = append(r, p.parseInt32(items))
-			index += 4
-
-		case Uint32:
-			items = body[index : index+4]
-			r = append(r, p.parseUint32(items))
-			index += 4
-
-		case Float32:
-			items = body[index : index+4]
-			r = append(r, p.parseFloat32(items))
-			index += 4
-
-		case Int64:
-			items = body[index : index+8]
-			r = append(r, p.parseInt64(items))
-			index += 8
-
-		case Uint64:
-			items = body[index : index+8]
-			r = append(r, p.parseUint64(items))
-			index += 8
-
-		case Float64:
-			items = body[index : index+8]
-			r = append(r, p.parseFloat64(items))
-			index += 8
-
-		case Int:
-			// 解析int，全部定为int64
-			items = body[index : index+8]
-			r = append(r, p.parseInt(items))
-			index += 8
-
-		case String:
-			// 确保当前byte为分隔符
-			item = body[index]
-			if item != StringSep {
-				log.Printf("common parser: find string start with %b", item)
-				return nil, ErrNotMatchedBody
-			}
+	switch d.Kind {
+	case String:
+		// 长度前缀后即为字符串内容
+		s, steps, err := p.parseString(body)
+		if err != nil {
+			return 0, err
+		}
+		rv.SetString(s)
+		return steps, nil
 
-			// 找到后一个分隔符位置，并返回index
-			// 假设[]byte要是特别大，怎么办呢？
-			s, steps := p.parseString(body[index:])
-			r = append(r, s)
+	case Bytes:
+		b, steps, err := p.parseBytes(body)
+		if err != nil {
+			return 0, err
+		}
+		rv.SetBytes(b)
+		return steps, nil
 
-			index = index + steps
+	case Time, Duration, BigInt, BigFloat, BigRat, UUID:
+		return p.parseWellKnown(body, d.Kind, rv)
 
-		case Slice:
-			// slice前8个byte指示slice的长度
-			lb := body[index : index+8]
-			ul := binary.BigEndian.Uint64(lb)
-			l := int(ul)
-			index += 8
+	case Slice:
+		l, isNil, index, err := p.parseLen(body)
+		if err != nil {
+			return 0, err
+		}
+		if isNil {
+			rv.Set(reflect.Zero(rv.Type()))
+			return index, nil
+		}
 
-			if l == 0 {
-				r = append(r, nil)
-				i += SliceKidsLen
-				index++
-				continue
+		// 元素为定长基本类型时批量解析
+		if size := fixedKindSize(d.Elem.Kind); size > 0 && d.Elem.Type == basicKindTypes[d.Elem.Kind] {
+			end := index + l*size
+			if end > len(body) {
+				return 0, ErrNotMatchedBody
 			}
+			rv.Set(reflect.ValueOf(p.parseFixedSlice(d.Elem.Kind, body[index:end], l)))
+			return end, nil
+		}
 
-			i++
-			kid = kids[i]
-			span, err := FindEndForPeerSepInByteArray(body[index:], 0, SliceLeftSep, SliceRightSep)
-			if err != nil {
-				log.Printf("common parser: find slice %s end fialed for %s", body[index:], err)
-				return nil, ErrNotMatchedBody
-			}
-			s := p.parseSlice(kid, body[index+1:index+span], l)
-			r = append(r, s)
-
-			index = index + span + 1
-
-		case Map:
-			// slice前8个byte指示slice的长度
-			lb := body[index : index+8]
-			ul := binary.BigEndian.Uint64(lb)
-			l := int(ul)
-			index += 8
-
-			if l == 0 {
-				r = append(r, nil)
-				i += MapKidsLen
-				index++
-				continue
-			}
+		s := reflect.MakeSlice(rv.Type(), l, l)
+		index, err = p.parseElems(body, index, d.Elem, s)
+		if err != nil {
+			return 0, err
+		}
+		rv.Set(s)
+		return index, nil
 
-			// 获取map k、v kindID
-			i++
-			// TODO: 如果超出就panic吧！
-			keyKid := kids[i]
+	case Array:
+		l, isNil, index, err := p.parseLen(body)
+		if err != nil {
+			return 0, err
+		}
+		if isNil || l != rv.Len() {
+			return 0, ErrNotMatchedBody
+		}
+		return p.parseElems(body, index, d.Elem, rv)
 
-			i++
-			elemKid := kids[i]
+	case Ptr:
+		// 1个byte标记是否为nil
+		if len(body) < 1 || body[0] > 1 {
+			return 0, ErrNotMatchedBody
+		}
+		if body[0] == 0 {
+			rv.Set(reflect.Zero(rv.Type()))
+			return 1, nil
+		}
+		e := reflect.New(rv.Type().Elem())
+		steps, err := p.parseInto(body[1:], d.Elem, e.Elem())
+		if err != nil {
+			return 0, err
+		}
+		rv.Set(e)
+		return steps + 1, nil
+
+	case Map:
+		l, isNil, index, err := p.parseLen(body)
+		if err != nil {
+			return 0, err
+		}
+		if isNil {
+			rv.Set(reflect.Zero(rv.Type()))
+			return index, nil
+		}
 
-			span, err := FindEndForPeerSepInByteArray(body[index:], 0, JsonLeftSep, JsonRightSep)
+		mt := rv.Type()
+		m := reflect.MakeMapWithSize(mt, l)
+		for j := 0; j < l; j++ {
+			k := reflect.New(mt.Key()).Elem()
+			steps, err := p.parseInto(body[index:], d.Key, k)
 			if err != nil {
-				log.Printf("common parser: find map %s end fialed for %s", body[index:], err)
-				return nil, ErrNotMatchedStruct
+				return 0, err
 			}
-			m, err := p.parseMap(keyKid, elemKid, body[index+1:index+span], l)
+			index += steps
+
+			v := reflect.New(mt.Elem()).Elem()
+			steps, err = p.parseInto(body[index:], d.Elem, v)
 			if err != nil {
-				return nil, err
+				return 0, err
 			}
+			index += steps
+			m.SetMapIndex(k, v)
+		}
+		rv.Set(m)
+		return index, nil
 
-			r = append(r, m)
-			index = index + span + 1
+	// default 认为是自定义编解码类型或者结构体
+	default:
+		if codec, ok := p.getCodec(d.Kind); ok {
+			return p.parseCodecInto(body, codec, rv)
+		}
+		plan, err := p.getPlan(d.Kind)
+		if err != nil {
+			return 0, err
+		}
+		return p.parseStructInto(body, plan, rv)
+	}
+}
 
-		// default 认为是结构体
-		default:
-			rs, steps, err := p.parseStruct(body[index:], kid)
-			if err != nil {
-				return nil, err
-			}
-			r = append(r, rs)
-			index = index + steps
+// parseElems 依次解析slice、array的各元素，返回解析结束的位置
+func (p *Parser) parseElems(body []byte, index int, ed *TypeDesc, rv reflect.Value) (int, error) {
+	for j := 0; j < rv.Len(); j++ {
+		steps, err := p.parseInto(body[index:], ed, rv.Index(j))
+		if err != nil {
+			return 0, err
 		}
-		i++
+		index += steps
 	}
+	return index, nil
+}
 
-	return r, nil
+// fixedKindSize 返回定长类型编码后的byte数，非定长类型返回0
+func fixedKindSize(kid KindID) int {
+	switch kid {
+	case Bool, Int8, Uint8:
+		return 1
+	case Int16, Uint16:
+		return 2
+	case Int32, Uint32, Float32:
+		return 4
+	case Int64, Uint64, Float64, Int, Uint:
+		// int、uint全部定为64位
+		return 8
+	}
+	return 0
 }
 
-func (p *Parser) parseStruct(body []byte, kid KindID) (interface{}, int, error) {
-	item := body[0]
-	if item != JsonLeftSep {
-		log.Printf("common parser: find struct start with %b", item)
-		return nil, 0, ErrNotMatchedBody
+func (p *Parser) parseFixed(kid KindID, items []byte) interface{} {
+	switch kid {
+	case Bool:
+		return p.parseBool(items[0])
+	case Int8:
+		return p.parseInt8(items[0])
+	case Uint8:
+		return p.parseUint8(items[0])
+	case Int16:
+		return p.parseInt16(items)
+	case Uint16:
+		return p.parseUint16(items)
+	case Int32:
+		return p.parseInt32(items)
+	case Uint32:
+		return p.parseUint32(items)
+	case Float32:
+		return p.parseFloat32(items)
+	case Int64:
+		return p.parseInt64(items)
+	case Uint64:
+		return p.parseUint64(items)
+	case Float64:
+		return p.parseFloat64(items)
+	case Int:
+		return p.parseInt(items)
+	default:
+		return p.parseUint(items)
 	}
+}
 
-	span, _ := FindEndForPeerSepInByteArray(body, 0, JsonLeftSep, JsonRightSep)
-	items := body[:span+1]
-	rs, err := p.ParseToStruct(items, kid)
-	if err != nil {
-		return nil, 0, err
+func (p *Parser) setFixed(kid KindID, items []byte, rv reflect.Value) {
+	switch kid {
+	case Bool:
+		rv.SetBool(p.parseBool(items[0]))
+	case Int8:
+		rv.SetInt(int64(p.parseInt8(items[0])))
+	case Uint8:
+		rv.SetUint(uint64(items[0]))
+	case Int16:
+		rv.SetInt(int64(p.parseInt16(items)))
+	case Uint16:
+		rv.SetUint(uint64(p.parseUint16(items)))
+	case Int32:
+		rv.SetInt(int64(p.parseInt32(items)))
+	case Uint32:
+		rv.SetUint(uint64(p.parseUint32(items)))
+	case Float32:
+		rv.SetFloat(float64(p.parseFloat32(items)))
+	case Int64, Int:
+		rv.SetInt(p.parseInt64(items))
+	case Uint64, Uint:
+		rv.SetUint(p.parseUint64(items))
+	case Float64:
+		rv.SetFloat(p.parseFloat64(items))
 	}
+}
 
-	return rs, span + 1, nil
+// parseLen 解析slice、map的varint数量前缀。前缀为数量+1，0表示nil
+func (p *Parser) parseLen(body []byte) (int, bool, int, error) {
+	ul, n := binary.Uvarint(body)
+	if n <= 0 {
+		return 0, false, 0, ErrNotMatchedBody
+	}
+	if ul == 0 {
+		return 0, true, n, nil
+	}
+
+	// 每个元素至少占用1个byte，数量不可能超过剩余body长度
+	l := ul - 1
+	if l > uint64(len(body)-n) {
+		log.Printf("common parser: wrong count prefix %d", l)
+		return 0, false, 0, ErrNotMatchedBody
+	}
+	return int(l), false, n, nil
 }
 
 func (p *Parser) parseBool(item byte) bool {
@@ -444,413 +422,347 @@ func (p *Parser) parseInt(items []byte) int {
 	return int(binary.BigEndian.Uint64(items))
 }
 
-func (p *Parser) parseString(body []byte) (string, int) {
-	span := FindEndForSepInByteArray(body, 0, StringSep)
-	return string(body[1:span]), span + 1
+func (p *Parser) parseUint(items []byte) uint {
+	return uint(binary.BigEndian.Uint64(items))
 }
 
-// body 是去除map括号的map值
-func (p *Parser) parseMap(kkid, vkid KindID, body []byte, l int) (interface{}, error) {
-	if l == 0 {
-		return nil, nil
-	}
-
-	// 获取key reflect.Type
-	keyType, err := p.getBasicType(kkid)
+func (p *Parser) parseString(body []byte) (string, int, error) {
+	b, steps, err := p.readLenPrefixed(body)
 	if err != nil {
-		return nil, err
-	}
-
-	// 构建key、value kids序列
-	mkids := make([]KindID, l*2)
-	for j := 0; j < len(mkids); {
-		mkids[j] = kkid
-		j++
-		mkids[j] = vkid
-		j++
+		return "", 0, err
 	}
+	return string(b), steps, nil
+}
 
-	// 获取key、value序列
-	mapItems, err := p.ParseBody(body, mkids)
+// parseBytes 解析varint长度前缀的内容，返回内容以及消耗的byte数。返回的内容为复制
+func (p *Parser) parseBytes(body []byte) ([]byte, int, error) {
+	b, steps, err := p.readLenPrefixed(body)
 	if err != nil {
-		return nil, err
-	}
-
-	// 组装map
-	m := reflect.MakeMapWithSize(reflect.MapOf(keyType, p.models.ModelMap[vkid]), l)
-	for i := 0; i < 2*l; i += 2 {
-		m.SetMapIndex(reflect.ValueOf(mapItems[i]), reflect.ValueOf(mapItems[i+1]))
+		return nil, 0, err
 	}
 
-	// 返回map
-	return m.Interface(), nil
+	r := make([]byte, len(b))
+	copy(r, b)
+	return r, steps, nil
 }
 
-func (p *Parser) getBasicType(kid KindID) (reflect.Type, error) {
-	switch kid {
-	case Bool:
-		return reflect.TypeOf(false), nil
-	case Int8:
-		return reflect.TypeOf(int8(0)), nil
-	case Uint8:
-		return reflect.TypeOf(uint8(0)), nil
-	case Int16:
-		return reflect.TypeOf(int16(0)), nil
-	case Uint16:
-		return reflect.TypeOf(uint16(0)), nil
-	case Int32:
-		return reflect.TypeOf(int32(0)), nil
-	case Uint32:
-		return reflect.TypeOf(uint32(0)), nil
-	case Float32:
-		return reflect.TypeOf(float32(0)), nil
-	case Int64:
-		return reflect.TypeOf(int64(0)), nil
-	case Uint64:
-		return reflect.TypeOf(uint64(0)), nil
-	case Float64:
-		return reflect.TypeOf(float64(0)), nil
-	case Int:
-		return reflect.TypeOf(0), nil
-	case String:
-		return reflect.TypeOf(""), nil
-	default:
-		return nil, ErrUnsupportedMapKeyType
+// readLenPrefixed 返回varint长度前缀之后的内容，不复制
+func (p *Parser) readLenPrefixed(body []byte) ([]byte, int, error) {
+	l, n := binary.Uvarint(body)
+	if n <= 0 || uint64(len(body)-n) < l {
+		log.Printf("common parser: wrong length prefix %d", l)
+		return nil, 0, ErrNotMatchedBody
 	}
-}
 
-func (p *Parser) parseSlice(ekid KindID, body []byte, l int) interface{} {
-	if l == 0 {
-		return nil
-	}
+	end := n + int(l)
+	return body[n:end], end, nil
+}
 
+// parseFixedSlice 元素为定长类型的slice，body长度已检查
+func (p *Parser) parseFixedSlice(ekid KindID, body []byte, l int) interface{} {
 	switch ekid {
 	case Bool:
 		r := make([]bool, l)
-		for i := 0; i < l; i++ {
+		for i := range r {
 			r[i] = p.parseBool(body[i])
 		}
 		return r
 
 	case Int8:
 		r := make([]int8, l)
-		for i := 0; i < l; i++ {
+		for i := range r {
 			r[i] = p.parseInt8(body[i])
 		}
 		return r
 
 	case Uint8:
 		r := make([]uint8, l)
-		for i := 0; i < l; i++ {
-			r[i] = p.parseUint8(body[i])
-		}
+		copy(r, body)
 		return r
 
 	case Int16:
 		r := make([]int16, l)
-		var j int
-		for i := 0; i < l; i++ {
-			r[i] = p.parseInt16(body[j : j+2])
-			j += 2
+		for i := range r {
+			r[i] = p.parseInt16(body[i*2:])
 		}
 		return r
 
 	case Uint16:
 		r := make([]uint16, l)
-		var j int
-		for i := 0; i < l; i++ {
-			r[i] = p.parseUint16(body[j : j+2])
-			j += 2
+		for i := range r {
+			r[i] = p.parseUint16(body[i*2:])
 		}
 		return r
 
 	case Int32:
 		r := make([]int32, l)
-		var j int
-		for i := 0; i < l; i++ {
-			r[i] = p.parseInt32(body[j : j+4])
-			j += 4
+		for i := range r {
+			r[i] = p.parseInt32(body[i*4:])
 		}
 		return r
 
 	case Uint32:
 		r := make([]uint32, l)
-		var j int
-		for i := 0; i < l; i++ {
-			r[i] = p.parseUint32(body[j : j+4])
-			j += 4
+		for i := range r {
+			r[i] = p.parseUint32(body[i*4:])
 		}
 		return r
 
 	case Float32:
 		r := make([]float32, l)
-		var j int
-		for i := 0; i < l; i++ {
-			r[i] = p.parseFloat32(body[j : j+4])
-			j += 4
+		for i := range r {
+			r[i] = p.parseFloat32(body[i*4:])
 		}
 		return r
 
 	case Int64:
 		r := make([]int64, l)
-		var j int
-		for i := 0; i < l; i++ {
-			r[i] = p.parseInt64(body[j : j+8])
-			j += 8
+		for i := range r {
+			r[i] = p.parseInt64(body[i*8:])
 		}
 		return r
 
 	case Uint64:
 		r := make([]uint64, l)
-		var j int
-		for i := 0; i < l; i++ {
-			r[i] = p.parseUint64(body[j : j+8])
-			j += 8
+		for i := range r {
+			r[i] = p.parseUint64(body[i*8:])
 		}
 		return r
 
 	case Float64:
 		r := make([]float64, l)
-		var j int
-		for i := 0; i < l; i++ {
-			r[i] = p.parseFloat64(body[j : j+8])
-			j += 8
+		for i := range r {
+			r[i] = p.parseFloat64(body[i*8:])
 		}
 		return r
 
 	case Int:
-		// 解析int，全部定为int64
 		r := make([]int, l)
-		var j int
-		for i := 0; i < l; i++ {
-			r[i] = p.parseInt(body[j : j+8])
-			j += 8
-		}
-		return r
-
-	case String:
-		r := make([]string, l)
-		var j int
-		var steps int
-		for i := 0; i < l; i++ {
-			r[i], steps = p.parseString(body[j:])
-			j += steps
+		for i := range r {
+			r[i] = p.parseInt(body[i*8:])
 		}
 		return r
 
 	default:
-		rv := p.models.ModelMap[ekid]
-		r := reflect.MakeSlice(reflect.SliceOf(rv), l, l)
-		var j int
-		for i := 0; i < l; i++ {
-			s, steps, err := p.parseStruct(body[j:], ekid)
-			if err != nil {
-				return nil
-			}
-			j += steps
-			r.Index(i).Set(reflect.ValueOf(s))
+		r := make([]uint, l)
+		for i := range r {
+			r[i] = p.parseUint(body[i*8:])
 		}
-		return r.Interface()
+		return r
 	}
 }
 
-func (p *Parser) EncodeBody(kids []KindID, params ...interface{}) ([]byte, error) {
-	var kid KindID
-	var index int
-	r := make([]byte, 0)
-	var item byte
-	var items []byte
+// EncodeBody 按照descs顺序编码params。slice、map以varint(数量+1)为前缀，0表示nil；指针以1个byte标记是否为nil；
+// 结构体按照StructPlan逐字段编码
+func (p *Parser) EncodeBody(descs []*TypeDesc, params ...interface{}) ([]byte, error) {
+	return p.AppendBody(nil, descs, params...)
+}
+
+// AppendBody 与EncodeBody相同，编码结果追加到r之后，r可以来自GetBuffer
+func (p *Parser) AppendBody(r []byte, descs []*TypeDesc, params ...interface{}) ([]byte, error) {
+	if len(params) != len(descs) {
+		return nil, ErrNotMatchedParam
+	}
+
 	var err error
-	for i := 0; i < len(kids); index++ {
-		kid = kids[i]
-		switch kid {
-		case Bool:
-			if params[index].(bool) == false {
-				item = byte(0)
-			} else {
-				item = byte(1)
-			}
-			r = append(r, item)
-
-		case Int8:
-			item = byte(params[index].(int8))
-			r = append(r, item)
-
-		case Uint8:
-			item = params[index].(uint8)
-			r = append(r, item)
-
-		case Int16:
-			items = make([]byte, 2)
-			binary.BigEndian.PutUint16(items, uint16(params[index].(int16)))
-			r = append(r, items...)
-
-		case Uint16:
-			items = make([]byte, 2)
-			binary.BigEndian.PutUint16(items, params[index].(uint16))
-			r = append(r, items...)
-
-		case Int32:
-			items = make([]byte, 4)
-			binary.BigEndian.PutUint32(items, uint32(params[index].(int32)))
-			r = append(r, items...)
-
-		case Uint32:
-			items = make([]byte, 4)
-			binary.BigEndian.PutUint32(items, params[index].(uint32))
-			r = append(r, items...)
-
-		case Float32:
-			items = make([]byte, 4)
-			binary.BigEndian.PutUint32(items, math.Float32bits(params[index].(float32)))
-			r = append(r, items...)
-
-		case Int64:
-			items = make([]byte, 8)
-			binary.BigEndian.PutUint64(items, uint64(params[index].(int64)))
-			r = append(r, items...)
-
-		case Uint64:
-			items = make([]byte, 8)
-			binary.BigEndian.PutUint64(items, params[index].(uint64))
-			r = append(r, items...)
-
-		case Float64:
-			items = make([]byte, 8)
-			binary.BigEndian.PutUint64(items, math.Float64bits(params[index].(float64)))
-			r = append(r, items...)
-
-		case Int:
-			// 解析int，全部定为int64
-			items = make([]byte, 8)
-			binary.BigEndian.PutUint64(items, uint64(params[index].(int)))
-			r = append(r, items...)
-
-		case String:
-			// 确保当前byte为分隔符
-			items = p.encodeString(params[index].(string))
-			r = append(r, items...)
-
-		case Slice:
-			items = make([]byte, 8)
-
-			// 判断是否是nil
-			if params[index] == nil {
-				binary.BigEndian.PutUint64(items, 0)
-				i += SliceKidsLen
-				r = append(r, items...)
-				r = append(r, NilFlag)
+	for i, d := range descs {
+		// 基本类型直接编码，无需反射
+		if d.Type == basicKindTypes[d.Kind] && reflect.TypeOf(params[i]) == d.Type {
+			var ok bool
+			if r, ok = p.appendBasic(r, params[i]); ok {
 				continue
 			}
+		}
+		r, err = p.appendReflect(r, d, reflect.ValueOf(params[i]))
+		if err != nil {
+			return nil, err
+		}
+	}
 
-			// 获取长度
-			sv := reflect.ValueOf(params[index])
-			l := sv.Len()
-			if l == 0 {
-				binary.BigEndian.PutUint64(items, 0)
-				i += SliceKidsLen
-				r = append(r, items...)
-				r = append(r, EmptyFlag)
-				continue
-			}
+	return r, nil
+}
 
-			// 放入长度
-			ul := uint64(l)
-			binary.BigEndian.PutUint64(items, ul)
-			r = append(r, items...)
-
-			// 递归编码
-			r = append(r, SliceLeftSep)
-
-			i++
-			elemKid := kids[i]
-			for j := 0; j < l; j++ {
-				items, err = p.EncodeBody([]KindID{elemKid}, sv.Index(j).Interface())
-				if err != nil {
-					return nil, err
-				}
-				r = append(r, items...)
-			}
+// appendBasic param为基本类型时直接编码，调用方已检查param类型与描述一致
+func (p *Parser) appendBasic(r []byte, param interface{}) ([]byte, bool) {
+	switch v := param.(type) {
+	case bool:
+		if v {
+			return append(r, byte(1)), true
+		}
+		return append(r, byte(0)), true
+	case int8:
+		return append(r, byte(v)), true
+	case uint8:
+		return append(r, v), true
+	case int16:
+		return binary.BigEndian.AppendUint16(r, uint16(v)), true
+	case uint16:
+		return binary.BigEndian.AppendUint16(r, v), true
+	case int32:
+		return binary.BigEndian.AppendUint32(r, uint32(v)), true
+	case uint32:
+		return binary.BigEndian.AppendUint32(r, v), true
+	case float32:
+		return binary.BigEndian.AppendUint32(r, math.Float32bits(v)), true
+	case int64:
+		return binary.BigEndian.AppendUint64(r, uint64(v)), true
+	case int:
+		return binary.BigEndian.AppendUint64(r, uint64(v)), true
+	case uint64:
+		return binary.BigEndian.AppendUint64(r, v), true
+	case uint:
+		return binary.BigEndian.AppendUint64(r, uint64(v)), true
+	case float64:
+		return binary.BigEndian.AppendUint64(r, math.Float64bits(v)), true
+	case string:
+		return p.appendString(r, v), true
+	}
+	return r, false
+}
 
-			r = append(r, SliceRightSep)
+// appendReflect 按照d编码rv。按Kind取值，因此支持type Status int这类自定义类型
+func (p *Parser) appendReflect(r []byte, d *TypeDesc, rv reflect.Value) ([]byte, error) {
+	// interface{}取实际值
+	for rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return p.appendNil(r, d)
+	}
+	if rv.Type() != d.Type && !kindMatched(d, rv.Type()) {
+		log.Printf("common parser: param %s not matched kind %d", rv.Type(), d.Kind)
+		return nil, ErrNotMatchedParam
+	}
 
-		case Map:
-			items = make([]byte, 8)
+	var err error
+	switch d.Kind {
+	case Bool:
+		if rv.Bool() {
+			r = append(r, byte(1))
+		} else {
+			r = append(r, byte(0))
+		}
+	case Int8:
+		r = append(r, byte(rv.Int()))
+	case Uint8:
+		r = append(r, byte(rv.Uint()))
+	case Int16:
+		r = binary.BigEndian.AppendUint16(r, uint16(rv.Int()))
+	case Uint16:
+		r = binary.BigEndian.AppendUint16(r, uint16(rv.Uint()))
+	case Int32:
+		r = binary.BigEndian.AppendUint32(r, uint32(rv.Int()))
+	case Uint32:
+		r = binary.BigEndian.AppendUint32(r, uint32(rv.Uint()))
+	case Float32:
+		r = binary.BigEndian.AppendUint32(r, math.Float32bits(float32(rv.Float())))
+	case Int64, Int:
+		// int全部定为int64
+		r = binary.BigEndian.AppendUint64(r, uint64(rv.Int()))
+	case Uint64, Uint:
+		r = binary.BigEndian.AppendUint64(r, rv.Uint())
+	case Float64:
+		r = binary.BigEndian.AppendUint64(r, math.Float64bits(rv.Float()))
+	case String:
+		r = p.appendString(r, rv.String())
+	case Bytes:
+		r = p.appendBytes(r, rv.Bytes())
+	case Time, Duration, BigInt, BigFloat, BigRat, UUID:
+		return p.appendWellKnown(r, d.Kind, rv)
+
+	case Slice:
+		if rv.IsNil() {
+			return binary.AppendUvarint(r, 0), nil
+		}
+		r = binary.AppendUvarint(r, uint64(rv.Len())+1)
+		return p.appendElems(r, d.Elem, rv)
 
-			// nil
-			if params[index] == nil {
-				binary.BigEndian.PutUint64(items, 0)
-				i += MapKidsLen
-				r = append(r, items...)
-				r = append(r, NilFlag)
-				continue
-			}
+	case Array:
+		r = binary.AppendUvarint(r, uint64(rv.Len())+1)
+		return p.appendElems(r, d.Elem, rv)
 
-			// 获取长度
-			sv := reflect.ValueOf(params[index])
-			l := sv.Len()
-			if l == 0 {
-				binary.BigEndian.PutUint64(items, 0)
-				i += MapKidsLen
-				r = append(r, items...)
-				r = append(r, EmptyFlag)
-				continue
-			}
+	case Ptr:
+		if rv.IsNil() {
+			return append(r, byte(0)), nil
+		}
+		return p.appendReflect(append(r, byte(1)), d.Elem, rv.Elem())
 
-			// 放入长度
-			ul := uint64(l)
-			binary.BigEndian.PutUint64(items, ul)
-			r = append(r, items...)
-
-			// 递归编码
-			r = append(r, JsonLeftSep)
-			i++
-			keyKid := kids[i]
-
-			i++
-			elemKid := kids[i]
-
-			iter := sv.MapRange()
-			for iter.Next() {
-				k := iter.Key().Interface()
-				items, err = p.EncodeBody([]KindID{keyKid}, k)
-				if err != nil {
-					return nil, err
-				}
-				r = append(r, items...)
-
-				v := iter.Value().Interface()
-				items, err = p.EncodeBody([]KindID{elemKid}, v)
-				if err != nil {
-					return nil, err
-				}
-				r = append(r, items...)
+	case Map:
+		if rv.IsNil() {
+			return binary.AppendUvarint(r, 0), nil
+		}
+		r = binary.AppendUvarint(r, uint64(rv.Len())+1)
+		// 复用key、value，避免每个元素复制时分配内存
+		k := reflect.New(rv.Type().Key()).Elem()
+		v := reflect.New(rv.Type().Elem()).Elem()
+		iter := rv.MapRange()
+		for iter.Next() {
+			k.SetIterKey(iter)
+			v.SetIterValue(iter)
+			r, err = p.appendReflect(r, d.Key, k)
+			if err != nil {
+				return nil, err
 			}
-
-			r = append(r, JsonRightSep)
-
-		// default 认为是结构体
-		default:
-			items, err = json.Marshal(params[index])
+			r, err = p.appendReflect(r, d.Elem, v)
 			if err != nil {
 				return nil, err
 			}
-			r = append(r, items...)
+		}
 
+	// default 认为是自定义编解码类型或者结构体
+	default:
+		if codec, ok := p.getCodec(d.Kind); ok {
+			return p.appendCodecValue(r, codec, rv)
 		}
-		i++
+		plan, err := p.getPlan(d.Kind)
+		if err != nil {
+			return nil, err
+		}
+		return p.appendStructValue(r, plan, rv)
 	}
 
 	return r, nil
 }
 
+// appendNil 编码nil值，只有slice、map、指针以及[]byte可以为nil
+func (p *Parser) appendNil(r []byte, d *TypeDesc) ([]byte, error) {
+	switch d.Kind {
+	case Slice, Map:
+		return binary.AppendUvarint(r, 0), nil
+	case Ptr:
+		return append(r, byte(0)), nil
+	case Bytes:
+		return p.appendBytes(r, nil), nil
+	}
+
+	log.Printf("common parser: nil param not matched kind %d", d.Kind)
+	return nil, ErrNotMatchedParam
+}
+
+func (p *Parser) appendElems(r []byte, ed *TypeDesc, rv reflect.Value) ([]byte, error) {
+	var err error
+	for j := 0; j < rv.Len(); j++ {
+		r, err = p.appendReflect(r, ed, rv.Index(j))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// encodeString 字符串以varint长度为前缀，内容可以包含任意byte
 func (p *Parser) encodeString(s string) []byte {
-	r := make([]byte, len(s)+2)
-	r[0] = StringSep
-	copy(r[1:len(r)-1], s)
-	r[len(r)-1] = StringSep
+	return p.appendString(make([]byte, 0, binary.MaxVarintLen64+len(s)), s)
+}
+
+func (p *Parser) appendString(r []byte, s string) []byte {
+	r = binary.AppendUvarint(r, uint64(len(s)))
+	return append(r, s...)
+}
 
-	return r
+func (p *Parser) appendBytes(r []byte, b []byte) []byte {
+	r = binary.AppendUvarint(r, uint64(len(b)))
+	return append(r, b...)
 }
 
 //func (p *Parser) recurAssign(m map[string]interface{}, rvp reflect.Value) error {
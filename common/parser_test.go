@@ -1,12 +1,17 @@
 package common
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
+	"math/big"
 	"reflect"
+	"strconv"
 	"testing"
+	"time"
 )
 
 type Simple struct {
@@ -207,6 +212,29 @@ type Simple struct {
 //	return m
 //}
 
+// newSimpleParser 注册Simple为ModelStartKindID
+func newSimpleParser(t testing.TB) *Parser {
+	plan, err := NewStructPlan(reflect.TypeOf(Simple{}), func(rt reflect.Type) (*TypeDesc, error) {
+		return &TypeDesc{Kind: KindMapKindID[rt.Kind()], Type: rt}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	models := &Models{}
+	models.AddModel(ModelStartKindID, plan)
+	return NewParser(models)
+}
+
+// mustDescs 由前序kindID序列构建类型描述
+func mustDescs(t testing.TB, p *Parser, kids []KindID) []*TypeDesc {
+	descs, err := NewTypeDescs(kids, p.models)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return descs
+}
+
 func TestParseBodyWithBasicTypes(t *testing.T) {
 	p := &Parser{}
 	kids := []KindID{
@@ -233,7 +261,7 @@ func TestParseBodyWithBasicTypes(t *testing.T) {
 	copy(or[2:10], f64B)
 	copy(or[10:], sBB)
 
-	r, err := p.ParseBody(or, kids)
+	r, err := p.ParseBody(or, mustDescs(t, p, kids))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -260,11 +288,7 @@ func TestParseBodyWithBasicTypes(t *testing.T) {
 }
 
 func TestParseBody(t *testing.T) {
-	p := &Parser{
-		models: &Models{ModelMap: map[KindID]reflect.Type{
-			16: reflect.TypeOf(Simple{}),
-		}},
-	}
+	p := newSimpleParser(t)
 	kids := []KindID{
 		Bool,
 		Int,
@@ -274,13 +298,14 @@ func TestParseBody(t *testing.T) {
 		Uint64,
 		Map,
 		Int64,
-		16,
+		ModelStartKindID,
 		Slice,
-		16,
+		ModelStartKindID,
 		Map,
-		16,
+		Int64,
+		ModelStartKindID,
 	}
-	body, err := p.EncodeBody(kids, true, 10, float32(3.14), "你好啊", []uint64{1, 2, 3}, map[int64]Simple{
+	body, err := p.EncodeBody(mustDescs(t, p, kids), true, 10, float32(3.14), "你好啊", []uint64{1, 2, 3}, map[int64]Simple{
 		1: {
 			Name: "hello",
 			ID:   100,
@@ -290,7 +315,7 @@ func TestParseBody(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	result, err := p.ParseBody(body, kids)
+	result, err := p.ParseBody(body, mustDescs(t, p, kids))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -361,3 +386,479 @@ func TestSliceRelated(t *testing.T) {
 	bsiv := reflect.ValueOf(bsi)
 	t.Log(bsiv.Len())
 }
+
+func TestParseStringWithSep(t *testing.T) {
+	p := &Parser{}
+	kids := []KindID{String, Bytes, Slice, String, Int8}
+	s := "a\xffb\xff"
+	b := []byte{0xFF, 0x00, '[', ']', '{', '}'}
+	body, err := p.EncodeBody(mustDescs(t, p, kids), s, b, []string{"\xff", "x\xff"}, int8(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := p.ParseBody(body, mustDescs(t, p, kids))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r[0] != s {
+		t.Fatal("string wrong")
+	}
+	if !bytes.Equal(r[1].([]byte), b) {
+		t.Fatal("bytes wrong")
+	}
+	if ss := r[2].([]string); len(ss) != 2 || ss[1] != "x\xff" {
+		t.Fatal("string slice wrong")
+	}
+	if r[3] != int8(-1) {
+		t.Fatal("int8 wrong")
+	}
+}
+
+func TestParseBodyWithSepValues(t *testing.T) {
+	p := newSimpleParser(t)
+	kids := []KindID{
+		Slice, Int,
+		Slice, Float64,
+		Map, String, Int,
+		Slice, ModelStartKindID,
+		Slice, Slice, Int64,
+		Slice, Uint8,
+		Map, Int, Slice, String,
+		Int,
+	}
+	ints := []int{91, 93, 123, 125, 0x5B5D7B7D, -1}
+	floats := []float64{math.Float64frombits(0x5B5B5B5B7D7D7D7D), 123}
+	m := map[string]int{"[": 123, "{": 91, "}]": 0}
+	ss := []Simple{{Name: "}{][", ID: 0x7B}, {Name: "", ID: 93}}
+	nested := [][]int64{{91}, nil, {}, {123, 125}}
+	ms := map[int]([]string){123: {"]"}, 91: nil}
+	params := []interface{}{ints, floats, m, ss, nested, []uint8{}, ms, 125}
+	body, err := p.EncodeBody(mustDescs(t, p, kids), params...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := p.ParseBody(body, mustDescs(t, p, kids))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != len(params) {
+		t.Fatalf("len wrong %d", len(r))
+	}
+	for i := range params {
+		if !reflect.DeepEqual(r[i], params[i]) {
+			t.Fatalf("param %d wrong: %v != %v", i, r[i], params[i])
+		}
+	}
+}
+
+func TestParseBodyTruncated(t *testing.T) {
+	p := newSimpleParser(t)
+	kids := []KindID{Slice, String, Map, Int, ModelStartKindID, Int64}
+	body, err := p.EncodeBody(mustDescs(t, p, kids), []string{"a", "[b"}, map[int]Simple{91: {Name: "{"}}, int64(123))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < len(body); i++ {
+		_, err = p.ParseBody(body[:i], mustDescs(t, p, kids))
+		if err == nil {
+			t.Fatalf("truncated body %d should fail", i)
+		}
+	}
+
+	// 数量前缀远超body长度
+	_, err = p.ParseBody([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x0F}, mustDescs(t, p, []KindID{Slice, Int}))
+	if err != ErrNotMatchedBody {
+		t.Fatalf("want ErrNotMatchedBody got %v", err)
+	}
+}
+
+func TestParseBodyWithPointer(t *testing.T) {
+	p := newSimpleParser(t)
+	kids := []KindID{
+		Ptr, Int,
+		Ptr, ModelStartKindID,
+		Slice, Ptr, String,
+		Map, String, Ptr, Slice, Int8,
+		Ptr, Ptr, Bool,
+	}
+	x := 91
+	s := "{"
+	tr := true
+	trp := &tr
+	ps := []*string{&s, nil}
+	m := map[string]*[]int8{"a": {1, 2}, "b": nil}
+	body, err := p.EncodeBody(mustDescs(t, p, kids), &x, (*Simple)(nil), ps, m, &trp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := p.ParseBody(body, mustDescs(t, p, kids))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *r[0].(*int) != 91 {
+		t.Fatal("int pointer wrong")
+	}
+	if r[1] != nil {
+		t.Fatal("nil pointer wrong")
+	}
+	if !reflect.DeepEqual(r[2], ps) || !reflect.DeepEqual(r[3], m) {
+		t.Fatal("pointer elem wrong")
+	}
+	if !**r[4].(**bool) {
+		t.Fatal("pointer to pointer wrong")
+	}
+}
+
+func TestParseBodyWithWellKnownTypes(t *testing.T) {
+	p := newSimpleParser(t)
+	kids := []KindID{
+		Time, Time, Time, Duration,
+		BigInt, Ptr, BigInt, BigFloat, BigRat,
+		UUID, Slice, Time,
+	}
+	local := time.Date(2023, 5, 6, 7, 8, 9, 123456789, time.FixedZone("CST", 8*3600))
+	// 带有单调时钟读数
+	now := time.Now()
+	neg, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	f := new(big.Float).SetPrec(200).SetFloat64(1.5)
+	f.Quo(f, big.NewFloat(3))
+	rat := big.NewRat(-1, 3)
+	id := [16]byte{0xde, 0xad, 0xbe, 0xef, 15: 0xff}
+	body, err := p.EncodeBody(mustDescs(t, p, kids),
+		time.Time{}, local, now, -90*time.Minute,
+		*neg, (*big.Int)(nil), *f, *rat,
+		id, []time.Time{local.UTC()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := p.ParseBody(body, mustDescs(t, p, kids))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r[0] != (time.Time{}) {
+		t.Fatalf("zero time wrong %v", r[0])
+	}
+	lt := r[1].(time.Time)
+	name, offset := lt.Zone()
+	if !lt.Equal(local) || name != "CST" || offset != 8*3600 {
+		t.Fatalf("local time wrong %v", lt)
+	}
+	// 不保留单调时钟读数
+	if !r[2].(time.Time).Equal(now) || r[2] == now {
+		t.Fatalf("now wrong %v", r[2])
+	}
+	if r[3] != -90*time.Minute {
+		t.Fatal("duration wrong")
+	}
+	bi := r[4].(big.Int)
+	if bi.Cmp(neg) != 0 || r[5] != nil {
+		t.Fatalf("big int wrong %v", &bi)
+	}
+	bf := r[6].(big.Float)
+	if bf.Cmp(f) != 0 || bf.Prec() != 200 {
+		t.Fatalf("big float wrong %v", &bf)
+	}
+	br := r[7].(big.Rat)
+	if br.Cmp(rat) != 0 {
+		t.Fatalf("big rat wrong %v", &br)
+	}
+	if r[8] != id {
+		t.Fatal("uuid wrong")
+	}
+	if ts := r[9].([]time.Time); len(ts) != 1 || ts[0] != local.UTC() {
+		t.Fatalf("time slice wrong %v", ts)
+	}
+
+	// time.Time不能按照其他结构体编码
+	_, err = p.EncodeBody(mustDescs(t, p, []KindID{ModelStartKindID}), local)
+	if err != ErrNotMatchedParam {
+		t.Fatalf("want ErrNotMatchedParam got %v", err)
+	}
+}
+
+// Money 以分为单位，自定义irpc编码
+type Money struct {
+	cents int64
+}
+
+func (m Money) MarshalIRPC() ([]byte, error) {
+	return []byte(strconv.FormatInt(m.cents, 10)), nil
+}
+
+func (m *Money) UnmarshalIRPC(b []byte) error {
+	cents, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return err
+	}
+	m.cents = cents
+	return nil
+}
+
+// Color 以名称编码的枚举
+type Color int
+
+var colorNames = []string{"red", "green"}
+
+func (c Color) MarshalText() ([]byte, error) {
+	if int(c) >= len(colorNames) {
+		return nil, errors.New("unknown color")
+	}
+	return []byte(colorNames[c]), nil
+}
+
+func (c *Color) UnmarshalText(b []byte) error {
+	for i, name := range colorNames {
+		if name == string(b) {
+			*c = Color(i)
+			return nil
+		}
+	}
+	return errors.New("unknown color")
+}
+
+// Celsius 第三方类型，通过函数注册编解码
+type Celsius float64
+
+func TestParseBodyWithTypeCodec(t *testing.T) {
+	p := newSimpleParser(t)
+	codecs := []*TypeCodec{
+		mustMarshalerCodec(t, reflect.TypeOf(Money{})),
+		mustMarshalerCodec(t, reflect.TypeOf(Color(0))),
+		NewTypeCodec(reflect.TypeOf(Celsius(0)), func(v interface{}) ([]byte, error) {
+			return []byte(strconv.FormatFloat(float64(v.(Celsius)), 'f', -1, 64)), nil
+		}, func(b []byte) (interface{}, error) {
+			f, err := strconv.ParseFloat(string(b), 64)
+			return Celsius(f), err
+		}),
+	}
+	for i, codec := range codecs {
+		p.models.AddCodec(ModelStartKindID+1+KindID(i), codec)
+	}
+	if _, ok := NewMarshalerCodec(reflect.TypeOf(Simple{})); ok {
+		t.Fatal("Simple should not have codec")
+	}
+
+	kids := []KindID{
+		ModelStartKindID + 1, Ptr, ModelStartKindID + 1,
+		Slice, ModelStartKindID + 2, ModelStartKindID + 3,
+	}
+	body, err := p.EncodeBody(mustDescs(t, p, kids), Money{cents: -1999}, &Money{cents: 5}, []Color{1, 0}, Celsius(36.6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 编码结果为长度前缀的自定义内容
+	if !bytes.HasPrefix(body, []byte("\x05-1999")) {
+		t.Fatalf("money body wrong %q", body)
+	}
+
+	r, err := p.ParseBody(body, mustDescs(t, p, kids))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r[0] != (Money{cents: -1999}) || *r[1].(*Money) != (Money{cents: 5}) {
+		t.Fatalf("money wrong %v %v", r[0], r[1])
+	}
+	if !reflect.DeepEqual(r[2], []Color{1, 0}) || r[3] != Celsius(36.6) {
+		t.Fatalf("codec wrong %v %v", r[2], r[3])
+	}
+
+	// 编码方法返回错误
+	_, err = p.EncodeBody(mustDescs(t, p, []KindID{ModelStartKindID + 2}), Color(9))
+	if err != ErrNotMatchedParam {
+		t.Fatalf("want ErrNotMatchedParam got %v", err)
+	}
+	// 解码方法返回错误
+	_, err = p.ParseBody([]byte("\x04blue"), mustDescs(t, p, []KindID{ModelStartKindID + 2}))
+	if err != ErrNotMatchedBody {
+		t.Fatalf("want ErrNotMatchedBody got %v", err)
+	}
+}
+
+func mustMarshalerCodec(t *testing.T, rt reflect.Type) *TypeCodec {
+	codec, ok := NewMarshalerCodec(rt)
+	if !ok {
+		t.Fatalf("%s has no codec", rt)
+	}
+	return codec
+}
+
+func TestEncodeBodyParamMismatch(t *testing.T) {
+	p := &Parser{}
+	_, err := p.EncodeBody(mustDescs(t, p, []KindID{Int, String}), 1)
+	if err != ErrNotMatchedParam {
+		t.Fatalf("want ErrNotMatchedParam got %v", err)
+	}
+
+	_, err = p.EncodeBody(mustDescs(t, p, []KindID{Slice, Int}), []int64{1})
+	if err != ErrNotMatchedParam {
+		t.Fatalf("want ErrNotMatchedParam got %v", err)
+	}
+}
+
+func FuzzParseStringAndBytes(f *testing.F) {
+	f.Add("hello,world 你好啊", []byte("hello"))
+	f.Add("", []byte{})
+	f.Add("\xff", []byte{0xFF})
+	f.Add("\xff\xff\x00\xff", []byte{0x00, 0xFF, 0xFF, 0x80})
+	f.Add(string(make([]byte, 300)), bytes.Repeat([]byte{0xFF}, 300))
+
+	p := &Parser{}
+	kids := []KindID{String, Bytes, Int64}
+	f.Fuzz(func(t *testing.T, s string, b []byte) {
+		body, err := p.EncodeBody(mustDescs(t, p, kids), s, b, int64(len(s)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := p.ParseBody(body, mustDescs(t, p, kids))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r[0] != s {
+			t.Fatalf("string mismatch %q %q", r[0], s)
+		}
+		if !bytes.Equal(r[1].([]byte), b) {
+			t.Fatalf("bytes mismatch %x %x", r[1], b)
+		}
+		if r[2] != int64(len(s)) {
+			t.Fatal("following int64 wrong")
+		}
+	})
+}
+
+func TestParseBodyInto(t *testing.T) {
+	p := newSimpleParser(t)
+	descs := mustDescs(t, p, []KindID{Int, Float64, Bool, Uint16, String, ModelStartKindID})
+	body, err := p.EncodeBody(descs, -91, 1.5, true, uint16(7), "{", Simple{Name: "a", ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		i  int
+		f  float64
+		b  bool
+		u  uint16
+		s  string
+		sp Simple
+	)
+	err = p.ParseBodyInto(body, descs, &i, &f, &b, &u, &s, &sp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != -91 || f != 1.5 || !b || u != 7 || s != "{" || sp != (Simple{Name: "a", ID: 1}) {
+		t.Fatalf("parse into wrong %v %v %v %v %v %v", i, f, b, u, s, sp)
+	}
+
+	// 指针类型与描述不一致
+	var i32 int32
+	err = p.ParseBodyInto(body, descs, &i32, &f, &b, &u, &s, &sp)
+	if err != ErrNotMatchedParam {
+		t.Fatalf("want ErrNotMatchedParam got %v", err)
+	}
+
+	// 定长基本类型的编解码不分配内存
+	primDescs := descs[:4]
+	params := []interface{}{i, f, b, u}
+	buf := make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = p.AppendBody(buf[:0], primDescs, params...)
+		_ = p.ParseBodyInto(buf, primDescs, &i, &f, &b, &u)
+	})
+	if allocs != 0 {
+		t.Fatalf("want 0 allocs got %v", allocs)
+	}
+}
+
+// benchPayload 代表性的请求参数：基本类型、字符串、slice、map以及结构体
+func benchPayload(t testing.TB, p *Parser) ([]*TypeDesc, []interface{}) {
+	kids := []KindID{
+		Int64, Float64, Bool, String,
+		Slice, Int32,
+		Map, String, Int64,
+		Slice, ModelStartKindID,
+	}
+	ints := make([]int32, 256)
+	for i := range ints {
+		ints[i] = int32(i * 7)
+	}
+	m := make(map[string]int64, 16)
+	for i := 0; i < 16; i++ {
+		m[fmt.Sprintf("key-%d", i)] = int64(i)
+	}
+	simples := make([]Simple, 32)
+	for i := range simples {
+		simples[i] = Simple{Name: fmt.Sprintf("simple-%d", i), ID: int64(i)}
+	}
+	return mustDescs(t, p, kids), []interface{}{int64(1 << 40), 3.14, true, "hello irpc", ints, m, simples}
+}
+
+func BenchmarkEncodeBodyPrimitives(b *testing.B) {
+	p := NewParser(&Models{})
+	descs := mustDescs(b, p, []KindID{Int64, Float64, Bool, Int32})
+	params := []interface{}{int64(1 << 40), 1.5, true, int32(-1)}
+	buf := GetBuffer(0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf, _ = p.AppendBody(buf[:0], descs, params...)
+	}
+	PutBuffer(buf)
+}
+
+func BenchmarkParseBodyIntoPrimitives(b *testing.B) {
+	p := NewParser(&Models{})
+	descs := mustDescs(b, p, []KindID{Int64, Float64, Bool, Int32})
+	body, err := p.EncodeBody(descs, int64(1), 1.5, true, int32(2))
+	if err != nil {
+		b.Fatal(err)
+	}
+	var (
+		i64 int64
+		f   float64
+		ok  bool
+		i32 int32
+	)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = p.ParseBodyInto(body, descs, &i64, &f, &ok, &i32)
+	}
+}
+
+func BenchmarkEncodeBody(b *testing.B) {
+	p := newSimpleParser(b)
+	descs, params := benchPayload(b, p)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf, err := p.AppendBody(GetBuffer(0), descs, params...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		PutBuffer(buf)
+	}
+}
+
+func BenchmarkParseBody(b *testing.B) {
+	p := newSimpleParser(b)
+	descs, params := benchPayload(b, p)
+	body, err := p.EncodeBody(descs, params...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err = p.ParseBody(body, descs)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
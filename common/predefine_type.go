@@ -1,11 +1,55 @@
 package common
 
-import "reflect"
+import (
+	"math/big"
+	"reflect"
+	"time"
+)
 
-type MethodID uint8
+// MethodID v1 frame中只有一个byte，超过MaxV1MethodID的编号需要对端支持ProtocolV2
+type MethodID uint16
 type SrvID uint16
 type KindID uint32
 
+// ContentType 请求、响应body的编码格式，在请求header中传递，响应使用与请求相同的格式
+type ContentType uint8
+
+const (
+	// ContentTypeBinary Parser的二进制格式
+	ContentTypeBinary ContentType = iota
+	ContentTypeJSON
+	ContentTypeMsgPack
+	ContentTypeCBOR
+	// ContentTypeProtobuf 只用于参数、结果均为proto.Message的方法
+	ContentTypeProtobuf
+)
+
+// Compression body的压缩算法，在frame header中标记
+type Compression uint8
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
+	CompressionSnappy
+)
+
+// CompressionSet 按位表示的压缩算法集合，frame header中用于告知对端本端可以解压的算法
+// header中该byte的最高位用于协商协议版本，见EncodeAccept
+type CompressionSet uint8
+
+func NewCompressionSet(cs ...Compression) CompressionSet {
+	var s CompressionSet
+	for _, c := range cs {
+		s |= 1 << c
+	}
+	return s
+}
+
+func (s CompressionSet) Has(c Compression) bool {
+	return c < 7 && s&(1<<c) != 0
+}
+
 const (
 	Bool KindID = iota
 	Int
@@ -23,6 +67,24 @@ const (
 	Map
 	Slice
 	String
+	// Bytes []byte，与string一样以长度前缀编码
+	Bytes
+	// Ptr 指针，以1个byte标记是否为nil
+	Ptr
+	// Array 数组，与slice编码相同
+	Array
+	// Time time.Time，编码为秒、纳秒、UTC偏移以及时区缩写，不保留单调时钟读数
+	Time
+	// Duration time.Duration，varint编码
+	Duration
+	// BigInt big.Int，编码为符号以及长度前缀的绝对值
+	BigInt
+	// BigFloat big.Float，保留精度以及舍入模式
+	BigFloat
+	// BigRat big.Rat，可以精确表示十进制小数
+	BigRat
+	// UUID 16 byte数组，例如uuid，直接编码16个byte
+	UUID
 )
 
 const InvalidKindID KindID = 1<<32 - 1
@@ -44,9 +106,90 @@ var KindMapKindID = map[reflect.Kind]KindID{
 	reflect.Map:     Map,
 	reflect.Slice:   Slice,
 	reflect.String:  String,
+	reflect.Ptr:     Ptr,
+	reflect.Array:   Array,
 }
 
-const ModelStartKindID = 16
+// IsBytesType []byte按照Bytes编码，而不是逐个uint8
+func IsBytesType(rt reflect.Type) bool {
+	return rt.Kind() == reflect.Slice && rt.Elem().Kind() == reflect.Uint8
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	bigIntType   = reflect.TypeOf(big.Int{})
+	bigFloatType = reflect.TypeOf(big.Float{})
+	bigRatType   = reflect.TypeOf(big.Rat{})
+	uuidType     = reflect.TypeOf([16]byte{})
+)
+
+// WellKnownKind 返回常用类型的kindID，这些类型有专门的紧凑编码，而不是按照底层结构编码
+func WellKnownKind(rt reflect.Type) (KindID, bool) {
+	switch {
+	case IsBytesType(rt):
+		return Bytes, true
+	case rt == timeType:
+		return Time, true
+	case rt == durationType:
+		return Duration, true
+	case rt == bigIntType:
+		return BigInt, true
+	case rt == bigFloatType:
+		return BigFloat, true
+	case rt == bigRatType:
+		return BigRat, true
+	case isUUIDType(rt):
+		return UUID, true
+	}
+	return InvalidKindID, false
+}
+
+func isUUIDType(rt reflect.Type) bool {
+	return rt.Kind() == reflect.Array && rt.Len() == 16 && rt.Elem().Kind() == reflect.Uint8
+}
+
+// ModelStartKindID 之前的kindID预留给内置类型
+const ModelStartKindID = 64
+
+// RequestHeaderLen 请求frame header长度：srvID(2)、methodID(1)、content type(1)、压缩算法(1)、
+// client可以解压的算法(1)、内容长度(4)
+const RequestHeaderLen = 10
+
+// ResponseHeaderLen 响应frame header长度：状态(1)、压缩算法(1)、server可以解压的算法(1)、内容长度(4)
+const ResponseHeaderLen = 7
+
+// ProtocolVersion frame格式版本。v1的srvID、methodID为请求header中的定长字段；
+// v2请求header中这3个byte为0，srvID、methodID以uvarint编码紧跟header，之后为内容。响应格式相同
+type ProtocolVersion uint8
+
+const (
+	ProtocolV1 ProtocolVersion = iota + 1
+	ProtocolV2
+)
+
+// MaxV1MethodID v1请求header可以表示的最大方法编号
+const MaxV1MethodID MethodID = 0xFF
+
+// protocolV2Flag 可以解压的算法所在byte的最高位。请求中表示该frame为v2格式，响应中表示server可以接收v2格式
+const protocolV2Flag = 0x80
+
+// EncodeAccept 将本端可以解压的算法以及协议版本编码为header中的一个byte
+func EncodeAccept(cs CompressionSet, v ProtocolVersion) byte {
+	b := byte(cs) &^ protocolV2Flag
+	if v >= ProtocolV2 {
+		b |= protocolV2Flag
+	}
+	return b
+}
+
+// DecodeAccept 与EncodeAccept相反，老版本的对端总是ProtocolV1
+func DecodeAccept(b byte) (CompressionSet, ProtocolVersion) {
+	if b&protocolV2Flag != 0 {
+		return CompressionSet(b &^ protocolV2Flag), ProtocolV2
+	}
+	return CompressionSet(b), ProtocolV1
+}
 
 type Request struct {
 	Header ReqHeader `json:"header"`
@@ -57,12 +200,15 @@ type Request struct {
 type ReqHeader struct {
 	SID SrvID
 	MID MethodID
+	CT  ContentType
+	// Accept client可以解压的算法，server据此压缩响应
+	Accept CompressionSet
 }
 
 type Response struct {
-	Body []byte `json:"body"`
-}
-
-type Models struct {
-	ModelMap map[KindID]reflect.Type
+	// Status 非StatusOK时Body为错误信息
+	Status StatusCode `json:"-"`
+	Body   []byte     `json:"body"`
+	// Accept 接收响应的client可以解压的算法
+	Accept CompressionSet `json:"-"`
 }
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+)
+
+// StatusCode 响应状态，非StatusOK时响应body为错误信息
+type StatusCode uint8
+
+const (
+	StatusOK StatusCode = iota
+	// StatusMessageTooLarge 请求或者响应超过了最大长度
+	StatusMessageTooLarge
+	// StatusUnavailable 服务没有注册或者已经删除
+	StatusUnavailable
+	// StatusNotFound 服务中没有该方法
+	StatusNotFound
+	// StatusMethodError 方法返回了error
+	StatusMethodError
+	// StatusInvalidArgument 参数无法解码或者校验失败，body为解码错误或者失败的字段以及原因
+	StatusInvalidArgument
+	// StatusUnsupportedContentType server没有请求使用的编码格式
+	StatusUnsupportedContentType
+)
+
+// DefaultMaxMessageSize 默认的最大请求、响应长度
+const DefaultMaxMessageSize = 16 << 20
+
+var (
+	ErrMessageTooLarge = errors.New("message too large")
+	ErrUnavailable     = errors.New("service unavailable")
+	ErrMethodNotFound  = errors.New("method not found")
+	ErrMethodError     = errors.New("method returned error")
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrUnsupportedContentType 对端不支持请求使用的编码格式
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+	// ErrProtocolUnsupported 对端不支持需要的协议版本，例如向v1的server请求超过MaxV1MethodID的方法
+	ErrProtocolUnsupported = errors.New("protocol version unsupported by peer")
+)
+
+// statusErrors 错误状态对应的error，对端返回的StatusError可以通过errors.Is判断
+var statusErrors = map[StatusCode]error{
+	StatusMessageTooLarge:        ErrMessageTooLarge,
+	StatusUnavailable:            ErrUnavailable,
+	StatusNotFound:               ErrMethodNotFound,
+	StatusMethodError:            ErrMethodError,
+	StatusInvalidArgument:        ErrInvalidArgument,
+	StatusUnsupportedContentType: ErrUnsupportedContentType,
+}
+
+// StatusError server返回的错误状态
+type StatusError struct {
+	Code    StatusCode
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("irpc status %d: %s", e.Code, e.Message)
+}
+
+// Is 使errors.Is(err, ErrMessageTooLarge)等对本端以及对端的错误均成立
+func (e *StatusError) Is(target error) bool {
+	err, ok := statusErrors[e.Code]
+	return ok && target == err
+}
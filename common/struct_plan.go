@@ -0,0 +1,100 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+// StructPlan 结构体的编解码计划，注册model时预先计算。结构体以字段数量为前缀，按字段顺序二进制编码
+type StructPlan struct {
+	Type   reflect.Type
+	Fields []FieldPlan
+}
+
+// FieldPlan 字段在结构体中的位置以及字段的类型描述
+type FieldPlan struct {
+	Name  string
+	Index int
+	Desc  *TypeDesc
+}
+
+// NewStructPlan 构建结构体的编解码计划，只包含导出字段。descOf返回字段的类型描述，嵌套结构体需要在其中注册
+func NewStructPlan(rt reflect.Type, descOf func(reflect.Type) (*TypeDesc, error)) (*StructPlan, error) {
+	if rt.Kind() != reflect.Struct {
+		return nil, ErrNotMatchedStruct
+	}
+
+	sp := &StructPlan{
+		Type:   rt,
+		Fields: make([]FieldPlan, 0, rt.NumField()),
+	}
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		desc, err := descOf(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s.%s: %w", rt.Name(), field.Name, err)
+		}
+		sp.Fields = append(sp.Fields, FieldPlan{
+			Name:  field.Name,
+			Index: i,
+			Desc:  desc,
+		})
+	}
+
+	return sp, nil
+}
+
+// AddModel 注册model类型以及编解码计划
+func (m *Models) AddModel(kid KindID, plan *StructPlan) {
+	m.update(func(s *modelsSnapshot) {
+		s.types[kid] = plan.Type
+		s.plans[kid] = plan
+	})
+}
+
+func (p *Parser) getPlan(kid KindID) (*StructPlan, error) {
+	if p.models == nil {
+		return nil, ErrNotRegisteredStruct
+	}
+	plan, ok := p.models.Plan(kid)
+	if !ok {
+		return nil, ErrNotRegisteredStruct
+	}
+	return plan, nil
+}
+
+func (p *Parser) appendStructValue(r []byte, plan *StructPlan, rv reflect.Value) ([]byte, error) {
+	var err error
+	r = binary.AppendUvarint(r, uint64(len(plan.Fields)))
+	for _, f := range plan.Fields {
+		r, err = p.appendReflect(r, f.Desc, rv.Field(f.Index))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (p *Parser) parseStructInto(body []byte, plan *StructPlan, rv reflect.Value) (int, error) {
+	n, index := binary.Uvarint(body)
+	if index <= 0 {
+		return 0, ErrNotMatchedBody
+	}
+	// 字段数量不一致说明两端的结构体定义不同
+	if n != uint64(len(plan.Fields)) {
+		return 0, ErrNotMatchedStruct
+	}
+
+	for _, f := range plan.Fields {
+		steps, err := p.parseInto(body[index:], f.Desc, rv.Field(f.Index))
+		if err != nil {
+			return 0, err
+		}
+		index += steps
+	}
+	return index, nil
+}
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"encoding"
+	"errors"
+	"log"
+	"reflect"
+)
+
+var ErrNotMatchedCodec = errors.New("not matched type codec")
+
+// Marshaler 自定义irpc编码，优先于encoding.BinaryMarshaler以及encoding.TextMarshaler
+type Marshaler interface {
+	MarshalIRPC() ([]byte, error)
+}
+
+// Unmarshaler 与Marshaler对应的解码，一般为指针方法
+type Unmarshaler interface {
+	UnmarshalIRPC([]byte) error
+}
+
+var (
+	marshalerType         = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType       = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	textMarshalerType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// TypeCodec 自定义类型的编解码，编码结果以varint长度为前缀，不再按照底层结构编码
+// Encode的rv为Type类型的值，Decode的rv为可设置的Type类型的值
+type TypeCodec struct {
+	Type   reflect.Type
+	Encode func(rv reflect.Value) ([]byte, error)
+	Decode func(b []byte, rv reflect.Value) error
+}
+
+// NewTypeCodec 由编解码函数构建TypeCodec，用于无法添加方法的类型。encoder的参数为rt类型的值，decoder需要返回rt类型的值
+func NewTypeCodec(rt reflect.Type, encoder func(interface{}) ([]byte, error), decoder func([]byte) (interface{}, error)) *TypeCodec {
+	return &TypeCodec{
+		Type: rt,
+		Encode: func(rv reflect.Value) ([]byte, error) {
+			return encoder(rv.Interface())
+		},
+		Decode: func(b []byte, rv reflect.Value) error {
+			v, err := decoder(b)
+			if err != nil {
+				return err
+			}
+			dv := reflect.ValueOf(v)
+			if !dv.IsValid() || dv.Type() != rt {
+				return ErrNotMatchedCodec
+			}
+			rv.Set(dv)
+			return nil
+		},
+	}
+}
+
+// NewMarshalerCodec rt实现了Marshaler、encoding.BinaryMarshaler或encoding.TextMarshaler以及对应的Unmarshaler时，
+// 返回使用这些方法的TypeCodec。方法可以是值方法也可以是指针方法，指针类型本身不检查，按照指针编码
+func NewMarshalerCodec(rt reflect.Type) (*TypeCodec, bool) {
+	if rt.Kind() == reflect.Ptr || rt.Kind() == reflect.Interface {
+		return nil, false
+	}
+
+	switch {
+	case implements(rt, marshalerType, unmarshalerType):
+		return &TypeCodec{
+			Type: rt,
+			Encode: func(rv reflect.Value) ([]byte, error) {
+				return addrOf(rv).Interface().(Marshaler).MarshalIRPC()
+			},
+			Decode: func(b []byte, rv reflect.Value) error {
+				return rv.Addr().Interface().(Unmarshaler).UnmarshalIRPC(b)
+			},
+		}, true
+
+	case implements(rt, binaryMarshalerType, binaryUnmarshalerType):
+		return &TypeCodec{
+			Type: rt,
+			Encode: func(rv reflect.Value) ([]byte, error) {
+				return addrOf(rv).Interface().(encoding.BinaryMarshaler).MarshalBinary()
+			},
+			Decode: func(b []byte, rv reflect.Value) error {
+				return rv.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(b)
+			},
+		}, true
+
+	case implements(rt, textMarshalerType, textUnmarshalerType):
+		return &TypeCodec{
+			Type: rt,
+			Encode: func(rv reflect.Value) ([]byte, error) {
+				return addrOf(rv).Interface().(encoding.TextMarshaler).MarshalText()
+			},
+			Decode: func(b []byte, rv reflect.Value) error {
+				return rv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(b)
+			},
+		}, true
+	}
+
+	return nil, false
+}
+
+// implements *rt实现了marshaler以及unmarshaler。*rt的方法集包含rt的值方法
+func implements(rt, marshaler, unmarshaler reflect.Type) bool {
+	pt := reflect.PointerTo(rt)
+	return pt.Implements(marshaler) && pt.Implements(unmarshaler)
+}
+
+// AddCodec 注册自定义类型的编解码
+func (m *Models) AddCodec(kid KindID, codec *TypeCodec) {
+	m.update(func(s *modelsSnapshot) {
+		s.types[kid] = codec.Type
+		s.codecs[kid] = codec
+	})
+}
+
+func (p *Parser) getCodec(kid KindID) (*TypeCodec, bool) {
+	if p.models == nil {
+		return nil, false
+	}
+	return p.models.Codec(kid)
+}
+
+func (p *Parser) appendCodecValue(r []byte, codec *TypeCodec, rv reflect.Value) ([]byte, error) {
+	b, err := codec.Encode(rv)
+	if err != nil {
+		log.Printf("common parser: encode %s err %v", codec.Type, err)
+		return nil, ErrNotMatchedParam
+	}
+	return p.appendBytes(r, b), nil
+}
+
+func (p *Parser) parseCodecInto(body []byte, codec *TypeCodec, rv reflect.Value) (int, error) {
+	b, steps, err := p.readLenPrefixed(body)
+	if err != nil {
+		return 0, err
+	}
+	// 解码方法可能持有b，复制一份
+	if err = codec.Decode(append([]byte(nil), b...), rv); err != nil {
+		log.Printf("common parser: decode %s err %v", codec.Type, err)
+		return 0, ErrNotMatchedBody
+	}
+	return steps, nil
+}
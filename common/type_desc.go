@@ -0,0 +1,173 @@
+package common
+
+import (
+	"reflect"
+)
+
+// TypeDesc 递归的类型描述。编码格式由Kind决定，解码得到Type类型的值
+type TypeDesc struct {
+	Kind KindID
+	// Slice、Array、Ptr的元素类型，Map的value类型
+	Elem *TypeDesc
+	// Map的key类型，只能是基本类型
+	Key *TypeDesc
+	// Array长度
+	Len int
+	// go类型
+	Type reflect.Type
+}
+
+// basicKindTypes 基本类型kindID对应的go类型，也是map key支持的类型
+var basicKindTypes = map[KindID]reflect.Type{
+	Bool:    reflect.TypeOf(false),
+	Int:     reflect.TypeOf(0),
+	Int8:    reflect.TypeOf(int8(0)),
+	Int16:   reflect.TypeOf(int16(0)),
+	Int32:   reflect.TypeOf(int32(0)),
+	Int64:   reflect.TypeOf(int64(0)),
+	Uint:    reflect.TypeOf(uint(0)),
+	Uint8:   reflect.TypeOf(uint8(0)),
+	Uint16:  reflect.TypeOf(uint16(0)),
+	Uint32:  reflect.TypeOf(uint32(0)),
+	Uint64:  reflect.TypeOf(uint64(0)),
+	Float32: reflect.TypeOf(float32(0)),
+	Float64: reflect.TypeOf(float64(0)),
+	String:  reflect.TypeOf(""),
+}
+
+var bytesType = reflect.TypeOf([]byte(nil))
+
+// wellKnownTypes 常用类型kindID对应的go类型
+var wellKnownTypes = map[KindID]reflect.Type{
+	Bytes:    bytesType,
+	Time:     timeType,
+	Duration: durationType,
+	BigInt:   bigIntType,
+	BigFloat: bigFloatType,
+	BigRat:   bigRatType,
+	UUID:     uuidType,
+}
+
+// IsBasicKind 是否是基本类型，基本类型才能作为map key
+func IsBasicKind(kid KindID) bool {
+	_, ok := basicKindTypes[kid]
+	return ok
+}
+
+// Kinds 按前序展开为kindID序列，用于schema等需要平铺表示的场景。Array不包含长度
+func (d *TypeDesc) Kinds() []KindID {
+	return d.appendKinds(make([]KindID, 0, 1))
+}
+
+func (d *TypeDesc) appendKinds(r []KindID) []KindID {
+	r = append(r, d.Kind)
+	if d.Key != nil {
+		r = d.Key.appendKinds(r)
+	}
+	if d.Elem != nil {
+		r = d.Elem.appendKinds(r)
+	}
+	return r
+}
+
+// FlattenKinds 展开多个参数的类型描述
+func FlattenKinds(descs []*TypeDesc) []KindID {
+	r := make([]KindID, 0, len(descs))
+	for _, d := range descs {
+		r = d.appendKinds(r)
+	}
+	return r
+}
+
+// NewTypeDesc 从前序kindID序列开头构建一个类型描述，返回消耗的kid数。结构体以及自定义编解码类型需要已经注册在models中
+// 平铺序列中没有数组长度，Array按照Slice构建，两者编码相同
+func NewTypeDesc(kids []KindID, models *Models) (*TypeDesc, int, error) {
+	if len(kids) == 0 {
+		return nil, 0, ErrInvalidKids
+	}
+
+	kid := kids[0]
+	switch kid {
+	case Slice, Array:
+		elem, n, err := NewTypeDesc(kids[1:], models)
+		if err != nil {
+			return nil, 0, err
+		}
+		return &TypeDesc{Kind: Slice, Elem: elem, Type: reflect.SliceOf(elem.Type)}, n + 1, nil
+
+	case Ptr:
+		elem, n, err := NewTypeDesc(kids[1:], models)
+		if err != nil {
+			return nil, 0, err
+		}
+		return &TypeDesc{Kind: Ptr, Elem: elem, Type: reflect.PointerTo(elem.Type)}, n + 1, nil
+
+	case Map:
+		key, kn, err := NewTypeDesc(kids[1:], models)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !IsBasicKind(key.Kind) {
+			return nil, 0, ErrUnsupportedMapKeyType
+		}
+		elem, en, err := NewTypeDesc(kids[1+kn:], models)
+		if err != nil {
+			return nil, 0, err
+		}
+		return &TypeDesc{Kind: Map, Key: key, Elem: elem, Type: reflect.MapOf(key.Type, elem.Type)}, 1 + kn + en, nil
+	}
+
+	if rt, ok := basicKindTypes[kid]; ok {
+		return &TypeDesc{Kind: kid, Type: rt}, 1, nil
+	}
+	if rt, ok := wellKnownTypes[kid]; ok {
+		return &TypeDesc{Kind: kid, Type: rt}, 1, nil
+	}
+	if models != nil {
+		if plan, ok := models.Plan(kid); ok {
+			return &TypeDesc{Kind: kid, Type: plan.Type}, 1, nil
+		}
+		if codec, ok := models.Codec(kid); ok {
+			return &TypeDesc{Kind: kid, Type: codec.Type}, 1, nil
+		}
+	}
+	return nil, 0, ErrNotRegisteredStruct
+}
+
+// NewTypeDescs 将方法参数的前序kindID序列切分为每个参数的类型描述
+func NewTypeDescs(kids []KindID, models *Models) ([]*TypeDesc, error) {
+	r := make([]*TypeDesc, 0, len(kids))
+	for i := 0; i < len(kids); {
+		d, n, err := NewTypeDesc(kids[i:], models)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, d)
+		i += n
+	}
+	return r, nil
+}
+
+// kindMatched 值的类型与描述不同时，按Kind检查是否可以编码，例如type Status int
+func kindMatched(d *TypeDesc, rt reflect.Type) bool {
+	switch d.Kind {
+	case Bytes:
+		return IsBytesType(rt)
+	case UUID:
+		return isUUIDType(rt)
+	case Slice:
+		return rt.Kind() == reflect.Slice
+	case Array:
+		return rt.Kind() == reflect.Array && rt.Len() == d.Len
+	case Ptr:
+		return rt.Kind() == reflect.Ptr
+	case Map:
+		return rt.Kind() == reflect.Map
+	}
+
+	if IsBasicKind(d.Kind) {
+		return KindMapKindID[rt.Kind()] == d.Kind
+	}
+	// 结构体、自定义编解码类型必须是注册的类型，time.Time等常用类型也必须类型相同
+	return rt == d.Type
+}
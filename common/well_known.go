@@ -0,0 +1,170 @@
+package common
+
+import (
+	"encoding/binary"
+	"log"
+	"math/big"
+	"reflect"
+	"time"
+)
+
+// time.Time编码为varint unix秒、uvarint纳秒、varint UTC偏移秒以及时区缩写
+// 单调时钟读数只在本进程内有意义，不编码。时区只保留偏移与缩写，解码为time.FixedZone，
+// 因此解码结果与原值Equal，但Location不一定相同。偏移为0并且缩写为空或UTC时解码为time.UTC
+const utcZoneName = "UTC"
+
+// appendWellKnown 编码time.Time等常用类型，rv类型已检查
+func (p *Parser) appendWellKnown(r []byte, kid KindID, rv reflect.Value) ([]byte, error) {
+	switch kid {
+	case Time:
+		t := rv.Interface().(time.Time)
+		name, offset := t.Zone()
+		r = binary.AppendVarint(r, t.Unix())
+		r = binary.AppendUvarint(r, uint64(t.Nanosecond()))
+		r = binary.AppendVarint(r, int64(offset))
+		return p.appendString(r, name), nil
+
+	case Duration:
+		return binary.AppendVarint(r, rv.Int()), nil
+
+	case BigInt:
+		// 符号1个byte，0为非负，1为负，之后为长度前缀的绝对值
+		x := addrOf(rv).Interface().(*big.Int)
+		if x.Sign() < 0 {
+			r = append(r, byte(1))
+		} else {
+			r = append(r, byte(0))
+		}
+		return p.appendBytes(r, x.Bytes()), nil
+
+	case BigFloat:
+		// gob编码包含精度以及舍入模式
+		b, err := addrOf(rv).Interface().(*big.Float).GobEncode()
+		if err != nil {
+			log.Printf("common parser: encode big.Float err %v", err)
+			return nil, ErrNotMatchedParam
+		}
+		return p.appendBytes(r, b), nil
+
+	case BigRat:
+		b, err := addrOf(rv).Interface().(*big.Rat).GobEncode()
+		if err != nil {
+			log.Printf("common parser: encode big.Rat err %v", err)
+			return nil, ErrNotMatchedParam
+		}
+		return p.appendBytes(r, b), nil
+
+	case UUID:
+		// 固定16个byte，不需要长度前缀
+		for i := 0; i < rv.Len(); i++ {
+			r = append(r, byte(rv.Index(i).Uint()))
+		}
+		return r, nil
+	}
+
+	return nil, ErrNotMatchedParam
+}
+
+// parseWellKnown 解析time.Time等常用类型并设置到rv，返回消耗的byte数
+func (p *Parser) parseWellKnown(body []byte, kid KindID, rv reflect.Value) (int, error) {
+	switch kid {
+	case Time:
+		sec, n := binary.Varint(body)
+		if n <= 0 {
+			return 0, ErrNotMatchedBody
+		}
+		index := n
+		nsec, n := binary.Uvarint(body[index:])
+		if n <= 0 || nsec >= uint64(time.Second) {
+			return 0, ErrNotMatchedBody
+		}
+		index += n
+		offset, n := binary.Varint(body[index:])
+		if n <= 0 || offset != int64(int32(offset)) {
+			return 0, ErrNotMatchedBody
+		}
+		index += n
+		name, steps, err := p.parseString(body[index:])
+		if err != nil {
+			return 0, err
+		}
+		index += steps
+
+		t := time.Unix(sec, int64(nsec))
+		if offset == 0 && (name == "" || name == utcZoneName) {
+			t = t.UTC()
+		} else {
+			t = t.In(time.FixedZone(name, int(offset)))
+		}
+		rv.Set(reflect.ValueOf(t))
+		return index, nil
+
+	case Duration:
+		d, n := binary.Varint(body)
+		if n <= 0 {
+			return 0, ErrNotMatchedBody
+		}
+		rv.SetInt(d)
+		return n, nil
+
+	case BigInt:
+		if len(body) < 1 || body[0] > 1 {
+			return 0, ErrNotMatchedBody
+		}
+		abs, steps, err := p.readLenPrefixed(body[1:])
+		if err != nil {
+			return 0, err
+		}
+		x := new(big.Int).SetBytes(abs)
+		if body[0] == 1 {
+			x.Neg(x)
+		}
+		rv.Set(reflect.ValueOf(x).Elem())
+		return steps + 1, nil
+
+	case BigFloat:
+		b, steps, err := p.readLenPrefixed(body)
+		if err != nil {
+			return 0, err
+		}
+		x := new(big.Float)
+		if err = x.GobDecode(b); err != nil {
+			log.Printf("common parser: decode big.Float err %v", err)
+			return 0, ErrNotMatchedBody
+		}
+		rv.Set(reflect.ValueOf(x).Elem())
+		return steps, nil
+
+	case BigRat:
+		b, steps, err := p.readLenPrefixed(body)
+		if err != nil {
+			return 0, err
+		}
+		x := new(big.Rat)
+		if err = x.GobDecode(b); err != nil {
+			log.Printf("common parser: decode big.Rat err %v", err)
+			return 0, ErrNotMatchedBody
+		}
+		rv.Set(reflect.ValueOf(x).Elem())
+		return steps, nil
+
+	case UUID:
+		if len(body) < rv.Len() {
+			return 0, ErrNotMatchedBody
+		}
+		reflect.Copy(rv, reflect.ValueOf(body[:rv.Len()]))
+		return rv.Len(), nil
+	}
+
+	return 0, ErrNotMatchedBody
+}
+
+// addrOf 返回指向rv的指针，rv不可取地址时指向其副本
+func addrOf(rv reflect.Value) reflect.Value {
+	if rv.CanAddr() {
+		return rv.Addr()
+	}
+	ptr := reflect.New(rv.Type())
+	ptr.Elem().Set(rv)
+	return ptr
+}
@@ -9,11 +9,33 @@ type ServicesConfig struct {
 }
 
 // 根本不需要根据路径解析出service
-// ID, Name 必须确保唯一，同一个服务中方法id、name也必须唯一
+// ID, Name 必须确保唯一，同一个服务中方法id、name也必须唯一。方法id超过255时需要client、server都支持协议v2
+// Aliases 服务的其他名称，例如服务改名之后旧的名称
+// Version 同一个服务的不同版本使用不同的服务编号以及go实现，服务表中的名称为VersionedName
+// Deprecated、DeprecatedMethods 整个服务或者单个方法废弃的说明，调用时记录日志
+// MethodAliases 方法别名对应的方法名，go方法改名之后使用别名注册，编号不变
 type ServiceConfig struct {
-	ID      common.SrvID               `yaml:"id"`
-	Name    string                     `yaml:"name"`
-	Methods map[string]common.MethodID `yaml:"methods"`
+	ID                common.SrvID               `yaml:"id"`
+	Name              string                     `yaml:"name"`
+	Version           string                     `yaml:"version"`
+	Methods           map[string]common.MethodID `yaml:"methods"`
+	Aliases           []string                   `yaml:"aliases"`
+	Deprecated        string                     `yaml:"deprecated"`
+	DeprecatedMethods map[string]string          `yaml:"deprecated_methods"`
+	MethodAliases     map[string]string          `yaml:"method_aliases"`
+}
+
+// FullName 带版本的服务名
+func (s *ServiceConfig) FullName() string {
+	return VersionedName(s.Name, s.Version)
+}
+
+// VersionedName 服务名加版本，例如UserService.v2，没有版本时为服务名
+func VersionedName(name, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + "." + version
 }
 
 type ServerConfig struct {
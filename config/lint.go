@@ -0,0 +1,278 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"learn/irpc/common"
+	"log"
+	"strings"
+)
+
+// Position services.yml中的位置，Line从1开始，为0时只有文件
+type Position struct {
+	File string
+	Line int
+}
+
+func (p Position) String() string {
+	if p.Line == 0 {
+		return p.File
+	}
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// Issue 配置检查发现的问题
+type Issue struct {
+	Pos Position
+	Msg string
+}
+
+func (i Issue) String() string {
+	return i.Pos.String() + ": " + i.Msg
+}
+
+// Issues 作为error返回时每行一个问题
+type Issues []Issue
+
+func (is Issues) Error() string {
+	lines := make([]string, len(is))
+	for i, issue := range is {
+		lines[i] = issue.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// RawServicesConfig 不限制编号范围、允许重复的services.yml，记录各项位置，用于检查配置
+type RawServicesConfig struct {
+	Path     string
+	Services []*RawServiceConfig
+	// 解析时发现的问题，例如方法编号不是整数
+	Issues Issues
+}
+
+type RawServiceConfig struct {
+	ID                int64
+	Name              string
+	Version           string
+	Methods           []*RawMethodConfig
+	Aliases           []string
+	Deprecated        string
+	DeprecatedMethods map[string]string
+	MethodAliases     map[string]string
+	Pos               Position
+}
+
+func (s *RawServiceConfig) FullName() string {
+	return VersionedName(s.Name, s.Version)
+}
+
+type RawMethodConfig struct {
+	Name string
+	ID   int64
+	Pos  Position
+}
+
+type rawServicesYAML struct {
+	Services []struct {
+		ID                int64             `yaml:"id"`
+		Name              string            `yaml:"name"`
+		Version           string            `yaml:"version"`
+		Methods           yaml.MapSlice     `yaml:"methods"`
+		Aliases           []string          `yaml:"aliases"`
+		Deprecated        string            `yaml:"deprecated"`
+		DeprecatedMethods map[string]string `yaml:"deprecated_methods"`
+		MethodAliases     map[string]string `yaml:"method_aliases"`
+	} `yaml:"services"`
+}
+
+// ParseRawServicesConfig 只有文件无法读取或者yaml格式错误时返回error，其他问题留给检查
+func ParseRawServicesConfig(filepath string) (*RawServicesConfig, error) {
+	file, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		log.Printf("config ParseRawServicesConfig: filepath %s wrong", filepath)
+		return nil, err
+	}
+
+	ry := &rawServicesYAML{}
+	err = yaml.Unmarshal(file, ry)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &RawServicesConfig{Path: filepath}
+	for _, s := range ry.Services {
+		srv := &RawServiceConfig{
+			ID:                s.ID,
+			Name:              s.Name,
+			Version:           s.Version,
+			Aliases:           s.Aliases,
+			Deprecated:        s.Deprecated,
+			DeprecatedMethods: s.DeprecatedMethods,
+			MethodAliases:     s.MethodAliases,
+		}
+		for _, item := range s.Methods {
+			m := &RawMethodConfig{Name: fmt.Sprint(item.Key), ID: -1}
+			switch id := item.Value.(type) {
+			case int:
+				m.ID = int64(id)
+			case int64:
+				m.ID = id
+			case uint64:
+				m.ID = int64(id)
+			}
+			srv.Methods = append(srv.Methods, m)
+		}
+		rc.Services = append(rc.Services, srv)
+	}
+	locate(strings.Split(string(file), "\n"), rc)
+
+	for _, s := range rc.Services {
+		for _, m := range s.Methods {
+			if m.ID < 0 {
+				rc.Issues = append(rc.Issues, Issue{Pos: m.Pos, Msg: fmt.Sprintf("method %s.%s id must be a non-negative integer", s.Name, m.Name)})
+			}
+		}
+	}
+	return rc, nil
+}
+
+// ServicesConfig 转换为ServicesConfig，编号超出范围的服务、方法被忽略
+func (rc *RawServicesConfig) ServicesConfig() *ServicesConfig {
+	sc := &ServicesConfig{}
+	for _, s := range rc.Services {
+		if s.ID < 0 || s.ID > int64(^common.SrvID(0)) {
+			continue
+		}
+		srv := &ServiceConfig{
+			ID:                common.SrvID(s.ID),
+			Name:              s.Name,
+			Version:           s.Version,
+			Methods:           make(map[string]common.MethodID, len(s.Methods)),
+			Aliases:           s.Aliases,
+			Deprecated:        s.Deprecated,
+			DeprecatedMethods: s.DeprecatedMethods,
+			MethodAliases:     s.MethodAliases,
+		}
+		for _, m := range s.Methods {
+			if m.ID < 0 || m.ID > int64(^common.MethodID(0)) {
+				continue
+			}
+			srv.Methods[m.Name] = common.MethodID(m.ID)
+		}
+		sc.Services = append(sc.Services, srv)
+	}
+	return sc
+}
+
+// locate 按行扫描services.yml，记录服务以及方法所在行
+// 只识别块格式的services列表以及methods映射，无法识别时位置为列表项或者methods所在行
+func locate(lines []string, rc *RawServicesConfig) {
+	for _, s := range rc.Services {
+		s.Pos = Position{File: rc.Path}
+		for _, m := range s.Methods {
+			m.Pos = Position{File: rc.Path}
+		}
+	}
+
+	start := -1
+	for i, line := range lines {
+		if indentOf(line) == 0 && yamlKey(line) == "services" {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		return
+	}
+
+	// 找到每个列表项的起止行
+	itemIndent := -1
+	items := make([]int, 0, len(rc.Services))
+	end := len(lines)
+	for i := start; i < len(lines); i++ {
+		line := lines[i]
+		if isBlank(line) {
+			continue
+		}
+		indent := indentOf(line)
+		isItem := strings.HasPrefix(strings.TrimSpace(line), "-")
+		if itemIndent < 0 && isItem {
+			itemIndent = indent
+		}
+		if indent < itemIndent || (indent == itemIndent && !isItem) || itemIndent < 0 {
+			end = i
+			break
+		}
+		if indent == itemIndent {
+			items = append(items, i)
+		}
+	}
+
+	for k, s := range rc.Services {
+		if k >= len(items) {
+			return
+		}
+		itemEnd := end
+		if k+1 < len(items) {
+			itemEnd = items[k+1]
+		}
+		s.Pos.Line = items[k] + 1
+		locateService(lines[items[k]:itemEnd], items[k], s)
+	}
+}
+
+func locateService(lines []string, offset int, s *RawServiceConfig) {
+	methodsLine, methodsIndent := -1, 0
+	for i, line := range lines {
+		if isBlank(line) {
+			continue
+		}
+		switch yamlKey(line) {
+		case "name":
+			s.Pos.Line = offset + i + 1
+		case "methods":
+			methodsLine, methodsIndent = i, indentOf(line)
+		}
+	}
+	if methodsLine < 0 {
+		return
+	}
+
+	// methods映射的每个key按顺序对应一个方法
+	j := 0
+	for i := methodsLine + 1; i < len(lines) && j < len(s.Methods); i++ {
+		line := lines[i]
+		if isBlank(line) {
+			continue
+		}
+		if indentOf(line) <= methodsIndent {
+			break
+		}
+		s.Methods[j].Pos.Line = offset + i + 1
+		j++
+	}
+	for ; j < len(s.Methods); j++ {
+		s.Methods[j].Pos.Line = offset + methodsLine + 1
+	}
+}
+
+// yamlKey 返回行中映射的key，列表项去掉"- "前缀
+func yamlKey(line string) string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimSpace(strings.TrimPrefix(line, "-"))
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(line[:i]), `"'`)
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func isBlank(line string) bool {
+	line = strings.TrimSpace(line)
+	return line == "" || strings.HasPrefix(line, "#")
+}
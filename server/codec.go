@@ -2,69 +2,204 @@ package server
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"learn/irpc/codec"
 	common2 "learn/irpc/common"
 )
 
+var (
+	ErrUnsupportedContentType = fmt.Errorf("irpcServer codec: %w", common2.ErrUnsupportedContentType)
+	ErrInvalidFrame           = errors.New("irpcServer codec: invalid frame")
+)
+
+// StreamCodec 按照请求header中的content type选择Codec，响应使用相同的Codec
 type StreamCodec struct {
-	parser *common2.Parser
+	codecs map[common2.ContentType]codec.Codec
+	// compression 为nil时不压缩也不接受压缩
+	compression *codec.CompressionConfig
+	// 请求、响应body的最大长度，不大于0时使用common.DefaultMaxMessageSize
+	maxRequestSize  int
+	maxResponseSize int
+	// protocol 可以接收的最高协议版本，通过响应header告知client
+	protocol common2.ProtocolVersion
 }
 
 func NewStreamCodec(parser *common2.Parser) *StreamCodec {
-	return &StreamCodec{parser: parser}
+	return &StreamCodec{
+		codecs:      codec.NewCodecs(parser),
+		compression: codec.DefaultCompressionConfig(),
+		protocol:    common2.ProtocolV2,
+	}
+}
+
+// SetProtocolVersion 设置可以接收的最高协议版本，为common.ProtocolV1时与老版本的server相同。需要在Run之前调用
+func (p *StreamCodec) SetProtocolVersion(v common2.ProtocolVersion) {
+	p.protocol = v
+}
+
+// SetCompression 设置压缩配置，为nil时关闭压缩。需要在Run之前调用
+func (p *StreamCodec) SetCompression(cfg *codec.CompressionConfig) {
+	p.compression = cfg
+}
+
+// SetMaxMessageSize 设置请求、响应body的最大长度，不大于0时使用默认值。需要在Run之前调用
+func (p *StreamCodec) SetMaxMessageSize(maxRequestSize, maxResponseSize int) {
+	p.maxRequestSize = maxRequestSize
+	p.maxResponseSize = maxResponseSize
+}
+
+func maxMessageSize(size int) int {
+	if size <= 0 {
+		return common2.DefaultMaxMessageSize
+	}
+	return size
 }
 
+func (p *StreamCodec) compressionConfig() *codec.CompressionConfig {
+	if p.compression == nil {
+		return &codec.CompressionConfig{}
+	}
+	return p.compression
+}
+
+// RegisterCodec 注册或替换Codec，需要在Run之前调用
+func (p *StreamCodec) RegisterCodec(cd codec.Codec) {
+	p.codecs[cd.ContentType()] = cd
+}
+
+func (p *StreamCodec) getCodec(ct common2.ContentType) (codec.Codec, error) {
+	cd, ok := p.codecs[ct]
+	if !ok {
+		return nil, ErrUnsupportedContentType
+	}
+	return cd, nil
+}
+
+// ReadRequest 请求或者解压后超过最大长度时丢弃请求内容并返回common.ErrMessageTooLarge，无法解压时返回common.ErrInvalidArgument，
+// 两种情况stream仍然可以继续使用
+// 返回的Body来自buffer池，解析完毕后可以通过common.PutBuffer放回
 func (p *StreamCodec) ReadRequest(reader io.Reader) (*common2.Request, error) {
-	// 读取srvID
-	var srvID uint16
-	err := binary.Read(reader, binary.BigEndian, &srvID)
+	// 一次读取srvID、methodID、content type、压缩算法、client可以解压的算法以及内容长度，v2格式的srvID、methodID紧跟header
+	var header [common2.RequestHeaderLen]byte
+	_, err := io.ReadFull(reader, header[:])
 	if err != nil {
 		return nil, err
 	}
+	comp := common2.Compression(header[4])
+	accept, version := common2.DecodeAccept(header[5])
+	sid, mid := common2.SrvID(binary.BigEndian.Uint16(header[:2])), common2.MethodID(header[2])
+	if version >= common2.ProtocolV2 {
+		if p.protocol < common2.ProtocolV2 {
+			return nil, fmt.Errorf("%w: request protocol v%d", common2.ErrProtocolUnsupported, version)
+		}
+		sid, mid, err = readIDs(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	// 读取methodID
-	var methodID uint8
-	err = binary.Read(reader, binary.BigEndian, &methodID)
-	if err != nil {
-		return nil, err
+	// 请求内容长度超过最大长度时不分配内存，直接丢弃
+	contentLen := binary.BigEndian.Uint32(header[6:10])
+	if int64(contentLen) > int64(maxMessageSize(p.maxRequestSize)) {
+		_, err = io.CopyN(io.Discard, reader, int64(contentLen))
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: request %d bytes", common2.ErrMessageTooLarge, contentLen)
 	}
 
-	// 读取请求内容长度。默认不超过2^32
-	var contentLen uint32
-	err = binary.Read(reader, binary.BigEndian, &contentLen)
+	// 读取内容。stream上单次Read可能只返回部分内容
+	content := common2.GetBuffer(int(contentLen))
+	_, err = io.ReadFull(reader, content)
 	if err != nil {
+		common2.PutBuffer(content)
 		return nil, err
 	}
 
-	// 读取内容
-	content := make([]byte, contentLen)
-	_, err = reader.Read(content)
-	if err != nil {
-		return nil, err
+	// 请求内容已经读完，解压失败时stream仍然可以继续使用。解压后同样不能超过最大长度
+	if comp != common2.CompressionNone {
+		raw := content
+		content, err = p.compressionConfig().DecompressLimit(raw, comp, maxMessageSize(p.maxRequestSize))
+		common2.PutBuffer(raw)
+		if errors.Is(err, codec.ErrExceedDecompressedSize) {
+			return nil, fmt.Errorf("%w: request %s", common2.ErrMessageTooLarge, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: request %s", common2.ErrInvalidArgument, err)
+		}
 	}
 
 	// 读取请求内容。log请求内容，并返回请求内容
 	return &common2.Request{
 		Header: common2.ReqHeader{
-			SID: common2.SrvID(srvID),
-			MID: common2.MethodID(methodID),
+			SID:    sid,
+			MID:    mid,
+			CT:     common2.ContentType(header[3]),
+			Accept: accept,
 		},
 		Body: content,
 	}, nil
 }
 
-// WriteResponse 将result写入writer
-// 返回结果是数组，但是result并不能编码为数组
+// readIDs 读取v2请求header之后uvarint编码的srvID、methodID
+func readIDs(reader io.Reader) (common2.SrvID, common2.MethodID, error) {
+	br := &byteReader{r: reader}
+	sid, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, 0, err
+	}
+	mid, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, 0, err
+	}
+	if sid > uint64(^common2.SrvID(0)) || mid > uint64(^common2.MethodID(0)) {
+		return 0, 0, fmt.Errorf("%w: srv id %d method id %d out of range", ErrInvalidFrame, sid, mid)
+	}
+	return common2.SrvID(sid), common2.MethodID(mid), nil
+}
+
+// byteReader 逐个byte读取，不会读取stream中之后的内容
+type byteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (br *byteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(br.r, br.buf[:])
+	return br.buf[0], err
+}
+
+// WriteResponse 将result写入writer。响应header为状态、压缩算法、本端可以解压的算法以及协议版本、内容长度
+// 返回结果是数组，但是result并不能编码为数组。超过最大长度时改为返回StatusMessageTooLarge
 func (p *StreamCodec) WriteResponse(writer io.Writer, resp *common2.Response) error {
-	// 放入response长度
-	resLen := len(resp.Body)
-	res := make([]byte, 4+resLen)
-	binary.BigEndian.PutUint32(res[:4], uint32(resLen))
+	// 按照client支持的算法压缩，错误信息不压缩
+	cfg := p.compressionConfig()
+	status, body, comp := resp.Status, resp.Body, common2.CompressionNone
+	if status == common2.StatusOK {
+		var err error
+		body, comp, err = cfg.Compress(resp.Body, resp.Accept)
+		if err != nil {
+			return err
+		}
+	}
+	if len(body) > maxMessageSize(p.maxResponseSize) {
+		status, comp = common2.StatusMessageTooLarge, common2.CompressionNone
+		body = []byte(fmt.Sprintf("%s: response %d bytes", common2.ErrMessageTooLarge, len(body)))
+	}
 
-	// 复制response
-	copy(res[4:], resp.Body)
+	// 放入状态、压缩算法、本端可以解压的算法以及response长度
+	frame := common2.GetBuffer(common2.ResponseHeaderLen + len(body))
+	frame[0] = byte(status)
+	frame[1] = byte(comp)
+	frame[2] = common2.EncodeAccept(cfg.Accept(), p.protocol)
+	binary.BigEndian.PutUint32(frame[3:7], uint32(len(body)))
 
-	_, err := writer.Write(res)
+	// quic.Stream没有vectored write，header与body复制到同一个frame中一次写入
+	copy(frame[common2.ResponseHeaderLen:], body)
+	_, err := writer.Write(frame)
+	common2.PutBuffer(frame)
 	if err != nil {
 		return err
 	}
@@ -72,12 +207,27 @@ func (p *StreamCodec) WriteResponse(writer io.Writer, resp *common2.Response) er
 	return nil
 }
 
-func (p *StreamCodec) ParseRequestBody(body []byte, kids []common2.KindID) ([]interface{}, error) {
-	return p.parser.ParseBody(body, kids)
+func (p *StreamCodec) ParseRequestBody(ct common2.ContentType, body []byte, descs []*common2.TypeDesc) ([]interface{}, error) {
+	cd, err := p.getCodec(ct)
+	if err != nil {
+		return nil, err
+	}
+	return cd.ParseBody(body, descs)
 }
 
-func (p *StreamCodec) EncodeBody(kids []common2.KindID, results ...interface{}) ([]byte, error) {
-	return p.parser.EncodeBody(kids, results...)
+// EncodeBody 编码结果来自buffer池，响应写入之后可以通过common.PutBuffer放回
+func (p *StreamCodec) EncodeBody(ct common2.ContentType, descs []*common2.TypeDesc, results ...interface{}) ([]byte, error) {
+	cd, err := p.getCodec(ct)
+	if err != nil {
+		return nil, err
+	}
+	buf := common2.GetBuffer(0)
+	body, err := codec.AppendBody(cd, buf, descs, results...)
+	if err != nil {
+		common2.PutBuffer(buf)
+		return nil, err
+	}
+	return body, nil
 }
 
 // 总不能大于1<<8-1的时候，输入又是大端吧？虽然也不是不可以
@@ -2,9 +2,12 @@ package server
 
 import (
 	"bytes"
+	"errors"
 	"learn/irpc/client"
+	"learn/irpc/codec"
 	"learn/irpc/common"
 	"testing"
+	"testing/iotest"
 )
 
 func TestParseSrvIDLen(t *testing.T) {
@@ -49,6 +52,7 @@ func TestParseToRequest(t *testing.T) {
 		Header: common.ReqHeader{
 			SID: 1,
 			MID: 1,
+			CT:  common.ContentTypeJSON,
 		},
 		Body: []byte("hello"),
 	}
@@ -65,7 +69,312 @@ func TestParseToRequest(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if request.Header.SID != req.Header.SID || request.Header.MID != req.Header.MID || !bytes.Equal(request.Body, req.Body) {
+	if request.Header != req.Header || !bytes.Equal(request.Body, req.Body) {
 		t.Fatal("req mismatch")
 	}
 }
+
+func TestUnsupportedContentType(t *testing.T) {
+	ssc := NewStreamCodec(common.NewParser(&common.Models{}))
+	_, err := ssc.ParseRequestBody(common.ContentType(99), []byte("[]"), nil)
+	if err != ErrUnsupportedContentType {
+		t.Fatalf("want ErrUnsupportedContentType got %v", err)
+	}
+}
+
+func TestCompressedFrames(t *testing.T) {
+	parser := common.NewParser(&common.Models{})
+	csc := client.NewStreamCodec(parser)
+	ssc := NewStreamCodec(parser)
+	body := bytes.Repeat([]byte("compress"), 1024)
+
+	// 第一个响应之前不知道server支持的算法，请求不压缩
+	req := &common.Request{Header: common.ReqHeader{SID: 1, MID: 1}, Body: body}
+	encodeReq, err := csc.EncodeToRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encodeReq) < len(body) {
+		t.Fatal("first request should not be compressed")
+	}
+	request, err := ssc.ReadRequest(bytes.NewReader(encodeReq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Header.Accept == 0 {
+		t.Fatal("client accept not sent")
+	}
+
+	// 响应按照client支持的算法压缩
+	var buf bytes.Buffer
+	err = ssc.WriteResponse(&buf, &common.Response{Body: body, Accept: request.Header.Accept})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() >= len(body) {
+		t.Fatal("response should be compressed")
+	}
+	resp, err := csc.ReadResponse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp.Body, body) {
+		t.Fatal("response body wrong")
+	}
+
+	// 之后的请求压缩
+	encodeReq, err = csc.EncodeToRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encodeReq) >= len(body) {
+		t.Fatal("request should be compressed")
+	}
+	request, err = ssc.ReadRequest(bytes.NewReader(encodeReq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(request.Body, body) {
+		t.Fatal("request body wrong")
+	}
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	parser := common.NewParser(&common.Models{})
+	csc := client.NewStreamCodec(parser)
+	csc.SetCompression(nil)
+	ssc := NewStreamCodec(parser)
+	ssc.SetMaxMessageSize(16, 16)
+
+	var frames []byte
+	for _, body := range [][]byte{bytes.Repeat([]byte("x"), 17), []byte("small")} {
+		encodeReq, err := csc.EncodeToRequest(&common.Request{Header: common.ReqHeader{SID: 1, MID: 1}, Body: body})
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, encodeReq...)
+	}
+
+	// 单次Read只返回1个byte，过大的请求被丢弃后仍然可以读取下一个请求
+	reader := iotest.OneByteReader(bytes.NewReader(frames))
+	_, err := ssc.ReadRequest(reader)
+	if !errors.Is(err, common.ErrMessageTooLarge) {
+		t.Fatalf("want ErrMessageTooLarge got %v", err)
+	}
+	request, err := ssc.ReadRequest(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(request.Body) != "small" {
+		t.Fatalf("next request wrong %q", request.Body)
+	}
+
+	// 过大的响应改为错误状态
+	var buf bytes.Buffer
+	err = ssc.WriteResponse(&buf, &common.Response{Body: bytes.Repeat([]byte("y"), 17)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = csc.ReadResponse(iotest.OneByteReader(&buf))
+	var se *common.StatusError
+	if !errors.As(err, &se) || se.Code != common.StatusMessageTooLarge || !errors.Is(err, common.ErrMessageTooLarge) {
+		t.Fatalf("want StatusMessageTooLarge got %v", err)
+	}
+
+	// client本地检查请求长度
+	csc.SetMaxMessageSize(4, 0)
+	_, err = csc.EncodeToRequest(&common.Request{Body: []byte("12345")})
+	if !errors.Is(err, common.ErrMessageTooLarge) {
+		t.Fatalf("want ErrMessageTooLarge got %v", err)
+	}
+}
+
+func TestProtocolNegotiation(t *testing.T) {
+	parser := common.NewParser(&common.Models{})
+	csc := client.NewStreamCodec(parser)
+	ssc := NewStreamCodec(parser)
+	req := &common.Request{Header: common.ReqHeader{SID: 0xFFFE, MID: 300}, Body: []byte("wide")}
+
+	// 第一个响应之前不知道server的版本，超过v1范围的方法编号无法编码
+	_, err := csc.EncodeToRequest(req)
+	if !errors.Is(err, common.ErrProtocolUnsupported) {
+		t.Fatalf("want ErrProtocolUnsupported got %v", err)
+	}
+	small := &common.Request{Header: common.ReqHeader{SID: 0xFFFE, MID: 1}, Body: []byte("narrow")}
+	encodeReq, err := csc.EncodeToRequest(small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encodeReq) != common.RequestHeaderLen+len(small.Body) {
+		t.Fatalf("first request should be v1, len %d", len(encodeReq))
+	}
+
+	// server在响应中告知支持v2，之后的请求使用varint编号
+	var buf bytes.Buffer
+	if err = ssc.WriteResponse(&buf, &common.Response{Body: []byte("ok")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = csc.ReadResponse(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var frames []byte
+	for _, r := range []*common.Request{req, small} {
+		encodeReq, err = csc.EncodeToRequest(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, encodeReq...)
+	}
+	reader := iotest.OneByteReader(bytes.NewReader(frames))
+	for _, r := range []*common.Request{req, small} {
+		request, err := ssc.ReadRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if request.Header.SID != r.Header.SID || request.Header.MID != r.Header.MID || !bytes.Equal(request.Body, r.Body) {
+			t.Fatalf("v2 request mismatch %+v", request.Header)
+		}
+	}
+
+	// v1的server不接收v2请求，client也不再使用v2
+	old := NewStreamCodec(parser)
+	old.SetProtocolVersion(common.ProtocolV1)
+	encodeReq, _ = csc.EncodeToRequest(small)
+	if _, err = old.ReadRequest(bytes.NewReader(encodeReq)); !errors.Is(err, common.ErrProtocolUnsupported) {
+		t.Fatalf("v1 server want ErrProtocolUnsupported got %v", err)
+	}
+	buf.Reset()
+	if err = old.WriteResponse(&buf, &common.Response{Body: []byte("ok")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = csc.ReadResponse(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = csc.EncodeToRequest(req); !errors.Is(err, common.ErrProtocolUnsupported) {
+		t.Fatalf("want ErrProtocolUnsupported got %v", err)
+	}
+	encodeReq, err = csc.EncodeToRequest(small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := old.ReadRequest(bytes.NewReader(encodeReq))
+	if err != nil || request.Header.MID != 1 {
+		t.Fatalf("v1 request %v %v", request, err)
+	}
+}
+
+func TestDecompressFailures(t *testing.T) {
+	parser := common.NewParser(&common.Models{})
+	csc := client.NewStreamCodec(parser)
+	ssc := NewStreamCodec(parser)
+	ssc.SetMaxMessageSize(4<<10, 0)
+
+	// 压缩后不超过最大长度，解压后超过；以及无法解压的内容
+	bomb, comp, err := codec.DefaultCompressionConfig().Compress(make([]byte, 1<<20), common.NewCompressionSet(common.CompressionGzip))
+	if err != nil || comp != common.CompressionGzip || len(bomb) > 4<<10 {
+		t.Fatalf("compress bomb %d %v", len(bomb), err)
+	}
+	var frames []byte
+	for _, body := range [][]byte{bomb, []byte("not gzip"), []byte("plain")} {
+		encodeReq, err := csc.EncodeToRequest(&common.Request{Header: common.ReqHeader{SID: 1, MID: 1}, Body: body})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 第一个响应之前client不压缩，直接修改header中的压缩算法
+		if string(body) != "plain" {
+			encodeReq[4] = byte(common.CompressionGzip)
+		}
+		frames = append(frames, encodeReq...)
+	}
+
+	reader := bytes.NewReader(frames)
+	if _, err = ssc.ReadRequest(reader); !errors.Is(err, common.ErrMessageTooLarge) {
+		t.Fatalf("want ErrMessageTooLarge got %v", err)
+	}
+	if _, err = ssc.ReadRequest(reader); !errors.Is(err, common.ErrInvalidArgument) {
+		t.Fatalf("want ErrInvalidArgument got %v", err)
+	}
+	request, err := ssc.ReadRequest(reader)
+	if err != nil || string(request.Body) != "plain" {
+		t.Fatalf("next request %v %v", request, err)
+	}
+}
+
+// countingWriter 记录Write次数，quic.Stream每次Write都是一次stream写入
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestSingleWritePerFrame(t *testing.T) {
+	parser := common.NewParser(&common.Models{})
+	csc := client.NewStreamCodec(parser)
+	ssc := NewStreamCodec(parser)
+	req := &common.Request{Header: common.ReqHeader{SID: 1, MID: 1}, Body: []byte("frame")}
+
+	frame, err := csc.EncodeFrame(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, _ := csc.EncodeToRequest(req)
+	if !bytes.Equal(frame, full) {
+		t.Fatal("EncodeFrame differs from EncodeToRequest")
+	}
+	common.PutBuffer(frame)
+
+	var w countingWriter
+	for _, resp := range []*common.Response{{Body: []byte("ok")}, {Status: common.StatusMethodError, Body: []byte("failed")}} {
+		if err = ssc.WriteResponse(&w, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.writes != 2 {
+		t.Fatalf("want 1 write per response got %d for 2", w.writes)
+	}
+	if resp, err := csc.ReadResponse(&w); err != nil || string(resp.Body) != "ok" {
+		t.Fatalf("response %v %v", resp, err)
+	}
+}
+
+// BenchmarkFrameRoundTrip 请求、响应经过与stream相同的Write路径，writes/op为每次调用的stream写入次数
+func BenchmarkFrameRoundTrip(b *testing.B) {
+	parser := common.NewParser(&common.Models{})
+	csc := client.NewStreamCodec(parser)
+	ssc := NewStreamCodec(parser)
+	body := bytes.Repeat([]byte("irpc"), 256)
+	req := &common.Request{Header: common.ReqHeader{SID: 1, MID: 1}, Body: body}
+
+	var w countingWriter
+	b.SetBytes(int64(2 * len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		frame, err := csc.EncodeFrame(req)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, _ = w.Write(frame)
+		common.PutBuffer(frame)
+
+		request, err := ssc.ReadRequest(&w)
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = ssc.WriteResponse(&w, &common.Response{Body: request.Body, Accept: request.Header.Accept})
+		common.PutBuffer(request.Body)
+		if err != nil {
+			b.Fatal(err)
+		}
+		resp, err := csc.ReadResponse(&w)
+		if err != nil {
+			b.Fatal(err)
+		}
+		common.PutBuffer(resp.Body)
+	}
+	b.ReportMetric(float64(w.writes)/float64(b.N), "writes/op")
+}
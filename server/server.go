@@ -9,6 +9,7 @@ import (
 	common2 "learn/irpc/common"
 	"learn/irpc/service"
 	"log"
+	"sync"
 )
 
 type IrpcServer struct {
@@ -17,6 +18,9 @@ type IrpcServer struct {
 	ctx        context.Context
 	cc         *StreamCodec
 	mgr        *service.Mgr
+	mu         *sync.Mutex
+	listener   quic.Listener
+	shutdown   bool
 }
 
 var (
@@ -30,6 +34,7 @@ func NewIrpcServer(tlsConfig *tls.Config, listenAddr string, ctx context.Context
 		ctx:        ctx,
 		cc:         cc,
 		mgr:        mgr,
+		mu:         &sync.Mutex{},
 	}
 }
 
@@ -40,10 +45,21 @@ func (s *IrpcServer) Run() error {
 		return err
 	}
 
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return listener.Close()
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
 	for {
 		// 接受连接
 		conn, err := listener.Accept(context.Background())
 		if err != nil {
+			if s.isShutdown() {
+				return nil
+			}
 			return err
 		}
 		log.Printf("irpcServer NewIrpcClient: conn accepted: %s", conn.RemoteAddr().String())
@@ -53,6 +69,25 @@ func (s *IrpcServer) Run() error {
 	}
 }
 
+// Shutdown 先将健康状态置为HealthNotServing，再关闭监听，之后Run返回nil
+func (s *IrpcServer) Shutdown() error {
+	s.mgr.Health().Shutdown()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = true
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+func (s *IrpcServer) isShutdown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdown
+}
+
 func (s *IrpcServer) handleConn(conn quic.Connection) {
 	for {
 		// 接受流
@@ -76,6 +111,16 @@ func (s *IrpcServer) handleStream(stream quic.Stream) {
 	for {
 		// 解析请求
 		request, err := s.cc.ReadRequest(stream)
+		if status, ok := readRequestStatus(err); ok {
+			// 请求内容已丢弃，告知client后继续处理该stream
+			log.Printf("irpcServer handleStream: %s", err)
+			err = s.writeStatus(stream, status, err)
+			if err != nil {
+				log.Printf("irpcServer handleStream: write response failed %s", err)
+				return
+			}
+			continue
+		}
 		if err != nil {
 			err = handleConnErr(err)
 			if err == connFinishedErr || err == io.EOF {
@@ -85,19 +130,66 @@ func (s *IrpcServer) handleStream(stream quic.Stream) {
 			return
 		}
 
+		// 获取方法。服务没有注册或已删除时告知client后继续处理该stream
+		// 同一个请求使用同一个handler，期间服务被替换也不影响本次调用
+		h, err := s.mgr.GetHandler(request.Header.SID, request.Header.MID)
+		if err != nil {
+			common2.PutBuffer(request.Body)
+			status := common2.StatusUnavailable
+			if errors.Is(err, service.ErrNotExistMethod) {
+				status = common2.StatusNotFound
+			}
+			err = s.writeStatus(stream, status, err)
+			if err != nil {
+				log.Printf("irpcServer handleStream: write response failed %s", err)
+				return
+			}
+			continue
+		}
+
 		// 解析请求参数
-		inParams, _ := s.mgr.GetKindIDsByMethod(request.Header.SID, request.Header.MID)
-		params, err := s.cc.ParseRequestBody(request.Body, inParams)
+		params, err := s.cc.ParseRequestBody(request.Header.CT, request.Body, h.InDescs())
+		// 参数已经解析为独立的值，body可以复用
+		common2.PutBuffer(request.Body)
 		if err != nil {
-			log.Printf("irpcServer handleStream: parse req body %s failed %s", string(request.Body), err)
-			return
+			// 编码格式不支持或者body无法解码，告知client后继续处理该stream
+			log.Printf("irpcServer handleStream: parse req body failed %s", err)
+			status := common2.StatusInvalidArgument
+			if errors.Is(err, ErrUnsupportedContentType) {
+				status = common2.StatusUnsupportedContentType
+			}
+			err = s.writeStatus(stream, status, err)
+			if err != nil {
+				log.Printf("irpcServer handleStream: write response failed %s", err)
+				return
+			}
+			continue
 		}
 
-		// 调用方法
-		result := s.mgr.Invoke(request.Header.SID, request.Header.MID, params)
+		// 校验参数，失败时不调用方法，告知client失败的字段后继续处理该stream
+		err = h.Validate(params)
+		if err != nil {
+			err = s.writeStatus(stream, common2.StatusInvalidArgument, err)
+			if err != nil {
+				log.Printf("irpcServer handleStream: write response failed %s", err)
+				return
+			}
+			continue
+		}
+
+		// 调用方法。方法返回的error告知client后继续处理该stream
+		result, err := h.Call(stream.Context(), params)
+		if err != nil {
+			err = s.writeStatus(stream, common2.StatusMethodError, err)
+			if err != nil {
+				log.Printf("irpcServer handleStream: write response failed %s", err)
+				return
+			}
+			continue
+		}
 
 		// 构造响应
-		resp, err := s.constructResp(request.Header.SID, request.Header.MID, result...)
+		resp, err := s.constructResp(request.Header, h.OutDescs(), result...)
 		if err != nil {
 			log.Printf("irpcServer handleStream: construct response failed %s", err)
 			return
@@ -105,6 +197,7 @@ func (s *IrpcServer) handleStream(stream quic.Stream) {
 
 		// 编码结果为response
 		err = s.cc.WriteResponse(stream, resp)
+		common2.PutBuffer(resp.Body)
 		if err != nil {
 			err = handleConnErr(err)
 			if err == connFinishedErr || err == io.EOF {
@@ -116,6 +209,17 @@ func (s *IrpcServer) handleStream(stream quic.Stream) {
 	}
 }
 
+// readRequestStatus 读取请求时不影响stream继续使用的错误对应的状态：请求过大或者无法解压
+func readRequestStatus(err error) (common2.StatusCode, bool) {
+	switch {
+	case errors.Is(err, common2.ErrMessageTooLarge):
+		return common2.StatusMessageTooLarge, true
+	case errors.Is(err, common2.ErrInvalidArgument):
+		return common2.StatusInvalidArgument, true
+	}
+	return common2.StatusOK, false
+}
+
 func handleConnErr(err error) error {
 	switch e := err.(type) {
 	case *quic.ApplicationError:
@@ -131,16 +235,18 @@ func handleConnErr(err error) error {
 	return err
 }
 
-func (s *IrpcServer) constructResp(srvID common2.SrvID, mid common2.MethodID, result ...interface{}) (*common2.Response, error) {
-	// 根据methodID获取输入参数kindIDs
-	_, outKinds := s.mgr.GetKindIDsByMethod(srvID, mid)
+// writeStatus 写入错误状态，body为错误信息
+func (s *IrpcServer) writeStatus(stream io.Writer, status common2.StatusCode, err error) error {
+	return s.cc.WriteResponse(stream, &common2.Response{Status: status, Body: []byte(err.Error())})
+}
 
-	// 构造响应body
-	body, err := s.cc.EncodeBody(outKinds, result...)
+func (s *IrpcServer) constructResp(header common2.ReqHeader, outDescs []*common2.TypeDesc, result ...interface{}) (*common2.Response, error) {
+	// 构造响应body，与请求使用相同的编码格式
+	body, err := s.cc.EncodeBody(header.CT, outDescs, result...)
 	if err != nil {
 		return nil, err
 	}
 
-	resp := &common2.Response{Body: body}
+	resp := &common2.Response{Body: body, Accept: header.Accept}
 	return resp, nil
 }
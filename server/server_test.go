@@ -1,7 +1,12 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"github.com/lucas-clemente/quic-go"
+	"io"
+	"learn/irpc/client"
 	"learn/irpc/common"
 	"learn/irpc/config"
 	"learn/irpc/service"
@@ -61,3 +66,121 @@ func TestRunServerBasic(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// memStream 从内存读取请求、写入响应，用于不建立连接测试handleStream
+type memStream struct {
+	quic.Stream
+	r io.Reader
+	w bytes.Buffer
+}
+
+func (s *memStream) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+func (s *memStream) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+func (s *memStream) Context() context.Context {
+	return context.Background()
+}
+
+// serveFrames 在同一个stream上依次处理请求，返回读取到的响应以及错误状态
+func serveFrames(t *testing.T, mgr *service.Mgr, csc *client.StreamCodec, frames ...[]byte) ([]*common.Response, []error) {
+	parser := common.NewParser(mgr.GetModels())
+	server := NewIrpcServer(nil, "", context.Background(), NewStreamCodec(parser), mgr)
+	stream := &memStream{r: bytes.NewReader(bytes.Join(frames, nil))}
+	server.handleStream(stream)
+
+	resps := make([]*common.Response, len(frames))
+	errs := make([]error, len(frames))
+	for i := range frames {
+		resps[i], errs[i] = csc.ReadResponse(&stream.w)
+	}
+	if stream.w.Len() != 0 {
+		t.Fatalf("%d bytes left after %d responses", stream.w.Len(), len(frames))
+	}
+	return resps, errs
+}
+
+// addRequest 编码ServerTest.Add请求，body为nil时使用ct编码参数
+func addRequest(t *testing.T, mgr *service.Mgr, csc *client.StreamCodec, ct common.ContentType, body []byte) []byte {
+	sid, mid, err := mgr.GetSrvMethodID("ServerTest", "Add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body == nil {
+		h, err := mgr.GetHandler(sid, mid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err = csc.EncodeBody(ct, h.InDescs(), 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	frame, err := csc.EncodeToRequest(&common.Request{Header: common.ReqHeader{SID: sid, MID: mid, CT: ct}, Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestHandleStreamUnsupportedContentType(t *testing.T) {
+	mgr := service.NewServiceMgr("../config/services.yml")
+	if err := mgr.Register(&ServerTest{}); err != nil {
+		t.Fatal(err)
+	}
+	csc := client.NewStreamCodec(common.NewParser(mgr.GetModels()))
+
+	// 不支持的编码格式返回错误状态，stream继续处理之后的请求
+	resps, errs := serveFrames(t, mgr, csc,
+		addRequest(t, mgr, csc, common.ContentType(99), []byte("[1,2]")),
+		addRequest(t, mgr, csc, common.ContentTypeBinary, nil),
+	)
+	var se *common.StatusError
+	if !errors.As(errs[0], &se) || se.Code != common.StatusUnsupportedContentType || !errors.Is(errs[0], common.ErrUnsupportedContentType) {
+		t.Fatalf("want StatusUnsupportedContentType got %v", errs[0])
+	}
+	if errs[1] != nil {
+		t.Fatal(errs[1])
+	}
+	sid, mid, _ := mgr.GetSrvMethodID("ServerTest", "Add")
+	h, _ := mgr.GetHandler(sid, mid)
+	r, err := csc.ParseResponseBody(common.ContentTypeBinary, resps[1].Body, h.OutDescs())
+	if err != nil || len(r) != 1 || r[0] != 3 {
+		t.Fatalf("next response %v %v", r, err)
+	}
+}
+
+func TestHandleStreamInvalidArgument(t *testing.T) {
+	mgr := service.NewServiceMgr("../config/services.yml")
+	if err := mgr.Register(&ServerTest{}); err != nil {
+		t.Fatal(err)
+	}
+	csc := client.NewStreamCodec(common.NewParser(mgr.GetModels()))
+
+	// 无法解码的参数返回StatusInvalidArgument，body为解码错误，stream继续处理之后的请求
+	frames := [][]byte{
+		addRequest(t, mgr, csc, common.ContentTypeBinary, []byte{0xff}),
+		addRequest(t, mgr, csc, common.ContentTypeJSON, []byte(`[1,`)),
+		addRequest(t, mgr, csc, common.ContentTypeJSON, nil),
+	}
+	resps, errs := serveFrames(t, mgr, csc, frames...)
+	for _, err := range errs[:2] {
+		var se *common.StatusError
+		if !errors.As(err, &se) || se.Code != common.StatusInvalidArgument || !errors.Is(err, common.ErrInvalidArgument) || se.Message == "" {
+			t.Fatalf("want StatusInvalidArgument got %v", err)
+		}
+	}
+	if errs[2] != nil {
+		t.Fatal(errs[2])
+	}
+	sid, mid, _ := mgr.GetSrvMethodID("ServerTest", "Add")
+	h, _ := mgr.GetHandler(sid, mid)
+	r, err := csc.ParseResponseBody(common.ContentTypeJSON, resps[2].Body, h.OutDescs())
+	if err != nil || len(r) != 1 || r[0] != 3 {
+		t.Fatalf("next response %v %v", r, err)
+	}
+}
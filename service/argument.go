@@ -0,0 +1,322 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	common2 "learn/irpc/common"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidRule = errors.New("service_mgr: invalid irpc validation tag")
+
+// Validator 参数类型实现该接口时，调用方法之前自动校验。嵌套在结构体、slice、map、指针中的值同样校验
+type Validator interface {
+	Validate() error
+}
+
+var validatorType = reflect.TypeOf((*Validator)(nil)).Elem()
+
+// FieldError 校验失败的参数或字段，Field形如arg0.Items[1].Count
+type FieldError struct {
+	Field string
+	Msg   string
+}
+
+func (e FieldError) String() string {
+	return e.Field + ": " + e.Msg
+}
+
+// ArgumentError 参数校验失败，包含所有失败的字段。server以common.StatusInvalidArgument返回，body为Error()
+type ArgumentError struct {
+	Fields []FieldError
+}
+
+func (e *ArgumentError) Error() string {
+	fields := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		fields[i] = f.String()
+	}
+	return "invalid argument: " + strings.Join(fields, "; ")
+}
+
+func (e *ArgumentError) Is(target error) bool {
+	return target == common2.ErrInvalidArgument
+}
+
+// checker 校验一个值，失败的字段追加到errs
+type checker func(v reflect.Value, path string, errs *[]FieldError)
+
+// argCheckers 方法每个参数的checker，不需要校验的参数为nil，都不需要校验时返回nil
+func argCheckers(in []reflect.Type) ([]checker, error) {
+	var checkers []checker
+	building := make(map[reflect.Type]*checkerSlot)
+	for i, rt := range in {
+		c, err := buildChecker(rt, building)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			continue
+		}
+		if checkers == nil {
+			checkers = make([]checker, len(in))
+		}
+		checkers[i] = c
+	}
+	return checkers, nil
+}
+
+// checkArgs 校验解码之后的参数，nil参数不校验
+func checkArgs(checkers []checker, argv []interface{}) error {
+	var errs []FieldError
+	for i, c := range checkers {
+		if c == nil || i >= len(argv) || argv[i] == nil {
+			continue
+		}
+		c(reflect.ValueOf(argv[i]), "arg"+strconv.Itoa(i), &errs)
+	}
+	if len(errs) > 0 {
+		return &ArgumentError{Fields: errs}
+	}
+	return nil
+}
+
+// checkerSlot 已经构建或者正在构建的checker，递归类型引用正在构建的checker
+type checkerSlot struct {
+	c    checker
+	done bool
+}
+
+// buildChecker 按类型构建checker，类型中没有需要校验的部分时返回nil
+func buildChecker(rt reflect.Type, building map[reflect.Type]*checkerSlot) (checker, error) {
+	if slot, ok := building[rt]; ok {
+		if slot.done {
+			return slot.c, nil
+		}
+		return func(v reflect.Value, path string, errs *[]FieldError) {
+			if slot.c != nil {
+				slot.c(v, path, errs)
+			}
+		}, nil
+	}
+	slot := &checkerSlot{}
+	building[rt] = slot
+
+	inner, err := buildInnerChecker(rt, building)
+	if err != nil {
+		return nil, err
+	}
+	slot.c, slot.done = withValidator(rt, inner), true
+	return slot.c, nil
+}
+
+func buildInnerChecker(rt reflect.Type, building map[reflect.Type]*checkerSlot) (checker, error) {
+	switch rt.Kind() {
+	case reflect.Ptr:
+		elem, err := buildChecker(rt.Elem(), building)
+		if elem == nil || err != nil {
+			return nil, err
+		}
+		return func(v reflect.Value, path string, errs *[]FieldError) {
+			if !v.IsNil() {
+				elem(v.Elem(), path, errs)
+			}
+		}, nil
+
+	case reflect.Slice, reflect.Array:
+		elem, err := buildChecker(rt.Elem(), building)
+		if elem == nil || err != nil {
+			return nil, err
+		}
+		return func(v reflect.Value, path string, errs *[]FieldError) {
+			for i := 0; i < v.Len(); i++ {
+				elem(v.Index(i), path+"["+strconv.Itoa(i)+"]", errs)
+			}
+		}, nil
+
+	case reflect.Map:
+		elem, err := buildChecker(rt.Elem(), building)
+		if elem == nil || err != nil {
+			return nil, err
+		}
+		return func(v reflect.Value, path string, errs *[]FieldError) {
+			iter := v.MapRange()
+			for iter.Next() {
+				elem(iter.Value(), fmt.Sprintf("%s[%v]", path, iter.Key()), errs)
+			}
+		}, nil
+
+	case reflect.Struct:
+		return buildStructChecker(rt, building)
+	}
+	return nil, nil
+}
+
+type fieldChecker struct {
+	index int
+	name  string
+	rule  *fieldRule
+	elem  checker
+}
+
+func buildStructChecker(rt reflect.Type, building map[reflect.Type]*checkerSlot) (checker, error) {
+	var fields []fieldChecker
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		rule, err := parseFieldRule(f)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s.%s %s", ErrInvalidRule, rt, f.Name, err)
+		}
+		elem, err := buildChecker(f.Type, building)
+		if err != nil {
+			return nil, err
+		}
+		if rule != nil || elem != nil {
+			fields = append(fields, fieldChecker{index: i, name: f.Name, rule: rule, elem: elem})
+		}
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	return func(v reflect.Value, path string, errs *[]FieldError) {
+		for _, f := range fields {
+			fv, fpath := v.Field(f.index), path+"."+f.name
+			if f.rule != nil {
+				if msg := f.rule.check(fv); msg != "" {
+					*errs = append(*errs, FieldError{Field: fpath, Msg: msg})
+					continue
+				}
+			}
+			if f.elem != nil {
+				f.elem(fv, fpath, errs)
+			}
+		}
+	}, nil
+}
+
+// withValidator 值或者指针实现了Validator时，在inner之后调用Validate
+func withValidator(rt reflect.Type, inner checker) checker {
+	// 指针指向的值已经按照值以及指针的方法校验
+	if rt.Kind() == reflect.Ptr && rt.Elem().Kind() != reflect.Ptr {
+		return inner
+	}
+	isValidator := rt.Implements(validatorType)
+	ptrValidator := !isValidator && rt.Kind() != reflect.Ptr && reflect.PtrTo(rt).Implements(validatorType)
+	if !isValidator && !ptrValidator {
+		return inner
+	}
+
+	return func(v reflect.Value, path string, errs *[]FieldError) {
+		if inner != nil {
+			inner(v, path, errs)
+		}
+		if rt.Kind() == reflect.Interface && v.IsNil() {
+			return
+		}
+		// 指针方法需要可以取地址的值
+		if ptrValidator {
+			if !v.CanAddr() {
+				cp := reflect.New(rt).Elem()
+				cp.Set(v)
+				v = cp
+			}
+			v = v.Addr()
+		}
+		if err := v.Interface().(Validator).Validate(); err != nil {
+			*errs = append(*errs, FieldError{Field: path, Msg: err.Error()})
+		}
+	}
+}
+
+// fieldRule 字段标签`irpc:"required,min=1,max=10"`。min、max对数字比较值，对string、slice、map、array比较长度
+type fieldRule struct {
+	required bool
+	min, max *float64
+	byLen    bool
+}
+
+func parseFieldRule(f reflect.StructField) (*fieldRule, error) {
+	tag, ok := f.Tag.Lookup("irpc")
+	if !ok || tag == "" {
+		return nil, nil
+	}
+
+	rule := &fieldRule{}
+	for _, item := range strings.Split(tag, ",") {
+		key, value, hasValue := strings.Cut(strings.TrimSpace(item), "=")
+		switch key {
+		case "required":
+			rule.required = true
+		case "min", "max":
+			if !hasValue {
+				return nil, fmt.Errorf("%s needs a value", key)
+			}
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s=%s is not a number", key, value)
+			}
+			if key == "min" {
+				rule.min = &n
+			} else {
+				rule.max = &n
+			}
+		case "exclude":
+			// 服务结构体排除方法的标签，不是校验规则
+			continue
+		default:
+			return nil, fmt.Errorf("unknown rule %s", key)
+		}
+	}
+
+	if rule.min != nil || rule.max != nil {
+		switch f.Type.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+			rule.byLen = true
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+		default:
+			return nil, fmt.Errorf("min/max unsupported on %s", f.Type)
+		}
+	}
+	if !rule.required && rule.min == nil && rule.max == nil {
+		return nil, nil
+	}
+	return rule, nil
+}
+
+// check 返回失败信息，通过时返回空
+func (r *fieldRule) check(v reflect.Value) string {
+	if r.required && v.IsZero() {
+		return "required"
+	}
+	if r.min == nil && r.max == nil {
+		return ""
+	}
+
+	var n float64
+	what := "value"
+	switch {
+	case r.byLen:
+		n, what = float64(v.Len()), "length"
+	case v.CanInt():
+		n = float64(v.Int())
+	case v.CanUint():
+		n = float64(v.Uint())
+	default:
+		n = v.Float()
+	}
+	if r.min != nil && n < *r.min {
+		return fmt.Sprintf("%s must be >= %v", what, *r.min)
+	}
+	if r.max != nil && n > *r.max {
+		return fmt.Sprintf("%s must be <= %v", what, *r.max)
+	}
+	return ""
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"errors"
+	common2 "learn/irpc/common"
+	"testing"
+)
+
+type Item struct {
+	Name  string `irpc:"required"`
+	Count int    `irpc:"min=1,max=10"`
+}
+
+type Order struct {
+	ID    string `irpc:"required,min=3"`
+	Items []Item `irpc:"min=1"`
+	Note  *Remark
+	Next  *Order
+}
+
+// Remark 指针接收者实现Validator
+type Remark struct {
+	Text string
+}
+
+func (r *Remark) Validate() error {
+	if r.Text == "bad" {
+		return errors.New("bad remark")
+	}
+	return nil
+}
+
+type orderSrv struct{}
+
+func (o *orderSrv) Place(order Order, r Remark) int {
+	return len(order.Items)
+}
+
+type BadTag struct {
+	N string `irpc:"min=x"`
+}
+
+type badSrv struct{}
+
+func (b *badSrv) Do(x BadTag) {}
+
+func TestArgumentValidation(t *testing.T) {
+	mgr := NewServiceMgr("")
+	mgr.SetAutoIDs(true)
+	if err := mgr.Register(&badSrv{}); !errors.Is(err, ErrInvalidRule) {
+		t.Fatalf("want ErrInvalidRule got %v", err)
+	}
+	if err := mgr.Register(&orderSrv{}); err != nil {
+		t.Fatal(err)
+	}
+	sid, mid, err := mgr.GetSrvMethodID("orderSrv", "Place")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := Order{ID: "o-1", Items: []Item{{Name: "a", Count: 1}}}
+	if r, err := mgr.Invoke(sid, mid, []interface{}{valid, Remark{}}); err != nil || r[0] != 1 {
+		t.Fatalf("valid order %v %v", r, err)
+	}
+
+	invalid := Order{
+		ID:    "o",
+		Items: []Item{{Name: "a", Count: 1}, {Count: 11}},
+		Note:  &Remark{Text: "bad"},
+		Next:  &Order{ID: "o-2"},
+	}
+	_, err = mgr.Invoke(sid, mid, []interface{}{invalid, Remark{Text: "bad"}})
+	if !errors.Is(err, common2.ErrInvalidArgument) {
+		t.Fatalf("want ErrInvalidArgument got %v", err)
+	}
+	var argErr *ArgumentError
+	if !errors.As(err, &argErr) {
+		t.Fatalf("want *ArgumentError got %T", err)
+	}
+	want := []FieldError{
+		{Field: "arg0.ID", Msg: "length must be >= 3"},
+		{Field: "arg0.Items[1].Name", Msg: "required"},
+		{Field: "arg0.Items[1].Count", Msg: "value must be <= 10"},
+		{Field: "arg0.Note", Msg: "bad remark"},
+		{Field: "arg0.Next.Items", Msg: "length must be >= 1"},
+		{Field: "arg1", Msg: "bad remark"},
+	}
+	if len(argErr.Fields) != len(want) {
+		t.Fatalf("fields %v", argErr.Fields)
+	}
+	for i, f := range want {
+		if argErr.Fields[i] != f {
+			t.Fatalf("field %d want %v got %v", i, f, argErr.Fields[i])
+		}
+	}
+}
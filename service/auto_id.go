@@ -0,0 +1,112 @@
+package service
+
+import (
+	common2 "learn/irpc/common"
+	"log"
+	"sort"
+)
+
+// 自动编号模式下，没有配置的服务由名称得到服务、方法编号，client、server只要服务名以及方法名集合一致编号就一致
+// 服务编号为服务名哈希映射到[1, ReservedSrvIDStart)，冲突时注册返回错误，需要通过services.yml或RegisterWithIDs指定编号
+// 方法按名称排序后依次从名称哈希映射到[1, 255]的编号开始顺序查找未使用的编号，不会冲突。
+// 不超过255个方法时与只支持v1格式的对端也可以通信，更多的方法使用256之后的编号
+// 之后通过HandleFunc等增加的方法不改变已有方法的编号，只为新方法查找编号，因此编号与增加的顺序有关
+
+// SetAutoIDs 开启后注册没有配置的服务时自动编号，否则返回ErrUnconfiguredSrv。需要在注册服务之前调用
+func (m *Mgr) SetAutoIDs(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.autoIDs = enabled
+}
+
+// RegisterWithIDs 不依赖services.yml，直接指定服务以及方法编号注册。methods中没有的方法不注册
+// 服务已配置但没有注册(例如Unregister之后)时使用新的编号
+func (m *Mgr) RegisterWithIDs(srvName string, srvID common2.SrvID, methods map[string]common2.MethodID, srv interface{}, opts ...RegisterOption) error {
+	return m.modify(func(t *srvTable) error {
+		err := addServiceConfig(t, srvName, srvID, methods)
+		if err != nil {
+			return err
+		}
+		return m.register(t, srvName, newService(srv, opts))
+	})
+}
+
+func addServiceConfig(t *srvTable, srvName string, srvID common2.SrvID, methods map[string]common2.MethodID) error {
+	if isReservedService(srvName, srvID) {
+		log.Printf("Mgr Register: service %s id %d is reserved", srvName, srvID)
+		return ErrReservedSrv
+	}
+	if _, registered := t.lookup(srvName); registered {
+		log.Printf("Mgr Register: service %s already registered", srvName)
+		return ErrSrvRegistered
+	}
+	for name, sci := range t.idSrvName {
+		if sci.id == srvID && name != srvName {
+			log.Printf("Mgr Register: service %s id %d conflicts with %s", srvName, srvID, name)
+			return ErrSrvIDConflict
+		}
+	}
+
+	runtime := make(map[string]bool, len(methods))
+	for name := range methods {
+		runtime[name] = true
+	}
+	t.idSrvName[srvName] = &serviceConfigInfo{
+		id:      srvID,
+		Methods: methods,
+		runtime: runtime,
+	}
+	return nil
+}
+
+// autoServiceConfig 由服务名以及方法名生成编号
+func (m *Mgr) autoServiceConfig(t *srvTable, srvName string, methodNames []string) error {
+	err := addServiceConfig(t, srvName, autoSrvID(srvName), autoMethodIDs(methodNames))
+	if err != nil {
+		return err
+	}
+	t.idSrvName[srvName].auto = true
+	return nil
+}
+
+func autoSrvID(srvName string) common2.SrvID {
+	return 1 + common2.SrvID(fnv32a(srvName)%uint32(ReservedSrvIDStart-1))
+}
+
+// autoMethodIDs 方法编号只由方法名集合决定，与names的顺序以及重复无关
+func autoMethodIDs(names []string) map[string]common2.MethodID {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	methods := make(map[string]common2.MethodID, len(sorted))
+	used := make(map[common2.MethodID]bool, len(sorted))
+	for _, name := range sorted {
+		if _, ok := methods[name]; ok {
+			continue
+		}
+		mid := probeMethodID(name, used)
+		methods[name] = mid
+		used[mid] = true
+	}
+	return methods
+}
+
+// probeMethodID 从autoMethodID开始在[1, 255]中顺序查找未使用的编号，都已使用时使用256之后最小的未使用编号
+func probeMethodID(methodName string, used map[common2.MethodID]bool) common2.MethodID {
+	start := autoMethodID(methodName)
+	for i := common2.MethodID(0); i < common2.MaxV1MethodID; i++ {
+		mid := 1 + (start-1+i)%common2.MaxV1MethodID
+		if !used[mid] {
+			return mid
+		}
+	}
+	mid := common2.MaxV1MethodID + 1
+	for used[mid] {
+		mid++
+	}
+	return mid
+}
+
+// autoMethodID 方法名哈希映射到[1, 255]，为方法查找编号的起点
+func autoMethodID(methodName string) common2.MethodID {
+	return 1 + common2.MethodID(fnv32a(methodName)%uint32(common2.MaxV1MethodID))
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	common2 "learn/irpc/common"
+	"log"
+)
+
+// 内置服务使用预留的服务ID，不需要也不允许在services.yml中配置
+const (
+	ReservedSrvIDStart common2.SrvID = 0xFF00
+
+	HealthServiceName                    = "irpc.Health"
+	HealthSrvID         common2.SrvID    = 0xFFFF
+	HealthCheckMethod                    = "Check"
+	HealthWatchMethod                    = "Watch"
+	healthCheckMethodID common2.MethodID = 1
+	healthWatchMethodID common2.MethodID = 2
+
+	ReflectionServiceName                     = "irpc.Reflection"
+	ReflectionSrvID          common2.SrvID    = 0xFFFE
+	ReflectionSchemaMethod                    = "Schema"
+	ReflectionListMethod                      = "ListServices"
+	reflectionSchemaMethodID common2.MethodID = 1
+	reflectionListMethodID   common2.MethodID = 2
+)
+
+func isReservedService(name string, id common2.SrvID) bool {
+	return name == HealthServiceName || name == ReflectionServiceName || id >= ReservedSrvIDStart
+}
+
+type builtinService struct {
+	name    string
+	id      common2.SrvID
+	methods map[string]common2.MethodID
+	srv     interface{}
+}
+
+// registerBuiltinServices 注册内置服务。client、server都通过NewServiceMgr调用，保证两端一致
+// model kid由类型指纹得到，与注册顺序无关
+func (m *Mgr) registerBuiltinServices(t *srvTable) {
+	builtins := []builtinService{
+		{
+			name: HealthServiceName,
+			id:   HealthSrvID,
+			methods: map[string]common2.MethodID{
+				HealthCheckMethod: healthCheckMethodID,
+				HealthWatchMethod: healthWatchMethodID,
+			},
+			srv: &healthService{h: m.health},
+		},
+		{
+			name: ReflectionServiceName,
+			id:   ReflectionSrvID,
+			methods: map[string]common2.MethodID{
+				ReflectionSchemaMethod: reflectionSchemaMethodID,
+				ReflectionListMethod:   reflectionListMethodID,
+			},
+			srv: &reflectionService{m: m},
+		},
+	}
+
+	for _, b := range builtins {
+		t.idSrvName[b.name] = &serviceConfigInfo{
+			id:      b.id,
+			Methods: b.methods,
+		}
+		err := m.register(t, b.name, newService(b.srv, nil))
+		if err != nil {
+			log.Fatalf("Mgr registerBuiltinServices: register %s failed %s", b.name, err)
+		}
+	}
+}
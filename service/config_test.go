@@ -16,8 +16,8 @@ func TestConfigInvokeService(t *testing.T) {
 	//}}
 	mgr := NewServiceMgr("../config/services.yml")
 	mgr.Register(&DemoService{})
-	r := mgr.Invoke(1, 1, []interface{}{1, 2})
-	if len(r) != 1 || r[0] != 3 {
+	r, err := mgr.Invoke(1, 1, []interface{}{1, 2})
+	if err != nil || len(r) != 1 || r[0] != 3 {
 		t.Fatal("unexpected result")
 	}
 }
@@ -0,0 +1,303 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	common2 "learn/irpc/common"
+	config2 "learn/irpc/config"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoConfig           = errors.New("service_mgr: no config file")
+	ErrIncompatibleConfig = errors.New("service_mgr: incompatible config change")
+)
+
+// ConfigReload 配置重新加载的变化，每项形如"+ method ServerTest.Sub id 3"
+// 存在Rejected时整个配置都不应用
+type ConfigReload struct {
+	// Changes 兼容的变化：新的服务、方法、别名以及废弃说明的修改
+	Changes []string
+	// Rejected 不兼容的变化：修改或删除已有的编号、别名，以及把编号分配给其他名称
+	Rejected []string
+}
+
+// ReloadConfig 重新读取NewServiceMgr时的配置文件并应用兼容的变化，已注册服务的新方法立即可以调用
+// 配置本身有问题时返回config.Issues，存在不兼容的变化时返回ErrIncompatibleConfig，两者都不应用任何变化
+func (m *Mgr) ReloadConfig() (*ConfigReload, error) {
+	m.mu.Lock()
+	old := m.rawConfig
+	m.mu.Unlock()
+	if old == nil {
+		return nil, ErrNoConfig
+	}
+
+	rc, err := config2.ParseRawServicesConfig(old.Path)
+	if err != nil {
+		return nil, err
+	}
+	if issues := ValidateConfig(rc, nil); len(issues) > 0 {
+		return nil, issues
+	}
+
+	var r *ConfigReload
+	err = m.modify(func(t *srvTable) error {
+		sc := rc.ServicesConfig()
+		r = diffConfig(t, m.rawConfig.ServicesConfig(), sc)
+		if len(r.Rejected) > 0 {
+			return fmt.Errorf("%w: %s", ErrIncompatibleConfig, strings.Join(r.Rejected, "; "))
+		}
+
+		for _, s := range sc.Services {
+			name := s.FullName()
+			old := t.idSrvName[name]
+			sci := withRuntimeMethods(convertServiceConfigToConfigInfo(s), old)
+			t.idSrvName[name] = sci
+			for _, alias := range s.Aliases {
+				t.aliases[alias] = name
+			}
+
+			// 方法、方法别名或者废弃说明变化时已注册的服务重新注册方法，健康状态不变
+			srv, registered := t.services[sci.id]
+			if !registered || old == nil || reflect.DeepEqual(old, sci) {
+				continue
+			}
+			ms, err := m.registerMethods(name, srv.handlers(), sci)
+			if err != nil {
+				return err
+			}
+			c := srv.clone()
+			c.methods = ms
+			t.services[sci.id] = c
+		}
+		m.rawConfig = rc
+		return nil
+	})
+	if err != nil {
+		if r != nil && len(r.Rejected) > 0 {
+			log.Printf("Mgr ReloadConfig: rejected %s\n%s", old.Path, strings.Join(append(r.Rejected, r.Changes...), "\n"))
+		}
+		return r, err
+	}
+
+	if len(r.Changes) > 0 {
+		log.Printf("Mgr ReloadConfig: applied %s\n%s", old.Path, strings.Join(r.Changes, "\n"))
+	}
+	return r, nil
+}
+
+// WatchConfig 每隔interval检查配置文件的修改时间以及大小，变化时调用ReloadConfig，之后调用onReload
+// onReload可以为nil。返回的stop用于停止检查
+func (m *Mgr) WatchConfig(interval time.Duration, onReload func(*ConfigReload, error)) (stop func(), err error) {
+	m.mu.Lock()
+	rc := m.rawConfig
+	m.mu.Unlock()
+	if rc == nil {
+		return nil, ErrNoConfig
+	}
+
+	last, err := os.Stat(rc.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			fi, err := os.Stat(rc.Path)
+			if err != nil {
+				log.Printf("Mgr WatchConfig: stat %s failed %s", rc.Path, err)
+				continue
+			}
+			if fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size() {
+				continue
+			}
+			last = fi
+
+			r, err := m.ReloadConfig()
+			if err != nil {
+				log.Printf("Mgr WatchConfig: reload %s failed %s", rc.Path, err)
+			}
+			if onReload != nil {
+				onReload(r, err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}, nil
+}
+
+// diffConfig 比较当前服务表与新的配置，old为当前配置文件中的服务，用于发现删除的服务
+func diffConfig(t *srvTable, old, sc *config2.ServicesConfig) *ConfigReload {
+	r := &ConfigReload{}
+	change := func(format string, args ...interface{}) {
+		r.Changes = append(r.Changes, fmt.Sprintf(format, args...))
+	}
+	reject := func(format string, args ...interface{}) {
+		r.Rejected = append(r.Rejected, fmt.Sprintf(format, args...))
+	}
+
+	names := make(map[string]bool, len(sc.Services))
+	for _, s := range sc.Services {
+		srvName := s.FullName()
+		names[srvName] = true
+		sci, exists := t.idSrvName[srvName]
+		if !exists {
+			if name, isAlias := t.aliases[srvName]; isAlias {
+				reject("! service %s conflicts with alias of %s", srvName, name)
+			} else if other := srvNameOfID(t, s.ID); other != "" {
+				reject("! service %s id %d already used by %s", srvName, s.ID, other)
+			} else {
+				change("+ service %s id %d", srvName, s.ID)
+			}
+		} else if sci.id != s.ID {
+			reject("! service %s id %d -> %d", srvName, sci.id, s.ID)
+		} else {
+			diffMethods(srvName, sci.Methods, sci.runtime, s.Methods, change, reject)
+			diffMethodAliases(srvName, sci.methodAliases, s.MethodAliases, change, reject)
+			if sci.deprecated != s.Deprecated {
+				change("~ service %s deprecated %q", srvName, s.Deprecated)
+			}
+			for _, mn := range sortedKeys(s.Methods) {
+				if sci.deprecatedMethods[mn] != s.DeprecatedMethods[mn] {
+					change("~ method %s.%s deprecated %q", srvName, mn, s.DeprecatedMethods[mn])
+				}
+			}
+		}
+
+		aliases := make(map[string]bool, len(s.Aliases))
+		for _, alias := range s.Aliases {
+			aliases[alias] = true
+			name, exists := t.aliases[alias]
+			switch {
+			case exists && name != srvName:
+				reject("! alias %s %s -> %s", alias, name, srvName)
+			case !exists && t.idSrvName[alias] != nil:
+				reject("! alias %s of %s conflicts with service", alias, srvName)
+			case !exists:
+				change("+ alias %s of %s", alias, srvName)
+			}
+		}
+		for _, alias := range sortedKeys(t.aliases) {
+			if t.aliases[alias] == srvName && !aliases[alias] {
+				reject("- alias %s of %s", alias, srvName)
+			}
+		}
+	}
+
+	for _, s := range old.Services {
+		if !names[s.FullName()] {
+			reject("- service %s id %d", s.FullName(), s.ID)
+		}
+	}
+
+	return r
+}
+
+// diffMethods 运行时增加的方法不在配置文件中，不算删除；配置文件中同名同编号的方法之后由配置文件提供
+func diffMethods(srvName string, old map[string]common2.MethodID, runtime map[string]bool, methods map[string]common2.MethodID, change, reject func(string, ...interface{})) {
+	oldNames := make(map[common2.MethodID]string, len(old))
+	for mn, mid := range old {
+		oldNames[mid] = mn
+	}
+
+	for _, mn := range sortedKeys(methods) {
+		mid := methods[mn]
+		oldID, exists := old[mn]
+		switch {
+		case exists && oldID != mid:
+			reject("! method %s.%s id %d -> %d", srvName, mn, oldID, mid)
+		case !exists && oldNames[mid] != "":
+			reject("! method %s.%s id %d already used by %s", srvName, mn, mid, oldNames[mid])
+		case !exists:
+			change("+ method %s.%s id %d", srvName, mn, mid)
+		}
+	}
+	for _, mn := range sortedKeys(old) {
+		if _, exists := methods[mn]; !exists && !runtime[mn] {
+			reject("- method %s.%s id %d", srvName, mn, old[mn])
+		}
+	}
+}
+
+// withRuntimeMethods 将old中运行时增加、新的配置文件中没有的方法合并到sci，auto同样保留
+func withRuntimeMethods(sci, old *serviceConfigInfo) *serviceConfigInfo {
+	if old == nil {
+		return sci
+	}
+	sci.auto = old.auto
+	var runtime []string
+	for _, mn := range sortedKeys(old.runtime) {
+		if _, inFile := sci.Methods[mn]; !inFile {
+			runtime = append(runtime, mn)
+		}
+	}
+	if len(runtime) == 0 {
+		return sci
+	}
+
+	methods := make(map[string]common2.MethodID, len(sci.Methods)+len(runtime))
+	for mn, mid := range sci.Methods {
+		methods[mn] = mid
+	}
+	sci.Methods = methods
+	sci.runtime = make(map[string]bool, len(runtime))
+	for _, mn := range runtime {
+		sci.Methods[mn] = old.Methods[mn]
+		sci.runtime[mn] = true
+	}
+	return sci
+}
+
+// diffMethodAliases 方法别名可以增加，不能修改或者删除，否则使用别名的go方法无法注册
+func diffMethodAliases(srvName string, old, aliases map[string]string, change, reject func(string, ...interface{})) {
+	for _, alias := range sortedKeys(aliases) {
+		target, exists := old[alias]
+		switch {
+		case exists && target != aliases[alias]:
+			reject("! method alias %s.%s %s -> %s", srvName, alias, target, aliases[alias])
+		case !exists:
+			change("+ method alias %s.%s of %s", srvName, alias, aliases[alias])
+		}
+	}
+	for _, alias := range sortedKeys(old) {
+		if _, exists := aliases[alias]; !exists {
+			reject("- method alias %s.%s of %s", srvName, alias, old[alias])
+		}
+	}
+}
+
+func srvNameOfID(t *srvTable, id common2.SrvID) string {
+	for name, sci := range t.idSrvName {
+		if sci.id == id {
+			return name
+		}
+	}
+	return ""
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
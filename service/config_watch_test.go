@@ -0,0 +1,167 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const watchConfigV1 = `services:
+  - id: 1
+    name: ServerTest
+    methods:
+      Add: 1
+`
+
+const watchConfigV2 = `services:
+  - id: 1
+    name: ServerTest
+    aliases:
+      - Calc
+    methods:
+      Add: 1
+      AddWithStruct: 2
+  - id: 2
+    name: DemoService
+    methods:
+      AddWithStruct: 1
+`
+
+// 修改已有方法的编号
+const watchConfigV3 = `services:
+  - id: 1
+    name: ServerTest
+    aliases:
+      - Calc
+    methods:
+      Add: 3
+      AddWithStruct: 2
+  - id: 2
+    name: DemoService
+    methods:
+      AddWithStruct: 1
+`
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.yml")
+	if err := os.WriteFile(path, []byte(watchConfigV1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mgr := NewServiceMgr(path)
+	if err := mgr.Register(&ServerTest{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := mgr.GetSrvMethodID("ServerTest", "AddWithStruct"); err == nil {
+		t.Fatal("unconfigured method registered")
+	}
+
+	if err := os.WriteFile(path, []byte(watchConfigV2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := mgr.ReloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Changes) != 3 {
+		t.Fatalf("changes %v", r.Changes)
+	}
+	sid, mid, err := mgr.GetSrvMethodID("Calc", "AddWithStruct")
+	if err != nil || sid != 1 || mid != 2 {
+		t.Fatalf("alias lookup %d %d %v", sid, mid, err)
+	}
+	res, err := mgr.Invoke(sid, mid, []interface{}{SchemaX{V: 1}, SchemaX{V: 2}})
+	if err != nil || res[0].(SchemaZ).V != 3 {
+		t.Fatalf("new method result %v %v", res, err)
+	}
+	if err = mgr.Register(&DemoService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(path, []byte(watchConfigV3), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err = mgr.ReloadConfig()
+	if !errors.Is(err, ErrIncompatibleConfig) || len(r.Rejected) != 1 {
+		t.Fatalf("want incompatible got %v %v", r, err)
+	}
+	if _, mid, _ = mgr.GetSrvMethodID("ServerTest", "Add"); mid != 1 {
+		t.Fatalf("rejected config applied, Add id %d", mid)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.yml")
+	if err := os.WriteFile(path, []byte(watchConfigV1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mgr := NewServiceMgr(path)
+	if err := mgr.Register(&ServerTest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := make(chan error, 1)
+	stop, err := mgr.WatchConfig(5*time.Millisecond, func(r *ConfigReload, err error) {
+		reloads <- err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	if err = os.WriteFile(path, []byte(watchConfigV2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-reloads:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("config change not detected")
+	}
+	if _, _, err = mgr.GetSrvMethodID("Calc", "AddWithStruct"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadConfigKeepsRuntimeMethods(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.yml")
+	if err := os.WriteFile(path, []byte(watchConfigV1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mgr := NewServiceMgr(path)
+	mgr.SetAutoIDs(true)
+	if err := mgr.Register(&ServerTest{}); err != nil {
+		t.Fatal(err)
+	}
+	// 配置文件中没有的方法，运行时增加
+	if err := mgr.HandleFunc("ServerTest", "Mul", func(x, y int) int { return x * y }); err != nil {
+		t.Fatal(err)
+	}
+	sid, mid, err := mgr.GetSrvMethodID("ServerTest", "Mul")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 与Mul无关的兼容变化可以应用，Mul保持原来的编号
+	for i := 0; i < 2; i++ {
+		if err = os.WriteFile(path, []byte(watchConfigV2), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = mgr.ReloadConfig(); err != nil {
+			t.Fatalf("reload %d: %v", i, err)
+		}
+		nsid, nmid, err := mgr.GetSrvMethodID("ServerTest", "Mul")
+		if err != nil || nsid != sid || nmid != mid {
+			t.Fatalf("reload %d: Mul id %d.%d was %d.%d %v", i, nsid, nmid, sid, mid, err)
+		}
+		if r, err := mgr.Invoke(sid, mid, []interface{}{2, 3}); err != nil || r[0] != 6 {
+			t.Fatalf("reload %d: Mul result %v %v", i, r, err)
+		}
+	}
+	if _, _, err = mgr.GetSrvMethodID("ServerTest", "AddWithStruct"); err != nil {
+		t.Fatalf("file method not applied %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"log"
+)
+
+// DeprecatedCall 调用配置中废弃的服务或者方法，Service为带版本的服务名，Method为配置中的方法名
+type DeprecatedCall struct {
+	Service string
+	Method  string
+	Message string
+}
+
+// OnDeprecatedCall 设置调用废弃方法时的回调，例如按服务、方法统计调用次数，每次调用都会回调
+// 不设置时只在每个方法第一次调用时记录日志
+func (m *Mgr) OnDeprecatedCall(f func(DeprecatedCall)) {
+	if f == nil {
+		m.deprecatedHook.Store(nil)
+		return
+	}
+	m.deprecatedHook.Store(&f)
+}
+
+func (m *Mgr) reportDeprecated(dc DeprecatedCall) {
+	if _, logged := m.deprecatedLogged.LoadOrStore(dc.Service+"/"+dc.Method, true); !logged {
+		log.Printf("Mgr Invoke: deprecated method %s.%s called: %s", dc.Service, dc.Method, dc.Message)
+	}
+	if f := m.deprecatedHook.Load(); f != nil {
+		(*f)(dc)
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const versionedConfig = `services:
+  - id: 1
+    name: Calc
+    version: v1
+    deprecated: use Calc.v2
+    methods:
+      Add: 1
+      AddWithStruct: 2
+  - id: 2
+    name: Calc
+    version: v2
+    method_aliases:
+      Plus: Add
+    deprecated_methods:
+      AddWithStruct: use Add
+    methods:
+      Add: 1
+      AddWithStruct: 2
+`
+
+// CalcV2 Add改名为Plus，编号不变
+type CalcV2 struct{}
+
+func (c *CalcV2) Plus(x, y int) int {
+	return x + y + 100
+}
+
+func (c *CalcV2) AddWithStruct(x SchemaX, y SchemaX) SchemaZ {
+	return SchemaZ{x.V + y.V}
+}
+
+func TestVersionsAndDeprecation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.yml")
+	if err := os.WriteFile(path, []byte(versionedConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mgr := NewServiceMgr(path)
+	if err := mgr.RegisterVersion("Calc", "v1", &ServerTest{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.RegisterVersion("Calc", "v2", &CalcV2{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []DeprecatedCall
+	mgr.OnDeprecatedCall(func(dc DeprecatedCall) {
+		calls = append(calls, dc)
+	})
+
+	// 两个版本同时可用，go方法Plus通过别名使用Add的编号
+	for _, c := range []struct {
+		srv, method string
+		want        int
+	}{
+		{"Calc.v1", "Add", 3},
+		{"Calc.v2", "Add", 103},
+		{"Calc.v2", "Plus", 103},
+	} {
+		sid, mid, err := mgr.GetSrvMethodID(c.srv, c.method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := mgr.Invoke(sid, mid, []interface{}{1, 2})
+		if err != nil || r[0] != c.want {
+			t.Fatalf("%s.%s result %v %v", c.srv, c.method, r, err)
+		}
+	}
+	sid, mid, _ := mgr.GetSrvMethodID("Calc.v2", "AddWithStruct")
+	if _, err := mgr.Invoke(sid, mid, []interface{}{SchemaX{}, SchemaX{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []DeprecatedCall{
+		{Service: "Calc.v1", Method: "Add", Message: "use Calc.v2"},
+		{Service: "Calc.v2", Method: "AddWithStruct", Message: "use Add"},
+	}
+	if len(calls) != len(want) || calls[0] != want[0] || calls[1] != want[1] {
+		t.Fatalf("deprecated calls %v", calls)
+	}
+
+	for _, s := range mgr.Schema().Services {
+		for _, m := range s.Methods {
+			if s.Name == "Calc.v2" && m.Name == "AddWithStruct" && m.Deprecated != "use Add" {
+				t.Fatalf("schema deprecation %q", m.Deprecated)
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	common2 "learn/irpc/common"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var ErrNotFunc = errors.New("service_mgr: handler is not a func")
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// RegisterOption 注册服务时的选项
+type RegisterOption func(s *service)
+
+// ExcludeMethods 指定的导出方法不作为rpc方法注册，与结构体字段标签`irpc:"exclude=A,B"`效果相同
+func ExcludeMethods(names ...string) RegisterOption {
+	return func(s *service) {
+		for _, name := range names {
+			s.excluded[name] = true
+		}
+	}
+}
+
+func newService(impl interface{}, opts []RegisterOption) *service {
+	s := &service{impl: impl, excluded: tagExcludes(reflect.TypeOf(impl))}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// tagExcludes 结构体任意字段(通常为_ struct{})的irpc标签中exclude=之后逗号分隔的方法名
+func tagExcludes(rt reflect.Type) map[string]bool {
+	excluded := make(map[string]bool)
+	for rt != nil && rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt == nil || rt.Kind() != reflect.Struct {
+		return excluded
+	}
+	for i := 0; i < rt.NumField(); i++ {
+		for _, name := range ExcludeTag(rt.Field(i).Tag) {
+			excluded[name] = true
+		}
+	}
+	return excluded
+}
+
+// ExcludeTag 解析字段标签`irpc:"exclude=A,B"`中排除的方法名，irpc lint也使用该格式
+func ExcludeTag(tag reflect.StructTag) []string {
+	value, ok := tag.Lookup("irpc")
+	if !ok || !strings.HasPrefix(value, "exclude=") {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(strings.TrimPrefix(value, "exclude="), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// HandleFunc 将函数注册为srvName服务的methodName方法。函数可以以context.Context开始、以error结束，最后的参数可以是可变参数
+// 服务需要已经配置，开启自动编号时没有配置的服务、方法由名称编号。服务已注册时增加或替换该方法，同名的结构体方法不再注册
+// 只有函数的服务之后仍然可以Register结构体实现，结构体方法与函数合并
+func (m *Mgr) HandleFunc(srvName, methodName string, fn interface{}) error {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func {
+		log.Printf("Mgr HandleFunc: %s.%s handler %T is not a func", srvName, methodName, fn)
+		return ErrNotFunc
+	}
+
+	return m.modify(func(t *srvTable) error {
+		sci, configured := t.idSrvName[srvName]
+		if !configured {
+			if !m.autoIDs {
+				log.Printf("Mgr HandleFunc: unconfiged srvName %s", srvName)
+				return ErrUnconfiguredSrv
+			}
+			err := m.autoServiceConfig(t, srvName, []string{methodName})
+			if err != nil {
+				return err
+			}
+			sci = t.idSrvName[srvName]
+		}
+		if isReservedService(srvName, sci.id) {
+			return ErrReservedSrv
+		}
+		if _, _, ok := sci.methodID(methodName); !ok {
+			if !m.autoIDs {
+				log.Printf("Mgr HandleFunc: method %s.%s not configured", srvName, methodName)
+				return ErrNotExistMethod
+			}
+			sci = sci.withMethods([]string{methodName})
+			t.idSrvName[srvName] = sci
+		}
+
+		srv := &service{funcs: make(map[string]reflect.Value), excluded: make(map[string]bool)}
+		if old, registered := t.services[sci.id]; registered {
+			srv = old.clone()
+		}
+		srv.funcs[methodName] = fv
+		return m.setService(t, srvName, sci, srv)
+	})
+}
+
+// withMethods 复制配置并自动编号增加的方法。已有方法的编号不变，client可能已经在使用；
+// 新方法按名称顺序从名称哈希开始使用未使用的编号
+func (sci *serviceConfigInfo) withMethods(methodNames []string) *serviceConfigInfo {
+	c := *sci
+	c.Methods = make(map[string]common2.MethodID, len(sci.Methods)+len(methodNames))
+	c.runtime = make(map[string]bool, len(sci.runtime)+len(methodNames))
+	used := make(map[common2.MethodID]bool, len(sci.Methods))
+	for name, id := range sci.Methods {
+		c.Methods[name] = id
+		used[id] = true
+	}
+	for name := range sci.runtime {
+		c.runtime[name] = true
+	}
+	sorted := append([]string(nil), methodNames...)
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		if _, ok := c.Methods[name]; !ok {
+			c.Methods[name] = probeMethodID(name, used)
+			c.runtime[name] = true
+			used[c.Methods[name]] = true
+		}
+	}
+	return &c
+}
+
+// signature 方法或函数中需要编解码的出入参数类型，first为第一个参数的位置，带接收者的方法类型为1
+// 第一个参数为context.Context、最后一个结果为error时不需要编解码
+func signature(ft reflect.Type, first int) (in, out []reflect.Type, hasCtx, hasErr bool) {
+	if ft.NumIn() > first && ft.In(first) == contextType {
+		hasCtx = true
+		first++
+	}
+	for i := first; i < ft.NumIn(); i++ {
+		in = append(in, ft.In(i))
+	}
+
+	numOut := ft.NumOut()
+	if numOut > 0 && ft.Out(numOut-1) == errorType {
+		hasErr = true
+		numOut--
+	}
+	for i := 0; i < numOut; i++ {
+		out = append(out, ft.Out(i))
+	}
+	return in, out, hasCtx, hasErr
+}
+
+// newMethod fn为绑定了接收者的方法或者函数
+func (m *Mgr) newMethod(fn reflect.Value) (*method, error) {
+	in, out, hasCtx, hasErr := signature(fn.Type(), 0)
+	inDescs, outDescs, err := m.registerParamModels(in, out)
+	if err != nil {
+		return nil, err
+	}
+	checkers, err := argCheckers(in)
+	if err != nil {
+		log.Printf("Mgr Register: %s", err)
+		return nil, err
+	}
+	return &method{
+		f:        fn,
+		inDescs:  inDescs,
+		outDescs: outDescs,
+		hasCtx:   hasCtx,
+		hasErr:   hasErr,
+		variadic: fn.Type().IsVariadic(),
+		checkers: checkers,
+	}, nil
+}
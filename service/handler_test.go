@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"errors"
+	common2 "learn/irpc/common"
+	"reflect"
+	"testing"
+)
+
+type ctxKey struct{}
+
+// pinger 未导出的服务类型，Close、Helper不是rpc方法
+type pinger struct {
+	_ struct{} `irpc:"exclude=Close"`
+}
+
+func (p *pinger) Ping(ctx context.Context, name string) (string, error) {
+	if name == "" {
+		return "", errors.New("empty name")
+	}
+	suffix, _ := ctx.Value(ctxKey{}).(string)
+	return "pong " + name + suffix, nil
+}
+
+func (p *pinger) Sum(base int, xs ...int) int {
+	for _, x := range xs {
+		base += x
+	}
+	return base
+}
+
+func (p *pinger) Close() {}
+
+func (p *pinger) Helper() int {
+	return 0
+}
+
+type EchoReq struct {
+	Msg string
+}
+
+type EchoResp struct {
+	Msg string
+}
+
+func TestHandlerSignatures(t *testing.T) {
+	mgr := NewServiceMgr("")
+	mgr.SetAutoIDs(true)
+	err := mgr.Register(&pinger{}, ExcludeMethods("Helper"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Close", "Helper"} {
+		if _, _, err = mgr.GetSrvMethodID("pinger", name); err != ErrNotExistMethod {
+			t.Fatalf("%s should be excluded, got %v", name, err)
+		}
+	}
+
+	// context.Context以及error不参与编解码
+	sid, mid, err := mgr.GetSrvMethodID("pinger", "Ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, out, err := mgr.GetMethodTypes(sid, mid)
+	if err != nil || len(in) != 1 || len(out) != 1 || in[0].Kind() != reflect.String {
+		t.Fatalf("ping types %v %v %v", in, out, err)
+	}
+	h, err := mgr.GetHandler(sid, mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := h.Call(context.WithValue(context.Background(), ctxKey{}, "!"), []interface{}{"irpc"})
+	if err != nil || r[0] != "pong irpc!" {
+		t.Fatalf("ping result %v %v", r, err)
+	}
+	if _, err = mgr.Invoke(sid, mid, []interface{}{""}); err == nil || err.Error() != "empty name" {
+		t.Fatalf("want method error got %v", err)
+	}
+
+	// 可变参数为对应的slice
+	sid, mid, _ = mgr.GetSrvMethodID("pinger", "Sum")
+	r, err = mgr.Invoke(sid, mid, []interface{}{1, []int{2, 3}})
+	if err != nil || r[0] != 6 {
+		t.Fatalf("sum result %v %v", r, err)
+	}
+	r, err = mgr.Invoke(sid, mid, []interface{}{1, nil})
+	if err != nil || r[0] != 1 {
+		t.Fatalf("sum without variadic args %v %v", r, err)
+	}
+}
+
+func TestHandleFunc(t *testing.T) {
+	echo := func(ctx context.Context, req EchoReq) (EchoResp, error) {
+		return EchoResp{Msg: req.Msg}, nil
+	}
+
+	mgr := NewServiceMgr("../config/services.yml")
+	if err := mgr.HandleFunc("Echo", "Echo", echo); err != ErrUnconfiguredSrv {
+		t.Fatalf("want ErrUnconfiguredSrv got %v", err)
+	}
+	if err := mgr.HandleFunc("ServerTest", "Echo", echo); err != ErrNotExistMethod {
+		t.Fatalf("want ErrNotExistMethod got %v", err)
+	}
+	if err := mgr.HandleFunc("ServerTest", "Add", 1); err != ErrNotFunc {
+		t.Fatalf("want ErrNotFunc got %v", err)
+	}
+
+	// 函数替换已注册服务的同名方法
+	if err := mgr.Register(&ServerTest{}); err != nil {
+		t.Fatal(err)
+	}
+	err := mgr.HandleFunc("ServerTest", "Add", func(x, y int) int { return x * y })
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid, mid, _ := mgr.GetSrvMethodID("ServerTest", "Add")
+	if r, err := mgr.Invoke(sid, mid, []interface{}{2, 3}); err != nil || r[0] != 6 {
+		t.Fatalf("func result %v %v", r, err)
+	}
+	// 替换实现之后函数保留
+	if err = mgr.Replace("ServerTest", &ServerTestV2{}); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := mgr.Invoke(sid, mid, []interface{}{2, 3}); err != nil || r[0] != 6 {
+		t.Fatalf("func after replace %v %v", r, err)
+	}
+
+	// 自动编号时由函数组成服务
+	mgr.SetAutoIDs(true)
+	if err = mgr.HandleFunc("Echo", "Echo", echo); err != nil {
+		t.Fatal(err)
+	}
+	if err = mgr.HandleFunc("Echo", "Upper", echo); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Echo", "Upper"} {
+		sid, mid, err = mgr.GetSrvMethodID("Echo", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := mgr.Invoke(sid, mid, []interface{}{EchoReq{Msg: "hi"}})
+		if err != nil || r[0].(EchoResp).Msg != "hi" {
+			t.Fatalf("%s result %v %v", name, r, err)
+		}
+	}
+}
+
+func TestHandleFuncThenRegister(t *testing.T) {
+	// 先注册函数，之后注册的结构体方法与函数合并，同名时函数优先
+	mgr := NewServiceMgr("../config/services.yml")
+	err := mgr.HandleFunc("ServerTest", "Add", func(x, y int) int { return x * y })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mgr.Register(&ServerTest{}); err != nil {
+		t.Fatal(err)
+	}
+	sid, mid, _ := mgr.GetSrvMethodID("ServerTest", "Add")
+	if r, err := mgr.Invoke(sid, mid, []interface{}{2, 3}); err != nil || r[0] != 6 {
+		t.Fatalf("func result %v %v", r, err)
+	}
+	sid, mid, _ = mgr.GetSrvMethodID("ServerTest", "AddWithStruct")
+	if _, err = mgr.GetHandler(sid, mid); err != nil {
+		t.Fatalf("struct method not merged %v", err)
+	}
+	if err = mgr.Register(&ServerTest{}); err != ErrSrvRegistered {
+		t.Fatalf("want ErrSrvRegistered got %v", err)
+	}
+
+	// 自动编号的服务为结构体方法增加编号，函数的编号不变
+	mgr = NewServiceMgr("")
+	mgr.SetAutoIDs(true)
+	if err = mgr.HandleFunc("pinger", "Extra", func() string { return "extra" }); err != nil {
+		t.Fatal(err)
+	}
+	_, extraID, _ := mgr.GetSrvMethodID("pinger", "Extra")
+	if err = mgr.Register(&pinger{}, ExcludeMethods("Helper")); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[common2.MethodID]string)
+	for _, name := range []string{"Extra", "Ping", "Sum"} {
+		sid, mid, err = mgr.GetSrvMethodID("pinger", name)
+		if err != nil || seen[mid] != "" || (name == "Extra" && mid != extraID) {
+			t.Fatalf("%s id %d (extra was %d) %v", name, mid, extraID, err)
+		}
+		seen[mid] = name
+	}
+	sid, mid, _ = mgr.GetSrvMethodID("pinger", "Extra")
+	if r, err := mgr.Invoke(sid, mid, nil); err != nil || r[0] != "extra" {
+		t.Fatalf("extra result %v %v", r, err)
+	}
+	sid, mid, _ = mgr.GetSrvMethodID("pinger", "Ping")
+	if r, err := mgr.Invoke(sid, mid, []interface{}{"irpc"}); err != nil || r[0] != "pong irpc" {
+		t.Fatalf("ping result %v %v", r, err)
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ServingStatus 服务健康状态
+type ServingStatus int32
+
+const (
+	HealthUnknown ServingStatus = iota
+	HealthServing
+	HealthNotServing
+)
+
+const (
+	// OverallHealthName 空服务名表示整个server的健康状态
+	OverallHealthName = ""
+
+	// healthWatchTimeout Watch轮询一次最长阻塞时间，超时返回当前状态，由调用者再次发起Watch
+	healthWatchTimeout = 30 * time.Second
+)
+
+// Health 记录整体以及各个服务的健康状态。状态可由应用设置，server关闭时自动置为HealthNotServing
+type Health struct {
+	mu       *sync.Mutex
+	statuses map[string]ServingStatus
+	// 状态变化时close对应chan并替换为新chan，以此通知所有watcher
+	changed map[string]chan struct{}
+	// shutdown之后SetServingStatus不改变当前状态，只记录到saved，Resume时恢复
+	shutdown bool
+	// saved shutdown之前的状态以及之后设置的状态
+	saved map[string]ServingStatus
+}
+
+func newHealth() *Health {
+	return &Health{
+		mu:       &sync.Mutex{},
+		statuses: map[string]ServingStatus{OverallHealthName: HealthServing},
+		changed:  make(map[string]chan struct{}),
+	}
+}
+
+// SetServingStatus 设置服务状态，srvName为空时设置整体状态
+func (h *Health) SetServingStatus(srvName string, status ServingStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.shutdown {
+		h.saved[srvName] = status
+		return
+	}
+	h.setLocked(srvName, status)
+}
+
+// Shutdown 保存当前状态后将所有服务置为HealthNotServing，之后的SetServingStatus在Resume之后生效
+func (h *Health) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.shutdown {
+		return
+	}
+	h.shutdown = true
+	h.saved = make(map[string]ServingStatus, len(h.statuses))
+	for srvName, status := range h.statuses {
+		h.saved[srvName] = status
+		h.setLocked(srvName, HealthNotServing)
+	}
+}
+
+// Resume 恢复Shutdown之前的状态以及shutdown期间设置的状态，应用标记为HealthNotServing的服务仍然是HealthNotServing
+func (h *Health) Resume() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.shutdown {
+		return
+	}
+	h.shutdown = false
+	for srvName, status := range h.saved {
+		h.setLocked(srvName, status)
+	}
+	h.saved = nil
+}
+
+// Status 获取服务状态，未设置过的服务返回HealthUnknown
+func (h *Health) Status(srvName string) ServingStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.statuses[srvName]
+}
+
+// Watch 返回当前状态以及状态下次变化时被close的chan
+func (h *Health) Watch(srvName string) (ServingStatus, <-chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.statuses[srvName], h.changedChanLocked(srvName)
+}
+
+func (h *Health) setLocked(srvName string, status ServingStatus) {
+	if old, ok := h.statuses[srvName]; ok && old == status {
+		return
+	}
+	h.statuses[srvName] = status
+
+	// 通知watcher
+	if ch, ok := h.changed[srvName]; ok {
+		close(ch)
+		delete(h.changed, srvName)
+	}
+}
+
+func (h *Health) changedChanLocked(srvName string) chan struct{} {
+	ch, ok := h.changed[srvName]
+	if !ok {
+		ch = make(chan struct{})
+		h.changed[srvName] = ch
+	}
+	return ch
+}
+
+// healthService 内置健康检查服务，对外暴露Check、Watch方法
+// 参数只能是已支持的基本类型，所以状态以int32传输
+type healthService struct {
+	h *Health
+}
+
+// Check 返回服务状态，srvName为空时返回整体状态
+func (s *healthService) Check(srvName string) int32 {
+	return int32(s.h.Status(srvName))
+}
+
+// Watch 轮询而不是推送：阻塞直到状态不同于last、超过healthWatchTimeout或者ctx结束，返回当前状态，
+// client收到后以新的状态再次调用。阻塞期间该stream不处理其他请求，client应该为Watch使用单独的stream并设置deadline
+func (s *healthService) Watch(ctx context.Context, srvName string, last int32) int32 {
+	timer := time.NewTimer(healthWatchTimeout)
+	defer timer.Stop()
+
+	for {
+		status, changed := s.h.Watch(srvName)
+		if int32(status) != last {
+			return int32(status)
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return int32(s.h.Status(srvName))
+		case <-ctx.Done():
+			return int32(s.h.Status(srvName))
+		}
+	}
+}
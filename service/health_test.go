@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHealthStatus(t *testing.T) {
+	h := newHealth()
+	if h.Status(OverallHealthName) != HealthServing {
+		t.Fatal("overall should be serving")
+	}
+	if h.Status("NotExist") != HealthUnknown {
+		t.Fatal("not exist srv should be unknown")
+	}
+
+	h.SetServingStatus("Srv", HealthServing)
+	h.Shutdown()
+	if h.Status("Srv") != HealthNotServing || h.Status(OverallHealthName) != HealthNotServing {
+		t.Fatal("shutdown should set not serving")
+	}
+
+	// shutdown之后忽略设置
+	h.SetServingStatus("Srv", HealthServing)
+	if h.Status("Srv") != HealthNotServing {
+		t.Fatal("set after shutdown should be ignored")
+	}
+
+	h.Resume()
+	if h.Status("Srv") != HealthServing {
+		t.Fatal("resume should set serving")
+	}
+}
+
+func TestHealthResumeRestores(t *testing.T) {
+	h := newHealth()
+	h.SetServingStatus("Up", HealthServing)
+	h.SetServingStatus("Broken", HealthNotServing)
+	h.SetServingStatus("Removed", HealthServing)
+
+	h.Shutdown()
+	// shutdown期间的设置在Resume之后生效，例如Unregister
+	h.SetServingStatus("Removed", HealthNotServing)
+	h.SetServingStatus("New", HealthServing)
+	if h.Status("Removed") != HealthNotServing || h.Status("New") != HealthUnknown {
+		t.Fatal("set during shutdown should not change current status")
+	}
+	h.Shutdown()
+
+	h.Resume()
+	wants := map[string]ServingStatus{
+		OverallHealthName: HealthServing,
+		"Up":              HealthServing,
+		"Broken":          HealthNotServing,
+		"Removed":         HealthNotServing,
+		"New":             HealthServing,
+	}
+	for name, want := range wants {
+		if got := h.Status(name); got != want {
+			t.Fatalf("%q after resume %d want %d", name, got, want)
+		}
+	}
+}
+
+func TestHealthWatch(t *testing.T) {
+	hs := &healthService{h: newHealth()}
+	hs.h.SetServingStatus("Srv", HealthServing)
+
+	done := make(chan int32)
+	go func() {
+		done <- hs.Watch(context.Background(), "Srv", int32(HealthServing))
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	hs.h.SetServingStatus("Srv", HealthNotServing)
+
+	select {
+	case status := <-done:
+		if status != int32(HealthNotServing) {
+			t.Fatalf("unexpected status %d", status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watch not notified")
+	}
+}
+
+func TestHealthWatchCanceled(t *testing.T) {
+	hs := &healthService{h: newHealth()}
+	hs.h.SetServingStatus("Srv", HealthServing)
+
+	// client取消之后Watch立即返回当前状态
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan int32)
+	go func() {
+		done <- hs.Watch(ctx, "Srv", int32(HealthServing))
+	}()
+	cancel()
+
+	select {
+	case status := <-done:
+		if status != int32(HealthServing) {
+			t.Fatalf("unexpected status %d", status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watch not canceled")
+	}
+}
+
+func TestInvokeBuiltinHealth(t *testing.T) {
+	mgr := NewServiceMgr("../config/services.yml")
+	sid, mid, err := mgr.GetSrvMethodID(HealthServiceName, HealthCheckMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sid != HealthSrvID {
+		t.Fatal("wrong health srv id")
+	}
+
+	r, err := mgr.Invoke(sid, mid, []interface{}{OverallHealthName})
+	if err != nil || len(r) != 1 || r[0] != int32(HealthServing) {
+		t.Fatal("unexpected result")
+	}
+
+	mgr.Health().Shutdown()
+	r, err = mgr.Invoke(sid, mid, []interface{}{OverallHealthName})
+	if err != nil || r[0] != int32(HealthNotServing) {
+		t.Fatal("unexpected result after shutdown")
+	}
+}
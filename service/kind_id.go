@@ -0,0 +1,66 @@
+package service
+
+import (
+	"hash/fnv"
+	common2 "learn/irpc/common"
+	"log"
+	"reflect"
+	"strings"
+)
+
+// model的kindID由类型指纹哈希得到，与服务注册顺序无关，client、server只要类型一致kindID就一致
+// 指纹包括包路径、类型名以及导出字段的名称和类型，字段变化会改变kindID，两端不一致时在握手阶段发现
+
+// modelFingerprint 结构体model的指纹，只包含参与编解码的导出字段
+func modelFingerprint(rt reflect.Type) string {
+	var sb strings.Builder
+	sb.WriteString(modelName(rt))
+	sb.WriteByte('{')
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		sb.WriteString(field.Name)
+		sb.WriteByte(' ')
+		sb.WriteString(field.Type.String())
+		sb.WriteByte(';')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// codecFingerprint 自定义编解码类型的编码结果与底层结构无关，只使用类型名
+func codecFingerprint(rt reflect.Type) string {
+	return modelName(rt) + "#codec"
+}
+
+// modelName model的唯一名称，由包路径与类型名组成，泛型类型名包含类型参数。匿名结构体使用其类型描述
+func modelName(rt reflect.Type) string {
+	if rt.Name() == "" {
+		return rt.String()
+	}
+	return rt.PkgPath() + "." + rt.Name()
+}
+
+// fingerprintKindID 将指纹的FNV-1a哈希映射到[ModelStartKindID, InvalidKindID)
+func fingerprintKindID(fp string) common2.KindID {
+	return common2.ModelStartKindID + common2.KindID(fnv32a(fp)%uint32(common2.InvalidKindID-common2.ModelStartKindID))
+}
+
+func fnv32a(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// checkKindID 不同model的指纹哈希冲突时返回ErrKindIDConflict，此时需要修改其中一个类型名
+func (m *Mgr) checkKindID(name string, kid common2.KindID) error {
+	for n, k := range m.registeredModels {
+		if k == kid && n != name {
+			log.Printf("Mgr Register: model %s kind id %d conflicts with %s", name, kid, n)
+			return ErrKindIDConflict
+		}
+	}
+	return nil
+}
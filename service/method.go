@@ -1,25 +1,101 @@
 package service
 
 import (
+	"context"
 	"learn/irpc/common"
 	"reflect"
 )
 
 type method struct {
-	f             reflect.Value
-	inParamTypes  []common.KindID
-	outParamTypes []common.KindID
+	f        reflect.Value
+	inDescs  []*common.TypeDesc
+	outDescs []*common.TypeDesc
+	// hasCtx 第一个参数为context.Context，hasErr 最后一个结果为error，均不在inDescs、outDescs中
+	hasCtx   bool
+	hasErr   bool
+	variadic bool
+	// checkers 参数校验，没有需要校验的参数时为nil
+	checkers []checker
+	// 废弃的方法每次调用通过report报告
+	deprecation *DeprecatedCall
+	report      func(DeprecatedCall)
 }
 
-func (m *method) call(argv []interface{}) []interface{} {
-	args := make([]reflect.Value, len(argv))
-	for i, arg := range argv {
-		args[i] = reflect.ValueOf(arg)
+// MethodHandler 已注册的方法。服务被替换或删除之后，已经取得的MethodHandler仍然调用原来的实现
+type MethodHandler struct {
+	m *method
+}
+
+func (h MethodHandler) InDescs() []*common.TypeDesc {
+	return h.m.inDescs
+}
+
+func (h MethodHandler) OutDescs() []*common.TypeDesc {
+	return h.m.outDescs
+}
+
+// Variadic 方法最后的参数为可变参数，对应InDescs中最后的slice
+func (h MethodHandler) Variadic() bool {
+	return h.m.variadic
+}
+
+// Deprecation 方法废弃的说明，没有废弃时为空
+func (h MethodHandler) Deprecation() string {
+	if h.m.deprecation == nil {
+		return ""
+	}
+	return h.m.deprecation.Message
+}
+
+// Validate 校验解码之后的参数：实现了Validator的值以及带有irpc校验标签的字段。失败时返回*ArgumentError
+func (h MethodHandler) Validate(argv []interface{}) error {
+	if h.m.checkers == nil {
+		return nil
+	}
+	return checkArgs(h.m.checkers, argv)
+}
+
+// Call ctx传给以context.Context开始的方法。方法最后的error结果不在返回的结果中，作为error返回
+// 可变参数方法的最后一个参数为对应的slice
+func (h MethodHandler) Call(ctx context.Context, argv []interface{}) ([]interface{}, error) {
+	if h.m.deprecation != nil {
+		h.m.report(*h.m.deprecation)
+	}
+	return h.m.call(ctx, argv)
+}
+
+func (m *method) call(ctx context.Context, argv []interface{}) ([]interface{}, error) {
+	ft := m.f.Type()
+	args := make([]reflect.Value, 0, len(argv)+1)
+	if m.hasCtx {
+		args = append(args, reflect.ValueOf(&ctx).Elem())
+	}
+	for _, arg := range argv {
+		// nil slice、map、指针需要转换为对应类型的零值
+		if arg == nil {
+			args = append(args, reflect.Zero(ft.In(len(args))))
+			continue
+		}
+		args = append(args, reflect.ValueOf(arg))
+	}
+
+	var rvs []reflect.Value
+	if m.variadic {
+		rvs = m.f.CallSlice(args)
+	} else {
+		rvs = m.f.Call(args)
+	}
+
+	var err error
+	if m.hasErr {
+		if rv := rvs[len(rvs)-1]; !rv.IsNil() {
+			err = rv.Interface().(error)
+		}
+		rvs = rvs[:len(rvs)-1]
 	}
-	rvs := m.f.Call(args)
 	rs := make([]interface{}, len(rvs))
 	for i, rv := range rvs {
 		rs[i] = rv.Interface()
 	}
-	return rs
+	return rs, err
 }
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	common2 "learn/irpc/common"
+	"sort"
+)
+
+var ErrSchemaMismatch = errors.New("service_mgr: model schema mismatch")
+
+// ServerSchema 反射服务返回的完整schema。字段均为基本类型，通用工具无需编译期类型即可解析
+type ServerSchema struct {
+	Services []ServiceSchema
+	Models   []ModelSchema
+}
+
+type ServiceSchema struct {
+	Name    string
+	ID      uint16
+	Methods []MethodSchema
+}
+
+// MethodSchema In、Out为参数按前序展开的kindID序列，与Mgr.GetKindIDsByMethod一致。Deprecated为废弃的说明
+type MethodSchema struct {
+	Name       string
+	ID         uint16
+	In         []uint32
+	Out        []uint32
+	Deprecated string
+}
+
+// ModelSchema Name为类型名，匿名结构体为其类型描述，PkgPath为空。Custom为自定义编解码类型，没有字段，内容为varint长度前缀的bytes
+type ModelSchema struct {
+	KindID  uint32
+	Name    string
+	PkgPath string
+	Custom  bool
+	Fields  []FieldSchema
+}
+
+// QualifiedName 包路径加类型名，与Mgr中model的key一致
+func (ms ModelSchema) QualifiedName() string {
+	if ms.PkgPath == "" {
+		return ms.Name
+	}
+	return ms.PkgPath + "." + ms.Name
+}
+
+// FieldSchema Type为go类型描述，Kinds为字段按前序展开的kindID序列
+type FieldSchema struct {
+	Name  string
+	Type  string
+	Kinds []uint32
+}
+
+// Schema 返回所有已注册服务、方法以及model的schema，按id排序
+func (m *Mgr) Schema() ServerSchema {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.table.Load()
+	ss := ServerSchema{
+		Services: make([]ServiceSchema, 0, len(t.services)),
+		Models:   make([]ModelSchema, 0, len(m.registeredModels)),
+	}
+
+	for name, sci := range t.idSrvName {
+		srv, registered := t.services[sci.id]
+		if !registered {
+			continue
+		}
+		ss.Services = append(ss.Services, m.serviceSchema(name, sci, srv))
+	}
+	sort.Slice(ss.Services, func(i, j int) bool {
+		return ss.Services[i].ID < ss.Services[j].ID
+	})
+
+	for _, kid := range m.registeredModels {
+		ss.Models = append(ss.Models, m.modelSchema(kid))
+	}
+	sort.Slice(ss.Models, func(i, j int) bool {
+		return ss.Models[i].KindID < ss.Models[j].KindID
+	})
+
+	return ss
+}
+
+func (m *Mgr) serviceSchema(name string, sci *serviceConfigInfo, srv *service) ServiceSchema {
+	s := ServiceSchema{
+		Name:    name,
+		ID:      uint16(sci.id),
+		Methods: make([]MethodSchema, 0, len(srv.methods)),
+	}
+	for mn, mid := range sci.Methods {
+		f, ok := srv.methods[mid]
+		if !ok {
+			continue
+		}
+		s.Methods = append(s.Methods, MethodSchema{
+			Name:       mn,
+			ID:         uint16(mid),
+			In:         kindIDsToUint32(common2.FlattenKinds(f.inDescs)),
+			Out:        kindIDsToUint32(common2.FlattenKinds(f.outDescs)),
+			Deprecated: MethodHandler{m: f}.Deprecation(),
+		})
+	}
+	sort.Slice(s.Methods, func(i, j int) bool {
+		return s.Methods[i].ID < s.Methods[j].ID
+	})
+
+	return s
+}
+
+func (m *Mgr) modelSchema(kid common2.KindID) ModelSchema {
+	ms := ModelSchema{KindID: uint32(kid)}
+	if rt, ok := m.models.Type(kid); ok {
+		ms.Name, ms.PkgPath = rt.Name(), rt.PkgPath()
+		if ms.Name == "" {
+			ms.Name = rt.String()
+		}
+	}
+	if _, ok := m.models.Codec(kid); ok {
+		ms.Custom = true
+		return ms
+	}
+	plan, ok := m.models.Plan(kid)
+	if !ok {
+		return ms
+	}
+
+	for _, f := range plan.Fields {
+		ms.Fields = append(ms.Fields, FieldSchema{
+			Name:  f.Name,
+			Type:  plan.Type.Field(f.Index).Type.String(),
+			Kinds: kindIDsToUint32(f.Desc.Kinds()),
+		})
+	}
+
+	return ms
+}
+
+// CheckSchema 检查对端schema中与本端同名的model kindID是否一致，不一致说明两端类型定义不同
+// 只存在于一端的model不检查
+func (m *Mgr) CheckSchema(ss ServerSchema) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ms := range ss.Models {
+		name := ms.QualifiedName()
+		kid, ok := m.registeredModels[name]
+		if !ok || uint32(kid) == ms.KindID {
+			continue
+		}
+		return fmt.Errorf("%w: %s kind id %d, peer %d", ErrSchemaMismatch, name, kid, ms.KindID)
+	}
+	return nil
+}
+
+func kindIDsToUint32(kids []common2.KindID) []uint32 {
+	r := make([]uint32, len(kids))
+	for i, kid := range kids {
+		r[i] = uint32(kid)
+	}
+	return r
+}
+
+// reflectionService 内置反射服务
+type reflectionService struct {
+	m *Mgr
+}
+
+// Schema 返回完整schema
+func (s *reflectionService) Schema() ServerSchema {
+	return s.m.Schema()
+}
+
+// ListServices 返回所有已注册的服务名
+func (s *reflectionService) ListServices() []string {
+	ss := s.m.Schema()
+	names := make([]string, len(ss.Services))
+	for i, srv := range ss.Services {
+		names[i] = srv.Name
+	}
+	return names
+}
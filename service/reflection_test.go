@@ -0,0 +1,159 @@
+package service
+
+import (
+	"errors"
+	common2 "learn/irpc/common"
+	"reflect"
+	"testing"
+)
+
+type ServerTest struct {
+}
+
+type SchemaX struct {
+	V  int
+	Vs []int64
+}
+
+type SchemaZ struct {
+	V int
+}
+
+func (s *ServerTest) Add(x, y int) int {
+	return x + y
+}
+
+func (s *ServerTest) AddWithStruct(x SchemaX, y SchemaX) SchemaZ {
+	return SchemaZ{x.V + y.V}
+}
+
+func TestReflectionSchema(t *testing.T) {
+	mgr := NewServiceMgr("../config/services.yml")
+	err := mgr.Register(&ServerTest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss := mgr.Schema()
+	if len(ss.Services) != 3 {
+		t.Fatalf("wrong services len %d", len(ss.Services))
+	}
+
+	st := ss.Services[0]
+	if st.Name != "ServerTest" || st.ID != 1 || len(st.Methods) != 2 {
+		t.Fatalf("wrong service schema %+v", st)
+	}
+	add := st.Methods[0]
+	if add.Name != "Add" || add.ID != 1 || len(add.In) != 2 || add.In[0] != uint32(common2.Int) {
+		t.Fatalf("wrong method schema %+v", add)
+	}
+
+	var x *ModelSchema
+	for i := range ss.Models {
+		if ss.Models[i].Name == "SchemaX" {
+			x = &ss.Models[i]
+		}
+	}
+	if x == nil || len(x.Fields) != 2 {
+		t.Fatal("model SchemaX not found")
+	}
+	vs := x.Fields[1]
+	if vs.Name != "Vs" || len(vs.Kinds) != 2 || vs.Kinds[0] != uint32(common2.Slice) || vs.Kinds[1] != uint32(common2.Int64) {
+		t.Fatalf("wrong field schema %+v", vs)
+	}
+}
+
+func TestInvokeBuiltinReflection(t *testing.T) {
+	mgr := NewServiceMgr("../config/services.yml")
+	err := mgr.Register(&ServerTest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sid, mid, err := mgr.GetSrvMethodID(ReflectionServiceName, ReflectionSchemaMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := mgr.Invoke(sid, mid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟经过编码传输
+	p := common2.NewParser(mgr.GetModels())
+	_, outDescs, err := mgr.GetTypeDescsByMethod(sid, mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := p.EncodeBody(outDescs, r...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := p.ParseBody(body, outDescs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss := result[0].(ServerSchema)
+	if len(ss.Services) != 3 || ss.Services[0].Name != "ServerTest" {
+		t.Fatalf("wrong schema %+v", ss)
+	}
+
+	sid, mid, err = mgr.GetSrvMethodID(ReflectionServiceName, ReflectionListMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = mgr.Invoke(sid, mid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := r[0].([]string)
+	if len(names) != 3 || names[2] != HealthServiceName {
+		t.Fatalf("wrong names %v", names)
+	}
+}
+
+func TestDeterministicKindIDs(t *testing.T) {
+	mgr := NewServiceMgr("../config/services.yml")
+	err := mgr.Register(&ServerTest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 注册顺序不同，kindID仍然一致
+	other := NewServiceMgr("")
+	for _, rt := range []reflect.Type{reflect.TypeOf(SchemaZ{}), reflect.TypeOf(SchemaX{})} {
+		_, err = other.getTypeDesc(rt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, rt := range []reflect.Type{reflect.TypeOf(SchemaX{}), reflect.TypeOf(SchemaZ{})} {
+		name := modelName(rt)
+		kid := mgr.registeredModels[name]
+		if kid < common2.ModelStartKindID || kid != other.registeredModels[name] {
+			t.Fatalf("%s kind id %d, other %d", name, kid, other.registeredModels[name])
+		}
+	}
+	if err = other.CheckSchema(mgr.Schema()); err != nil {
+		t.Fatal(err)
+	}
+
+	ss := mgr.Schema()
+	for i := range ss.Models {
+		if ss.Models[i].Name == "SchemaZ" {
+			ss.Models[i].KindID++
+		}
+	}
+	if err = other.CheckSchema(ss); !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("want ErrSchemaMismatch got %v", err)
+	}
+
+	// 不同model的kindID冲突
+	conflict := NewServiceMgr("")
+	conflict.registeredModels["Other"] = fingerprintKindID(modelFingerprint(reflect.TypeOf(SchemaZ{})))
+	_, err = conflict.getTypeDesc(reflect.TypeOf(SchemaZ{}))
+	if err != ErrKindIDConflict {
+		t.Fatalf("want ErrKindIDConflict got %v", err)
+	}
+}
@@ -2,18 +2,45 @@ package service
 
 import (
 	"learn/irpc/common"
-	"log"
+	"reflect"
 )
 
 type service struct {
 	methods map[common.MethodID]*method
+	// 注册的实现，用于检查配置以及配置变化时重新注册方法。只有HandleFunc注册的函数时为nil
+	impl interface{}
+	// HandleFunc注册的函数，优先于impl的同名方法
+	funcs map[string]reflect.Value
+	// 不作为rpc方法注册的impl方法
+	excluded map[string]bool
 }
 
-func (s *service) call(mn common.MethodID, argv []interface{}) []interface{} {
-	m, ok := s.methods[mn]
-	if !ok {
-		log.Fatalln("wrong method")
+// handlers impl的导出方法以及注册的函数，key为方法名
+func (s *service) handlers() map[string]reflect.Value {
+	hs := make(map[string]reflect.Value)
+	if s.impl != nil {
+		st, sv := reflect.TypeOf(s.impl), reflect.ValueOf(s.impl)
+		for i := 0; i < st.NumMethod(); i++ {
+			if name := st.Method(i).Name; !s.excluded[name] {
+				hs[name] = sv.Method(i)
+			}
+		}
 	}
+	for name, fn := range s.funcs {
+		hs[name] = fn
+	}
+	return hs
+}
 
-	return m.call(argv)
+// clone 复制除methods之外的部分，服务表发布之后service不再修改
+func (s *service) clone() *service {
+	c := &service{
+		impl:     s.impl,
+		funcs:    make(map[string]reflect.Value, len(s.funcs)+1),
+		excluded: s.excluded,
+	}
+	for name, fn := range s.funcs {
+		c.funcs[name] = fn
+	}
+	return c
 }
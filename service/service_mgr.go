@@ -1,17 +1,46 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	common2 "learn/irpc/common"
 	config2 "learn/irpc/config"
 	"log"
 	"reflect"
 	"sync"
+	"sync/atomic"
 )
 
 type serviceConfigInfo struct {
 	id      common2.SrvID
 	Methods map[string]common2.MethodID
+	// 方法别名对应的方法名
+	methodAliases map[string]string
+	// 服务以及方法废弃的说明
+	deprecated        string
+	deprecatedMethods map[string]string
+	// auto 由名称自动编号，合并注册结构体时为没有编号的方法自动编号
+	auto bool
+	// runtime 运行时增加、不在配置文件中的方法，重新加载配置时保留
+	runtime map[string]bool
+}
+
+// methodID 方法名或者别名对应的编号，name为配置中的方法名
+func (sci *serviceConfigInfo) methodID(methodName string) (mid common2.MethodID, name string, ok bool) {
+	if target, isAlias := sci.methodAliases[methodName]; isAlias {
+		methodName = target
+	}
+	mid, ok = sci.Methods[methodName]
+	return mid, methodName, ok
+}
+
+// deprecation 方法废弃的说明，方法没有单独说明时使用服务的说明
+func (sci *serviceConfigInfo) deprecation(methodName string) string {
+	if msg, ok := sci.deprecatedMethods[methodName]; ok {
+		return msg
+	}
+	return sci.deprecated
 }
 
 var (
@@ -19,52 +48,145 @@ var (
 	ErrUnsupportedType             = errors.New("service_mgr: unsupported type")
 	ErrNotExistSrv                 = errors.New("service_mgr: not exist srv")
 	ErrNotExistMethod              = errors.New("service_mgr: not exist method")
+	ErrTypeRegistered              = errors.New("service_mgr: type already registered")
+	ErrKindIDConflict              = errors.New("service_mgr: model kind id conflict")
+	ErrModelConflict               = errors.New("service_mgr: model name conflict")
+	ErrUnconfiguredSrv             = errors.New("service_mgr: unconfigured srv")
+	ErrSrvRegistered               = errors.New("service_mgr: srv already registered")
+	ErrReservedSrv                 = errors.New("service_mgr: reserved srv name or id")
+	ErrSrvIDConflict               = errors.New("service_mgr: srv id conflict")
+	ErrMethodIDConflict            = errors.New("service_mgr: method id conflict")
+	ErrServiceUnavailable          = fmt.Errorf("service_mgr: %w", common2.ErrUnavailable)
 )
 
-type Mgr struct {
+// srvTable 服务编号以及已注册的服务，发布之后不再修改
+type srvTable struct {
 	// 配置文件中srvId与服务名的对应关系。也就是可能导致idSrvName存在的服务，而services不存在
 	idSrvName map[string]*serviceConfigInfo
 	// 注册的服务名和服务信息
 	services map[common2.SrvID]*service
-	mu       *sync.Mutex
+	// 配置中服务的别名对应的服务名
+	aliases map[string]string
+}
+
+func newSrvTable() *srvTable {
+	return &srvTable{
+		idSrvName: make(map[string]*serviceConfigInfo),
+		services:  make(map[common2.SrvID]*service),
+		aliases:   make(map[string]string),
+	}
+}
+
+func (t *srvTable) clone() *srvTable {
+	c := &srvTable{
+		idSrvName: make(map[string]*serviceConfigInfo, len(t.idSrvName)+1),
+		services:  make(map[common2.SrvID]*service, len(t.services)+1),
+		aliases:   make(map[string]string, len(t.aliases)),
+	}
+	for name, sci := range t.idSrvName {
+		c.idSrvName[name] = sci
+	}
+	for id, srv := range t.services {
+		c.services[id] = srv
+	}
+	for alias, name := range t.aliases {
+		c.aliases[alias] = name
+	}
+	return c
+}
+
+// config 服务名或者别名对应的编号
+func (t *srvTable) config(srvName string) (*serviceConfigInfo, bool) {
+	if name, ok := t.aliases[srvName]; ok {
+		srvName = name
+	}
+	sci, ok := t.idSrvName[srvName]
+	return sci, ok
+}
+
+// Mgr 服务表写时复制，调用以及查询编号不加锁。注册、替换、删除服务在mu中复制服务表，修改之后整体替换，
+// 因此server运行时也可以修改服务，已经开始的调用使用原来的实现完成
+type Mgr struct {
+	table atomic.Pointer[srvTable]
+	// 修改服务表以及注册model时加锁
+	mu *sync.Mutex
 	// 注册的所有models
 	models *common2.Models
-	// 已经注册的model名称，假定不存在重名的model
+	// 已经注册的model，key为包路径加类型名，不同包的同名类型互不影响
 	registeredModels map[string]common2.KindID
-	// TODO: 生成储存Kid的文件
-	// 全局记录model id
-	kid common2.KindID
+	// 自定义编解码类型对应的kid
+	typeCodecs map[reflect.Type]common2.KindID
+	// 内置健康检查服务状态
+	health *Health
+	// 没有配置的服务是否由名称自动编号
+	autoIDs bool
+	// 原始配置，用于Validate
+	rawConfig *config2.RawServicesConfig
+	// 调用废弃方法时的回调以及已经记录过日志的方法
+	deprecatedHook   atomic.Pointer[func(DeprecatedCall)]
+	deprecatedLogged sync.Map
 }
 
 func NewServiceMgr(configPath string) *Mgr {
 	mgr := &Mgr{
-		idSrvName:        make(map[string]*serviceConfigInfo),
-		services:         make(map[common2.SrvID]*service),
 		mu:               &sync.Mutex{},
-		models:           &common2.Models{ModelMap: make(map[common2.KindID]reflect.Type)},
+		models:           &common2.Models{},
 		registeredModels: make(map[string]common2.KindID),
-		kid:              common2.ModelStartKindID,
+		typeCodecs:       make(map[reflect.Type]common2.KindID),
+		health:           newHealth(),
 	}
 
-	sc, err := config2.ParseToServicesConfig(configPath)
-	if err != nil {
-		log.Fatalf("Mgr NewServiceMgr: parse config file failed %s", err)
+	// configPath为空时只注册内置服务，例如只依赖反射服务的通用工具
+	// 编号超出范围等问题不在这里终止，通过Validate一次性报告
+	t := newSrvTable()
+	if configPath != "" {
+		rc, err := config2.ParseRawServicesConfig(configPath)
+		if err != nil {
+			log.Fatalf("Mgr NewServiceMgr: parse config file failed %s", err)
+		}
+		mgr.rawConfig = rc
+		initFromConfig(t, rc.ServicesConfig())
 	}
-	mgr.initFromConfig(sc)
+	mgr.registerBuiltinServices(t)
+	mgr.table.Store(t)
 
 	return mgr
 }
 
-func (m *Mgr) initFromConfig(sc *config2.ServicesConfig) {
+func initFromConfig(t *srvTable, sc *config2.ServicesConfig) {
 	for _, s := range sc.Services {
-		m.idSrvName[s.Name] = convertServiceConfigToConfigInfo(s)
+		if isReservedService(s.Name, s.ID) {
+			log.Printf("Mgr initFromConfig: service %s id %d is reserved, skipped", s.Name, s.ID)
+			continue
+		}
+		t.idSrvName[s.FullName()] = convertServiceConfigToConfigInfo(s)
+		for _, alias := range s.Aliases {
+			t.aliases[alias] = s.FullName()
+		}
+	}
+}
+
+// modify 在mu中复制服务表，f成功之后发布，失败时服务表不变
+func (m *Mgr) modify(f func(t *srvTable) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.table.Load().clone()
+	err := f(t)
+	if err != nil {
+		return err
 	}
+	m.table.Store(t)
+	return nil
 }
 
 func convertServiceConfigToConfigInfo(sc *config2.ServiceConfig) *serviceConfigInfo {
 	return &serviceConfigInfo{
-		id:      sc.ID,
-		Methods: sc.Methods,
+		id:                sc.ID,
+		Methods:           sc.Methods,
+		methodAliases:     sc.MethodAliases,
+		deprecated:        sc.Deprecated,
+		deprecatedMethods: sc.DeprecatedMethods,
 	}
 }
 
@@ -80,171 +202,323 @@ func (m *Mgr) RegisterServices(srvs ...interface{}) error {
 	return nil
 }
 
-// Register 不支持并发注册。srv要求指针或接口类型。注册方法出入参数值确保必须大写
-func (m *Mgr) Register(srv interface{}) error {
+// Register 可以并发调用，server运行时也可以注册。srv要求指针或接口类型。注册方法出入参数值确保必须大写
+// 方法可以以context.Context开始、以error结束，最后的参数可以是可变参数。opts可以排除不作为rpc方法的导出方法
+func (m *Mgr) Register(srv interface{}, opts ...RegisterOption) error {
 	// 获取srv名称
 	// TypeOf也无法获取服务名，那该怎么获取呢？
 	srvName := common2.GetServiceName(srv)
 
-	// 检查是否配置过该服务，并获取srvId
-	sci, configured := m.idSrvName[srvName]
+	return m.RegisterWithName(srvName, srv, opts...)
+}
+
+// RegisterWithName 以配置中的服务名注册，实现类型名与服务名不同时使用
+func (m *Mgr) RegisterWithName(srvName string, srv interface{}, opts ...RegisterOption) error {
+	return m.modify(func(t *srvTable) error {
+		return m.register(t, srvName, newService(srv, opts))
+	})
+}
+
+// RegisterVersion 注册服务的某个版本，不同版本可以使用不同的实现同时注册
+func (m *Mgr) RegisterVersion(srvName, version string, srv interface{}, opts ...RegisterOption) error {
+	return m.RegisterWithName(config2.VersionedName(srvName, version), srv, opts...)
+}
+
+func (m *Mgr) register(t *srvTable, srvName string, srv *service) error {
+	// 检查是否配置过该服务，并获取srvId。没有配置时按照名称自动编号
+	sci, configured := t.idSrvName[srvName]
 	if !configured {
-		log.Fatalf("Mgr Register: unconfiged srvName %s", srvName)
+		if !m.autoIDs {
+			log.Printf("Mgr Register: unconfiged srvName %s", srvName)
+			return ErrUnconfiguredSrv
+		}
+		err := m.autoServiceConfig(t, srvName, sortedKeys(srv.handlers()))
+		if err != nil {
+			return err
+		}
+		sci = t.idSrvName[srvName]
 	}
 
-	// 检查serviceName是否已经存在
-	if _, exists := m.services[sci.id]; exists {
-		log.Fatalf("Mgr Register: service name %s exists", srvName)
+	// 检查serviceName是否已经存在。之前只通过HandleFunc注册了函数时合并结构体方法，同名时函数优先
+	if old, exists := t.services[sci.id]; exists {
+		if old.impl != nil || srv.impl == nil {
+			log.Printf("Mgr Register: service name %s exists", srvName)
+			return ErrSrvRegistered
+		}
+		srv.funcs = old.clone().funcs
+		if sci.auto {
+			sci = sci.withMethods(sortedKeys(srv.handlers()))
+			t.idSrvName[srvName] = sci
+		}
 	}
 
-	// 获取service方法，并注册service方法入参、出参
-	ms, err := m.registerMethods(srv, sci.Methods)
+	return m.setService(t, srvName, sci, srv)
+}
+
+// setService 注册srv的方法以及出入参数，放入服务表并设置为可用
+func (m *Mgr) setService(t *srvTable, srvName string, sci *serviceConfigInfo, srv *service) error {
+	ms, err := m.registerMethods(srvName, srv.handlers(), sci)
 	if err != nil {
 		return err
 	}
-	m.services[sci.id] = &service{methods: ms}
+	srv.methods = ms
+	t.services[sci.id] = srv
+
+	// 注册的服务默认可用
+	m.health.SetServingStatus(srvName, HealthServing)
 	return nil
 }
 
-func (m *Mgr) registerMethods(srv interface{}, minfo map[string]common2.MethodID) (map[common2.MethodID]*method, error) {
-	st := reflect.TypeOf(srv)
-	sv := reflect.ValueOf(srv)
-	num := st.NumMethod()
-	ms := make(map[common2.MethodID]*method, num)
-	for i := 0; i < num; i++ {
-		sm := sv.Method(i)
-		mt := st.Method(i)
-		mn := mt.Name
-		inTypes, outTypes, err := m.registerMethodModels(mt.Type)
+// Replace 替换已注册服务的实现，编号不变，HandleFunc注册的函数保留。已经开始的调用使用原来的实现完成
+func (m *Mgr) Replace(srvName string, srv interface{}, opts ...RegisterOption) error {
+	return m.modify(func(t *srvTable) error {
+		sci, registered := t.lookup(srvName)
+		if !registered {
+			return ErrNotExistSrv
+		}
+		if isReservedService(srvName, sci.id) {
+			return ErrReservedSrv
+		}
+		s := newService(srv, opts)
+		s.funcs = t.services[sci.id].clone().funcs
+		return m.setService(t, srvName, sci, s)
+	})
+}
+
+// Unregister 删除已注册的服务，之后对该服务的请求返回ErrServiceUnavailable，已经开始的调用正常完成
+// 服务编号保留，可以再次注册
+func (m *Mgr) Unregister(srvName string) error {
+	return m.modify(func(t *srvTable) error {
+		sci, registered := t.lookup(srvName)
+		if !registered {
+			return ErrNotExistSrv
+		}
+		if isReservedService(srvName, sci.id) {
+			return ErrReservedSrv
+		}
+		delete(t.services, sci.id)
+		m.health.SetServingStatus(srvName, HealthNotServing)
+		return nil
+	})
+}
+
+// lookup 返回服务编号以及服务是否已注册
+func (t *srvTable) lookup(srvName string) (*serviceConfigInfo, bool) {
+	sci, configured := t.idSrvName[srvName]
+	if !configured {
+		return nil, false
+	}
+	_, registered := t.services[sci.id]
+	return sci, registered
+}
+
+// registerMethods 没有配置编号的方法不注册，避免与其他方法编号混淆。go方法名可以是配置中的方法别名
+func (m *Mgr) registerMethods(srvName string, handlers map[string]reflect.Value, sci *serviceConfigInfo) (map[common2.MethodID]*method, error) {
+	ms := make(map[common2.MethodID]*method, len(handlers))
+	names := make(map[common2.MethodID]string, len(handlers))
+	for _, mn := range sortedKeys(handlers) {
+		mid, name, configured := sci.methodID(mn)
+		if !configured {
+			log.Printf("Mgr Register: method %s.%s not configured, skipped", srvName, mn)
+			continue
+		}
+		if other, exists := names[mid]; exists {
+			log.Printf("Mgr Register: method %s.%s id %d conflicts with %s", srvName, mn, mid, other)
+			return nil, ErrMethodIDConflict
+		}
+		names[mid] = mn
+
+		me, err := m.newMethod(handlers[mn])
 		if err != nil {
 			return nil, err
 		}
-		ms[minfo[mn]] = &method{
-			f:             sm,
-			inParamTypes:  inTypes,
-			outParamTypes: outTypes,
+		ms[mid] = me
+		if msg := sci.deprecation(name); msg != "" {
+			ms[mid].deprecation = &DeprecatedCall{Service: srvName, Method: name, Message: msg}
+			ms[mid].report = m.reportDeprecated
 		}
 	}
 
 	return ms, nil
 }
 
-func (m *Mgr) registerMethodModels(f reflect.Type) ([]common2.KindID, []common2.KindID, error) {
-	// 注册入参
-	numIn := f.NumIn()
-	inKids := make([]common2.KindID, 0, numIn)
+// registerMethodModels f为带接收者的方法类型
+func (m *Mgr) registerMethodModels(f reflect.Type) ([]*common2.TypeDesc, []*common2.TypeDesc, error) {
 	// start from 1, for 0 is func receiver
-	for i := 1; i < numIn; i++ {
-		param := f.In(i)
-		paramName := param.Name()
-		kids, err := m.getKindID(param, paramName)
+	in, out, _, _ := signature(f, 1)
+	return m.registerParamModels(in, out)
+}
+
+func (m *Mgr) registerParamModels(in, out []reflect.Type) ([]*common2.TypeDesc, []*common2.TypeDesc, error) {
+	// 注册入参
+	inDescs := make([]*common2.TypeDesc, 0, len(in))
+	for _, rt := range in {
+		desc, err := m.getTypeDesc(rt)
 		if err != nil {
 			return nil, nil, err
 		}
-		kid := kids[0]
-		m.models.ModelMap[kid] = param
-		inKids = append(inKids, kids...)
+		inDescs = append(inDescs, desc)
 	}
 
 	// 注册出参
-	numOut := f.NumOut()
-	outKids := make([]common2.KindID, 0, numOut)
-	for i := 0; i < numOut; i++ {
-		param := f.Out(i)
-		paramName := param.Name()
-		kids, err := m.getKindID(param, paramName)
+	outDescs := make([]*common2.TypeDesc, 0, len(out))
+	for _, rt := range out {
+		desc, err := m.getTypeDesc(rt)
 		if err != nil {
 			return nil, nil, err
 		}
-		kid := kids[0]
-		m.models.ModelMap[kid] = param
-		outKids = append(outKids, kids...)
+		outDescs = append(outDescs, desc)
 	}
 
-	return inKids, outKids, nil
+	return inDescs, outDescs, nil
 }
 
-func (m *Mgr) getKindID(rt reflect.Type, paramName string) ([]common2.KindID, error) {
-	// 预先定义基本类型，直接返回kid
-	if kid, ok := common2.KindMapKindID[rt.Kind()]; ok {
-		// 若是slice、map类型，还要检查元素类型是否注册过
-		if kid == common2.Slice {
-			ekid, err := m.getElemKids(rt)
-			if err != nil {
-				return nil, err
-			}
-
-			return []common2.KindID{kid, ekid}, nil
+// getTypeDesc 递归构建类型描述，支持基本类型、结构体以及它们任意组合的slice、array、map、指针
+func (m *Mgr) getTypeDesc(rt reflect.Type) (*common2.TypeDesc, error) {
+	if kid, ok := m.typeCodecs[rt]; ok {
+		return &common2.TypeDesc{Kind: kid, Type: rt}, nil
+	}
+	// []byte、time.Time等常用类型有专门的编码，优先于按底层结构处理
+	if kid, ok := common2.WellKnownKind(rt); ok {
+		return &common2.TypeDesc{Kind: kid, Type: rt}, nil
+	}
+	// 实现了Marshaler、BinaryMarshaler或TextMarshaler的类型使用其方法编码
+	if codec, ok := common2.NewMarshalerCodec(rt); ok {
+		kid, err := m.registerCodec(codec)
+		if err != nil {
+			return nil, err
 		}
+		return &common2.TypeDesc{Kind: kid, Type: rt}, nil
+	}
 
-		if kid == common2.Map {
-			keyType := rt.Key()
-			kkid, ok := common2.KindMapKindID[keyType.Kind()]
-			if !ok {
-				return nil, ErrUnsupportedSliceMapElemType
-			}
-
-			ekid, err := m.getElemKids(rt)
-			if err != nil {
-				return nil, err
-			}
+	switch rt.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Ptr:
+		elem, err := m.getTypeDesc(rt.Elem())
+		if err != nil {
+			return nil, err
+		}
+		desc := &common2.TypeDesc{Kind: common2.KindMapKindID[rt.Kind()], Elem: elem, Type: rt}
+		if rt.Kind() == reflect.Array {
+			desc.Len = rt.Len()
+		}
+		return desc, nil
 
-			return []common2.KindID{kid, kkid, ekid}, nil
+	case reflect.Map:
+		key, err := m.getTypeDesc(rt.Key())
+		if err != nil {
+			return nil, err
+		}
+		if !common2.IsBasicKind(key.Kind) {
+			return nil, ErrUnsupportedSliceMapElemType
+		}
+		elem, err := m.getTypeDesc(rt.Elem())
+		if err != nil {
+			return nil, err
 		}
+		return &common2.TypeDesc{Kind: common2.Map, Key: key, Elem: elem, Type: rt}, nil
 
-		return []common2.KindID{kid}, nil
+	case reflect.Struct:
+		// 结构体类型，若已经存在，返回已经存在kid，否则由类型指纹得到kid
+		kid, err := m.registerModel(rt)
+		if err != nil {
+			return nil, err
+		}
+		return &common2.TypeDesc{Kind: kid, Type: rt}, nil
 	}
 
-	// 结构体类型，若已经存在，返回已经存在kid，否则采用新kid，并递增
-	if rt.Kind() == reflect.Struct {
-		if kid, exists := m.registeredModels[paramName]; exists {
-			return []common2.KindID{kid}, nil
-		}
-		kid := m.kid
-		m.registeredModels[paramName] = kid
-		m.kid++
-		return []common2.KindID{kid}, nil
+	// 预先定义基本类型，直接返回kid
+	if kid, ok := common2.KindMapKindID[rt.Kind()]; ok {
+		return &common2.TypeDesc{Kind: kid, Type: rt}, nil
 	}
 
 	// 其他类型返回无效类型
 	return nil, ErrUnsupportedType
 }
 
-// 必须要是slice、map类型
-func (m *Mgr) getElemKids(rt reflect.Type) (common2.KindID, error) {
-	elemType := rt.Elem()
-	if ekid, ok := common2.KindMapKindID[elemType.Kind()]; ok {
-		return ekid, nil
+func (m *Mgr) registerModel(rt reflect.Type) (common2.KindID, error) {
+	name := modelName(rt)
+	if kid, exists := m.registeredModels[name]; exists {
+		// 同名却是不同的类型，例如字段不同的同名匿名结构体
+		if registered, ok := m.models.Type(kid); ok && registered != rt {
+			log.Printf("Mgr Register: model %s conflicts with registered %s", rt, registered)
+			return common2.InvalidKindID, ErrModelConflict
+		}
+		return kid, nil
 	}
+	kid := fingerprintKindID(modelFingerprint(rt))
+	if err := m.checkKindID(name, kid); err != nil {
+		return common2.InvalidKindID, err
+	}
+	m.registeredModels[name] = kid
 
-	if elemType.Kind() == reflect.Struct {
-		ekids, err := m.getKindID(elemType, elemType.Name())
-		if err != nil {
-			return common2.InvalidKindID, err
-		}
-		ekid := ekids[0]
-		return ekid, nil
+	// 先记录kid再构建编解码计划，字段引用自身时直接返回kid。嵌套的结构体在其中递归注册
+	plan, err := common2.NewStructPlan(rt, m.getTypeDesc)
+	if err != nil {
+		delete(m.registeredModels, name)
+		return common2.InvalidKindID, err
 	}
+	m.models.AddModel(kid, plan)
+	return kid, nil
+}
 
-	return common2.InvalidKindID, ErrUnsupportedType
+// RegisterTypeCodec 为无法添加方法的类型注册编解码，例如第三方库中的类型。encoder的参数为rt类型的值，decoder需要返回rt类型的值
+// 需要在注册使用该类型的服务之前调用，client以及server需要保持一致
+func (m *Mgr) RegisterTypeCodec(rt reflect.Type, encoder func(interface{}) ([]byte, error), decoder func([]byte) (interface{}, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.registerCodec(common2.NewTypeCodec(rt, encoder, decoder))
+	return err
 }
 
-func (m *Mgr) Invoke(srvID common2.SrvID, mID common2.MethodID, args []interface{}) []interface{} {
-	srv, exists := m.services[srvID]
-	if !exists {
-		log.Fatalf("Mgr Invoke: service %d not exists", srvID)
+func (m *Mgr) registerCodec(codec *common2.TypeCodec) (common2.KindID, error) {
+	name := modelName(codec.Type)
+	if _, exists := m.registeredModels[name]; exists {
+		log.Printf("Mgr RegisterTypeCodec: type %s already registered", codec.Type)
+		return common2.InvalidKindID, ErrTypeRegistered
 	}
+	kid := fingerprintKindID(codecFingerprint(codec.Type))
+	if err := m.checkKindID(name, kid); err != nil {
+		return common2.InvalidKindID, err
+	}
+	m.registeredModels[name] = kid
+	m.typeCodecs[codec.Type] = kid
+	m.models.AddCodec(kid, codec)
+	return kid, nil
+}
 
-	return srv.call(mID, args)
+// Invoke 调用方法，服务没有注册或已删除时返回ErrServiceUnavailable，参数校验失败时返回*ArgumentError，方法最后的error结果作为error返回
+func (m *Mgr) Invoke(srvID common2.SrvID, mID common2.MethodID, args []interface{}) ([]interface{}, error) {
+	h, err := m.GetHandler(srvID, mID)
+	if err != nil {
+		return nil, err
+	}
+	if err = h.Validate(args); err != nil {
+		return nil, err
+	}
+	return h.Call(context.Background(), args)
+}
+
+// GetHandler 获取方法，之后服务被替换或删除也不影响该方法的调用。server对同一个请求的解析、调用以及编码使用同一个MethodHandler
+func (m *Mgr) GetHandler(srvID common2.SrvID, mID common2.MethodID) (MethodHandler, error) {
+	srv, exists := m.table.Load().services[srvID]
+	if !exists {
+		return MethodHandler{}, ErrServiceUnavailable
+	}
+	f, exists := srv.methods[mID]
+	if !exists {
+		return MethodHandler{}, ErrNotExistMethod
+	}
+	return MethodHandler{m: f}, nil
 }
 
+// GetSrvMethodID srvName可以是服务名、带版本的服务名或者配置中的别名，methodName可以是方法名或者方法别名
 func (m *Mgr) GetSrvMethodID(srvName, methodName string) (common2.SrvID, common2.MethodID, error) {
-	cfg, ok := m.idSrvName[srvName]
+	cfg, ok := m.table.Load().config(srvName)
 	if !ok {
 		return 0, 0, ErrNotExistSrv
 	}
 
-	mid, ok := cfg.Methods[methodName]
+	mid, _, ok := cfg.methodID(methodName)
 	if !ok {
 		return 0, 0, ErrNotExistMethod
 	}
@@ -253,15 +527,44 @@ func (m *Mgr) GetSrvMethodID(srvName, methodName string) (common2.SrvID, common2
 	return srvID, mid, nil
 }
 
-func (m *Mgr) GetKindIDsByMethod(sid common2.SrvID, mid common2.MethodID) ([]common2.KindID, []common2.KindID) {
-	f := m.services[sid].methods[mid]
-	return f.inParamTypes, f.outParamTypes
+// GetTypeDescsByMethod 获取方法出入参数的类型描述，用于编解码
+func (m *Mgr) GetTypeDescsByMethod(sid common2.SrvID, mid common2.MethodID) ([]*common2.TypeDesc, []*common2.TypeDesc, error) {
+	h, err := m.GetHandler(sid, mid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h.InDescs(), h.OutDescs(), nil
+}
+
+// GetKindIDsByMethod 获取方法出入参数按前序展开的kindID序列
+func (m *Mgr) GetKindIDsByMethod(sid common2.SrvID, mid common2.MethodID) ([]common2.KindID, []common2.KindID, error) {
+	h, err := m.GetHandler(sid, mid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return common2.FlattenKinds(h.InDescs()), common2.FlattenKinds(h.OutDescs()), nil
+}
+
+// GetMethodTypes 获取方法需要编解码的出入参数的go类型，用于调用前检查参数类型。不包括context.Context以及最后的error
+func (m *Mgr) GetMethodTypes(sid common2.SrvID, mid common2.MethodID) ([]reflect.Type, []reflect.Type, error) {
+	h, err := m.GetHandler(sid, mid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	in, out, _, _ := signature(h.m.f.Type(), 0)
+	return in, out, nil
 }
 
 func (m *Mgr) GetModels() *common2.Models {
 	return m.models
 }
 
+// Health 获取内置健康检查服务状态，应用可通过它设置服务状态
+func (m *Mgr) Health() *Health {
+	return m.health
+}
+
 // 	case reflect.Bool:
 //		return common.Bool
 //	case reflect.Int:
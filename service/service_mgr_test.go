@@ -1,13 +1,19 @@
 package service
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	common2 "learn/irpc/common"
+	config2 "learn/irpc/config"
+	"math/big"
 	"reflect"
+	"strconv"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestRegisterService(t *testing.T) {
@@ -323,17 +329,15 @@ func TestRegisterMethodModels(t *testing.T) {
 	dst := reflect.TypeOf(ds)
 	mt := dst.Method(1).Type
 	mgr := &Mgr{
-		idSrvName:        make(map[string]*serviceConfigInfo),
-		services:         make(map[common2.SrvID]*service),
 		mu:               &sync.Mutex{},
-		models:           &common2.Models{ModelMap: make(map[common2.KindID]reflect.Type)},
+		models:           &common2.Models{},
 		registeredModels: make(map[string]common2.KindID),
-		kid:              common2.ModelStartKindID,
 	}
-	inKids, outKids, err := mgr.registerMethodModels(mt)
+	inDescs, outDescs, err := mgr.registerMethodModels(mt)
 	if err != nil {
 		t.Fatal(err)
 	}
+	inKids, outKids := common2.FlattenKinds(inDescs), common2.FlattenKinds(outDescs)
 
 	if len(inKids) != 5 {
 		t.Fatal("wrong in len")
@@ -351,7 +355,7 @@ func TestRegisterMethodModels(t *testing.T) {
 		t.Fatal("str wrong")
 	}
 
-	if inKids[2] != common2.Map || inKids[3] != common2.Int64 || inKids[4] != 16 {
+	if inKids[2] != common2.Map || inKids[3] != common2.Int64 || inKids[4] != mgr.registeredModels[modelName(reflect.TypeOf(AddParam{}))] {
 		t.Fatal("map wrong")
 	}
 
@@ -364,6 +368,268 @@ func TestRegisterMethodModels(t *testing.T) {
 	}
 }
 
+type PlanStatus int32
+
+type PlanInner struct {
+	V     int64
+	State PlanStatus
+}
+
+type PlanNode struct {
+	Name     string
+	Inner    PlanInner
+	Tags     map[string][]PlanStatus
+	Children []PlanNode
+	Raw      []byte
+	Next     *PlanNode
+	Grid     [2][3]int8
+	Opt      map[string]*PlanInner
+	Matrix   [][]PlanStatus
+}
+
+func TestStructPlanRoundTrip(t *testing.T) {
+	mgr := NewServiceMgr("")
+	desc, err := mgr.getTypeDesc(reflect.TypeOf(PlanNode{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	descs := []*common2.TypeDesc{desc}
+	// 嵌套的结构体也需要注册
+	if _, ok := mgr.registeredModels[modelName(reflect.TypeOf(PlanInner{}))]; !ok {
+		t.Fatal("nested model not registered")
+	}
+
+	n := PlanNode{
+		Name:  "{[",
+		Inner: PlanInner{V: 1<<62 + 1, State: 3},
+		Tags:  map[string][]PlanStatus{"a": {1, 2}, "b": nil},
+		Children: []PlanNode{
+			{Name: "child", Raw: []byte{}},
+		},
+		Raw:    []byte{'}', ']'},
+		Next:   &PlanNode{Name: "next", Raw: []byte{}},
+		Grid:   [2][3]int8{{1, 2, 3}, {-1, 91, 123}},
+		Opt:    map[string]*PlanInner{"x": {V: -1}, "nil": nil},
+		Matrix: [][]PlanStatus{{1}, nil, {}},
+	}
+	p := common2.NewParser(mgr.GetModels())
+	body, err := p.EncodeBody(descs, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := p.ParseBody(body, descs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r[0], n) {
+		t.Fatalf("struct wrong %+v", r[0])
+	}
+
+	_, err = p.EncodeBody(descs, PlanInner{})
+	if err != common2.ErrNotMatchedParam {
+		t.Fatalf("want ErrNotMatchedParam got %v", err)
+	}
+}
+
+// ServiceConfig 与config.ServiceConfig同名
+type ServiceConfig struct {
+	Name   string
+	Weight int
+}
+
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+func TestQualifiedModelNames(t *testing.T) {
+	mgr := NewServiceMgr("")
+	types := []reflect.Type{
+		reflect.TypeOf(ServiceConfig{}),
+		reflect.TypeOf(config2.ServiceConfig{}),
+		reflect.TypeOf(Pair[string, int]{}),
+		reflect.TypeOf(Pair[string, PlanInner]{}),
+		reflect.TypeOf(struct{ V int }{}),
+		reflect.TypeOf(struct{ V string }{}),
+	}
+	kids := make(map[common2.KindID]reflect.Type)
+	for _, rt := range types {
+		desc, err := mgr.getTypeDesc(rt)
+		if err != nil {
+			t.Fatalf("%s: %v", rt, err)
+		}
+		if other, ok := kids[desc.Kind]; ok {
+			t.Fatalf("%s shares kind id with %s", rt, other)
+		}
+		kids[desc.Kind] = rt
+	}
+
+	// 各自按照自己的字段编解码
+	descs := make([]*common2.TypeDesc, len(types))
+	for i, rt := range types {
+		descs[i], _ = mgr.getTypeDesc(rt)
+	}
+	params := []interface{}{
+		ServiceConfig{Name: "a", Weight: 3},
+		config2.ServiceConfig{ID: 2, Name: "b", Methods: map[string]common2.MethodID{"M": 1}},
+		Pair[string, int]{Key: "k", Value: 1},
+		Pair[string, PlanInner]{Key: "k", Value: PlanInner{V: 2}},
+		struct{ V int }{V: 3},
+		struct{ V string }{V: "v"},
+	}
+	p := common2.NewParser(mgr.GetModels())
+	body, err := p.EncodeBody(descs, params...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := p.ParseBody(body, descs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r, params) {
+		t.Fatalf("models wrong %+v", r)
+	}
+
+	ms := mgr.modelSchema(descs[1].Kind)
+	if ms.Name != "ServiceConfig" || ms.QualifiedName() != "learn/irpc/config.ServiceConfig" {
+		t.Fatalf("wrong schema name %s %s", ms.Name, ms.PkgPath)
+	}
+
+	// 同一个包中同名的不同类型无法区分
+	type ServiceConfig struct {
+		ID int
+	}
+	_, err = mgr.getTypeDesc(reflect.TypeOf(ServiceConfig{}))
+	if err != ErrModelConflict {
+		t.Fatalf("want ErrModelConflict got %v", err)
+	}
+}
+
+type WellKnownID [16]byte
+
+type WellKnownEvent struct {
+	ID      WellKnownID
+	At      time.Time
+	Timeout time.Duration
+	Amount  *big.Int
+	Payload []byte
+}
+
+func TestWellKnownTypeDesc(t *testing.T) {
+	mgr := NewServiceMgr("")
+	desc, err := mgr.getTypeDesc(reflect.TypeOf(WellKnownEvent{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, _ := mgr.GetModels().Plan(desc.Kind)
+	want := [][]common2.KindID{
+		{common2.UUID}, {common2.Time}, {common2.Duration}, {common2.Ptr, common2.BigInt}, {common2.Bytes},
+	}
+	for i, f := range plan.Fields {
+		if !reflect.DeepEqual(f.Desc.Kinds(), want[i]) {
+			t.Fatalf("field %s kinds %v", f.Name, f.Desc.Kinds())
+		}
+	}
+	// time.Time等常用类型不注册为model
+	if _, ok := mgr.registeredModels[modelName(reflect.TypeOf(time.Time{}))]; ok {
+		t.Fatal("time.Time registered as model")
+	}
+
+	e := WellKnownEvent{
+		ID:      WellKnownID{1, 15: 2},
+		At:      time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		Timeout: 3 * time.Second,
+		Amount:  big.NewInt(-42),
+		Payload: []byte{},
+	}
+	descs := []*common2.TypeDesc{desc}
+	p := common2.NewParser(mgr.GetModels())
+	body, err := p.EncodeBody(descs, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := p.ParseBody(body, descs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r[0], e) {
+		t.Fatalf("event wrong %+v", r[0])
+	}
+}
+
+// CodecLevel 以名称编码的枚举
+type CodecLevel int
+
+func (l CodecLevel) MarshalText() ([]byte, error) {
+	return []byte(strconv.Itoa(int(l) * 10)), nil
+}
+
+func (l *CodecLevel) UnmarshalText(b []byte) error {
+	n, err := strconv.Atoi(string(b))
+	*l = CodecLevel(n / 10)
+	return err
+}
+
+// CodecPoint 假定为第三方类型
+type CodecPoint struct {
+	X, Y int32
+}
+
+type CodecEvent struct {
+	Level  CodecLevel
+	Points []CodecPoint
+}
+
+func TestRegisterTypeCodec(t *testing.T) {
+	mgr := NewServiceMgr("")
+	err := mgr.RegisterTypeCodec(reflect.TypeOf(CodecPoint{}), func(v interface{}) ([]byte, error) {
+		pt := v.(CodecPoint)
+		return []byte{byte(pt.X), byte(pt.Y)}, nil
+	}, func(b []byte) (interface{}, error) {
+		if len(b) != 2 {
+			return nil, errors.New("wrong point")
+		}
+		return CodecPoint{X: int32(b[0]), Y: int32(b[1])}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mgr.RegisterTypeCodec(reflect.TypeOf(CodecPoint{}), nil, nil)
+	if err != ErrTypeRegistered {
+		t.Fatalf("want ErrTypeRegistered got %v", err)
+	}
+
+	desc, err := mgr.getTypeDesc(reflect.TypeOf(CodecEvent{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 自定义编解码类型不按照结构体注册字段
+	if _, ok := mgr.GetModels().Plan(mgr.registeredModels[modelName(reflect.TypeOf(CodecPoint{}))]); ok {
+		t.Fatal("codec type registered as struct")
+	}
+	if _, ok := mgr.GetModels().Codec(mgr.registeredModels[modelName(reflect.TypeOf(CodecLevel(0)))]); !ok {
+		t.Fatal("text marshaler not detected")
+	}
+	if !mgr.modelSchema(mgr.registeredModels[modelName(reflect.TypeOf(CodecLevel(0)))]).Custom {
+		t.Fatal("schema should be custom")
+	}
+
+	e := CodecEvent{Level: 3, Points: []CodecPoint{{1, 2}, {3, 4}}}
+	descs := []*common2.TypeDesc{desc}
+	p := common2.NewParser(mgr.GetModels())
+	body, err := p.EncodeBody(descs, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := p.ParseBody(body, descs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r[0], e) {
+		t.Fatalf("event wrong %+v", r[0])
+	}
+}
+
 type CallTest struct {
 }
 
@@ -393,3 +659,242 @@ func TestCallWithInterfaceParam(t *testing.T) {
 		t.Fatal("wrong")
 	}
 }
+
+func TestAutoIDs(t *testing.T) {
+	mgr := NewServiceMgr("")
+	err := mgr.Register(&ServerTest{})
+	if err != ErrUnconfiguredSrv {
+		t.Fatalf("want ErrUnconfiguredSrv got %v", err)
+	}
+
+	mgr.SetAutoIDs(true)
+	err = mgr.Register(&ServerTest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid, mid, err := mgr.GetSrvMethodID("ServerTest", "Add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sid != autoSrvID("ServerTest") || mid != autoMethodID("Add") || sid >= ReservedSrvIDStart || mid == 0 {
+		t.Fatalf("wrong auto ids %d %d", sid, mid)
+	}
+	r, err := mgr.Invoke(sid, mid, []interface{}{1, 2})
+	if err != nil || r[0] != 3 {
+		t.Fatalf("wrong result %v", r)
+	}
+
+	// 另一端注册相同的服务得到相同的编号
+	other := NewServiceMgr("")
+	other.SetAutoIDs(true)
+	err = other.Register(&ServerTest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	osid, omid, _ := other.GetSrvMethodID("ServerTest", "Add")
+	if osid != sid || omid != mid {
+		t.Fatalf("ids differ %d.%d %d.%d", sid, mid, osid, omid)
+	}
+
+	err = mgr.Register(&ServerTest{})
+	if err != ErrSrvRegistered {
+		t.Fatalf("want ErrSrvRegistered got %v", err)
+	}
+}
+
+func TestAutoMethodIDs(t *testing.T) {
+	// 方法数不超过255时编号都在v1范围内且不冲突，与名称顺序无关
+	for _, n := range []int{20, 255, 300} {
+		names := make([]string, n)
+		for i := range names {
+			names[i] = "Method" + strconv.Itoa(i)
+		}
+		ids := autoMethodIDs(names)
+		for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+			names[i], names[j] = names[j], names[i]
+		}
+		if !reflect.DeepEqual(ids, autoMethodIDs(names)) {
+			t.Fatalf("%d methods: ids depend on order", n)
+		}
+		seen := make(map[common2.MethodID]string, n)
+		for name, mid := range ids {
+			if other, ok := seen[mid]; ok || mid == 0 {
+				t.Fatalf("%d methods: %s id %d conflicts with %q", n, name, mid, other)
+			}
+			if n <= int(common2.MaxV1MethodID) && mid > common2.MaxV1MethodID {
+				t.Fatalf("%d methods: %s id %d out of v1 range", n, name, mid)
+			}
+			seen[mid] = name
+		}
+	}
+
+	// HandleFunc逐个增加方法时已有方法的编号不变，新方法不与已有方法冲突
+	mgr := NewServiceMgr("")
+	mgr.SetAutoIDs(true)
+	assigned := make(map[string]common2.MethodID)
+	for i := 0; i < 40; i++ {
+		name := "Func" + strconv.Itoa(i)
+		if err := mgr.HandleFunc("Funcs", name, func() {}); err != nil {
+			t.Fatal(err)
+		}
+		seen := make(map[common2.MethodID]string)
+		assigned[name] = 0
+		for mn, want := range assigned {
+			_, mid, err := mgr.GetSrvMethodID("Funcs", mn)
+			if err != nil || (want != 0 && mid != want) {
+				t.Fatalf("after %s: %s id %d was %d %v", name, mn, mid, want, err)
+			}
+			if other, ok := seen[mid]; ok {
+				t.Fatalf("after %s: %s id %d conflicts with %s", name, mn, mid, other)
+			}
+			seen[mid] = mn
+			assigned[mn] = mid
+		}
+	}
+	for mn, mid := range assigned {
+		if _, err := mgr.Invoke(autoSrvID("Funcs"), mid, nil); err != nil {
+			t.Fatalf("%s invoke %v", mn, err)
+		}
+	}
+}
+
+func TestRegisterWithIDs(t *testing.T) {
+	mgr := NewServiceMgr("")
+	// 没有指定编号的方法不注册
+	err := mgr.RegisterWithIDs("DemoService", 7, map[string]common2.MethodID{"AddWithStruct": 1}, &DemoService{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid, mid, err := mgr.GetSrvMethodID("DemoService", "AddWithStruct")
+	if err != nil || sid != 7 || mid != 1 {
+		t.Fatalf("wrong ids %d %d %v", sid, mid, err)
+	}
+	if _, _, err = mgr.GetSrvMethodID("DemoService", "Meaningless"); err != ErrNotExistMethod {
+		t.Fatalf("want ErrNotExistMethod got %v", err)
+	}
+	if _, _, err = mgr.GetMethodTypes(7, 0); err != ErrNotExistMethod {
+		t.Fatalf("unlisted method registered as 0: %v", err)
+	}
+
+	err = mgr.RegisterWithIDs("CallTest", 7, map[string]common2.MethodID{"Sum": 1}, &CallTest{})
+	if err != ErrSrvIDConflict {
+		t.Fatalf("want ErrSrvIDConflict got %v", err)
+	}
+	err = mgr.RegisterWithIDs("CallTest", HealthSrvID, map[string]common2.MethodID{"Sum": 1}, &CallTest{})
+	if err != ErrReservedSrv {
+		t.Fatalf("want ErrReservedSrv got %v", err)
+	}
+	err = mgr.RegisterWithIDs("ServerTest", 8, map[string]common2.MethodID{"Add": 1, "AddWithStruct": 1}, &ServerTest{})
+	if err != ErrMethodIDConflict {
+		t.Fatalf("want ErrMethodIDConflict got %v", err)
+	}
+	// 注册失败不保留编号
+	err = mgr.RegisterWithIDs("ServerTest", 8, map[string]common2.MethodID{"Add": 1, "AddWithStruct": 2}, &ServerTest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ServerTestV2 替换ServerTest的实现
+type ServerTestV2 struct {
+	ServerTest
+}
+
+func (s *ServerTestV2) Add(x, y int) int {
+	return x + y + 100
+}
+
+func TestReplaceAndUnregister(t *testing.T) {
+	mgr := NewServiceMgr("../config/services.yml")
+	err := mgr.Register(&ServerTest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid, mid, err := mgr.GetSrvMethodID("ServerTest", "Add")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 调用与修改并发进行
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				r, err := mgr.Invoke(sid, mid, []interface{}{1, 2})
+				if err != nil && !errors.Is(err, ErrServiceUnavailable) {
+					t.Error(err)
+					return
+				}
+				if err == nil && r[0] != 3 && r[0] != 103 {
+					t.Errorf("wrong result %v", r)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		if err = mgr.Replace("ServerTest", &ServerTestV2{}); err != nil {
+			t.Fatal(err)
+		}
+		if err = mgr.Unregister("ServerTest"); err != nil {
+			t.Fatal(err)
+		}
+		if err = mgr.Register(&ServerTest{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	// 替换之前取得的handler仍然调用原来的实现
+	h, err := mgr.GetHandler(sid, mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mgr.Replace("ServerTest", &ServerTestV2{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := h.Call(context.Background(), []interface{}{1, 2}); err != nil || r[0] != 3 {
+		t.Fatalf("old handler result %v", r)
+	}
+	r, err := mgr.Invoke(sid, mid, []interface{}{1, 2})
+	if err != nil || r[0] != 103 {
+		t.Fatalf("replaced result %v %v", r, err)
+	}
+
+	err = mgr.Unregister("ServerTest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mgr.Invoke(sid, mid, []interface{}{1, 2})
+	if !errors.Is(err, common2.ErrUnavailable) {
+		t.Fatalf("want unavailable got %v", err)
+	}
+	if mgr.Health().Status("ServerTest") != HealthNotServing {
+		t.Fatal("unregistered service still serving")
+	}
+	if err = mgr.Unregister("ServerTest"); err != ErrNotExistSrv {
+		t.Fatalf("want ErrNotExistSrv got %v", err)
+	}
+	if err = mgr.Replace("ServerTest", &ServerTestV2{}); err != ErrNotExistSrv {
+		t.Fatalf("want ErrNotExistSrv got %v", err)
+	}
+	if err = mgr.Unregister(HealthServiceName); err != ErrReservedSrv {
+		t.Fatalf("want ErrReservedSrv got %v", err)
+	}
+
+	// client收到的错误状态同样可以判断
+	se := &common2.StatusError{Code: common2.StatusUnavailable, Message: err.Error()}
+	if !errors.Is(se, common2.ErrUnavailable) || errors.Is(se, common2.ErrMessageTooLarge) {
+		t.Fatal("wrong status error")
+	}
+}
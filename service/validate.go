@@ -0,0 +1,173 @@
+package service
+
+import (
+	"fmt"
+	common2 "learn/irpc/common"
+	config2 "learn/irpc/config"
+	"sort"
+	"strings"
+)
+
+// Validate 检查services.yml与已注册的服务是否一致，返回所有问题，没有问题时返回nil
+// 问题包括重复的服务名、服务编号、方法编号，超出范围或预留的编号，以及配置与go类型之间缺少的方法
+// 没有注册的服务只检查配置本身
+func (m *Mgr) Validate() error {
+	m.mu.Lock()
+	rc := m.rawConfig
+	m.mu.Unlock()
+	if rc == nil {
+		return nil
+	}
+
+	t := m.table.Load()
+	methods := make(map[string][]string)
+	for name, sci := range t.idSrvName {
+		srv, ok := t.services[sci.id]
+		if !ok {
+			continue
+		}
+		methods[name] = sortedKeys(srv.handlers())
+	}
+
+	issues := ValidateConfig(rc, methods)
+	if len(issues) == 0 {
+		return nil
+	}
+	return issues
+}
+
+// ValidateConfig 检查配置，methods为服务名对应go类型的导出方法，没有的服务不检查方法
+func ValidateConfig(rc *config2.RawServicesConfig, methods map[string][]string) config2.Issues {
+	issues := append(config2.Issues(nil), rc.Issues...)
+	report := func(pos config2.Position, format string, args ...interface{}) {
+		issues = append(issues, config2.Issue{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	srvNames := make(map[string]config2.Position)
+	srvIDs := make(map[int64]*config2.RawServiceConfig)
+	for _, s := range rc.Services {
+		if s.Name == "" {
+			report(s.Pos, "service id %d has no name", s.ID)
+		} else if first, ok := srvNames[s.FullName()]; ok {
+			report(s.Pos, "duplicate service name %s, first at %s", s.FullName(), first)
+		} else {
+			srvNames[s.FullName()] = s.Pos
+		}
+		// 带版本的服务名以"."分隔服务名与版本
+		if strings.Contains(s.Version, ".") {
+			report(s.Pos, "service %s version %s contains '.'", s.Name, s.Version)
+		}
+
+		switch {
+		case s.ID < 0 || s.ID > int64(^common2.SrvID(0)):
+			report(s.Pos, "service %s id %d out of range [0, %d]", s.Name, s.ID, ^common2.SrvID(0))
+		case isReservedService(s.Name, common2.SrvID(s.ID)):
+			report(s.Pos, "service %s id %d is reserved, ids from %d are for builtin services", s.Name, s.ID, ReservedSrvIDStart)
+		}
+		if first, ok := srvIDs[s.ID]; ok {
+			report(s.Pos, "duplicate service id %d of %s, first used by %s at %s", s.ID, s.FullName(), first.FullName(), first.Pos)
+		} else {
+			srvIDs[s.ID] = s
+		}
+
+		issues = append(issues, validateMethods(s, methods)...)
+	}
+
+	// 别名不能与服务名或者其他别名重复
+	aliases := make(map[string]*config2.RawServiceConfig)
+	for _, s := range rc.Services {
+		for _, alias := range s.Aliases {
+			if pos, ok := srvNames[alias]; ok {
+				report(s.Pos, "alias %s of %s conflicts with service at %s", alias, s.FullName(), pos)
+			} else if first, ok := aliases[alias]; ok {
+				report(s.Pos, "duplicate alias %s of %s, first used by %s at %s", alias, s.FullName(), first.FullName(), first.Pos)
+			} else if isReservedService(alias, 0) {
+				report(s.Pos, "alias %s of %s is reserved", alias, s.FullName())
+			} else {
+				aliases[alias] = s
+			}
+		}
+	}
+
+	return issues
+}
+
+func validateMethods(s *config2.RawServiceConfig, methods map[string][]string) config2.Issues {
+	var issues config2.Issues
+	report := func(pos config2.Position, format string, args ...interface{}) {
+		issues = append(issues, config2.Issue{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	srvName := s.FullName()
+	goNames, hasType := methods[srvName]
+
+	names := make(map[string]*config2.RawMethodConfig)
+	ids := make(map[int64]*config2.RawMethodConfig)
+	for _, m := range s.Methods {
+		if first, ok := names[m.Name]; ok {
+			report(m.Pos, "duplicate method %s.%s, first at %s", srvName, m.Name, first.Pos)
+		} else {
+			names[m.Name] = m
+		}
+
+		if m.ID > int64(^common2.MethodID(0)) {
+			report(m.Pos, "method %s.%s id %d out of range [0, %d]", srvName, m.Name, m.ID, ^common2.MethodID(0))
+		}
+		if m.ID >= 0 {
+			if first, ok := ids[m.ID]; ok {
+				report(m.Pos, "duplicate method id %d of %s.%s, first used by %s at %s", m.ID, srvName, m.Name, first.Name, first.Pos)
+			} else {
+				ids[m.ID] = m
+			}
+		}
+	}
+
+	// 方法别名指向配置中的方法，go方法可以使用别名
+	aliasOf := make(map[string]string, len(s.MethodAliases))
+	for _, alias := range sortedKeys(s.MethodAliases) {
+		target := s.MethodAliases[alias]
+		if _, ok := names[alias]; ok {
+			report(s.Pos, "method alias %s.%s conflicts with method", srvName, alias)
+		} else if _, ok := names[target]; !ok {
+			report(s.Pos, "method alias %s.%s of %s not found in config", srvName, alias, target)
+		} else {
+			aliasOf[alias] = target
+		}
+	}
+	for _, mn := range sortedKeys(s.DeprecatedMethods) {
+		if _, ok := names[mn]; !ok {
+			report(s.Pos, "deprecated method %s.%s not found in config", srvName, mn)
+		}
+	}
+
+	if hasType {
+		implemented := make(map[string]bool, len(goNames))
+		for _, name := range goNames {
+			if target, ok := aliasOf[name]; ok {
+				name = target
+			}
+			implemented[name] = true
+		}
+		for _, m := range s.Methods {
+			if !implemented[m.Name] {
+				report(m.Pos, "method %s.%s not found on go type", srvName, m.Name)
+			}
+		}
+	}
+
+	// 缺少的方法按名称排序输出
+	missing := make([]string, 0)
+	for _, name := range goNames {
+		_, configured := names[name]
+		_, aliased := aliasOf[name]
+		if !configured && !aliased {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		report(s.Pos, "exported method %s.%s missing from config", srvName, name)
+	}
+
+	return issues
+}
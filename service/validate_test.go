@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	config2 "learn/irpc/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	mgr := NewServiceMgr("../config/services.yml")
+	err := mgr.Register(&ServerTest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mgr.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "services.yml")
+	err = os.WriteFile(path, []byte(`services:
+  - id: 1
+    name: "ServerTest"
+    methods:
+      Add: 1
+      Sub: 1
+  - id: 65534
+    name: "Reserved"
+    methods:
+      Do: 1
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mgr = NewServiceMgr(path)
+	err = mgr.Register(&ServerTest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mgr.Validate()
+	var issues config2.Issues
+	if !errors.As(err, &issues) {
+		t.Fatalf("want issues got %v", err)
+	}
+
+	// 服务的位置为name所在行
+	want := []config2.Issue{
+		{Pos: config2.Position{File: path, Line: 6}, Msg: "duplicate method id 1 of ServerTest.Sub, first used by Add at " + path + ":5"},
+		{Pos: config2.Position{File: path, Line: 6}, Msg: "method ServerTest.Sub not found on go type"},
+		{Pos: config2.Position{File: path, Line: 3}, Msg: "exported method ServerTest.AddWithStruct missing from config"},
+		{Pos: config2.Position{File: path, Line: 8}, Msg: "service Reserved id 65534 is reserved, ids from 65280 are for builtin services"},
+	}
+	if len(issues) != len(want) {
+		t.Fatalf("wrong issues\n%v", err)
+	}
+	for i := range want {
+		if issues[i] != want[i] {
+			t.Fatalf("issue %d want %s got %s", i, want[i], issues[i])
+		}
+	}
+}